@@ -14,6 +14,8 @@ import (
     "sync"
     "sync/atomic"
     "time"
+
+    "filedo/fileduplicates"
 )
 
 const (
@@ -29,6 +31,7 @@ const (
     modeQuick checkMode = iota
     modeBalanced
     modeDeep
+    modeFull
 )
 
 type checkConfig struct {
@@ -53,6 +56,12 @@ type checkConfig struct {
     report        string // "", "csv", "json"
     reportFile    string
     hddSleepMs    int
+    slowChunk     time.Duration // chunk reads slower than this are listed in the report
+    // integrity check: full reads compared with stored checksums
+    verify        bool
+    baseline      bool
+    hash          string // "" = md5, or the algorithm of an existing database
+    checksumDB    string // "" = CHECKSUM_DB_FILE at the root
     // single-reader and adaptive throttle
     singleReaderOverride int    // -1 auto, 0 force off, 1 force on
     ewmaAlpha            float64
@@ -105,25 +114,10 @@ func getEnvInt(name string, def int) int {
     return n
 }
 
-func parseExtSet(s string) map[string]bool {
-    if s == "" {
-        return nil
-    }
-    m := make(map[string]bool)
-    for _, p := range strings.Split(s, ",") {
-        e := strings.TrimSpace(strings.ToLower(p))
-        if e == "" {
-            continue
-        }
-        if !strings.HasPrefix(e, ".") {
-            e = "." + e
-        }
-        m[e] = true
-    }
-    return m
-}
+// parseExtSet shares its rules with the check-duplicates filters
+func parseExtSet(s string) map[string]bool { return fileduplicates.ParseExtSet(s) }
 
-func toBytesMBEnv(val float64) int64 { return int64(val * 1024.0 * 1024.0) }
+func toBytesMBEnv(val float64) int64 { return fileduplicates.MBToBytes(val) }
 
 func detectMode() checkMode {
     m := strings.ToLower(os.Getenv("FILEDO_CHECK_MODE"))
@@ -132,6 +126,8 @@ func detectMode() checkMode {
         return modeBalanced
     case "deep":
         return modeDeep
+    case "full":
+        return modeFull
     default:
         return modeQuick
     }
@@ -201,6 +197,11 @@ func loadCheckConfig(root string) *checkConfig {
         report:        strings.ToLower(os.Getenv("FILEDO_CHECK_REPORT")),
         reportFile:    os.Getenv("FILEDO_CHECK_REPORT_FILE"),
         hddSleepMs:    getEnvInt("FILEDO_CHECK_HDD_SLEEP_MS", 0),
+        slowChunk:     time.Duration(getEnvFloat("FILEDO_CHECK_SLOW_CHUNK_MS", DEFAULT_SLOW_CHUNK_MS) * float64(time.Millisecond)),
+        verify:        getEnvInt("FILEDO_CHECK_VERIFY", 0) == 1,
+        baseline:      getEnvInt("FILEDO_CHECK_BASELINE", 0) == 1,
+        hash:          os.Getenv("FILEDO_CHECK_HASH"),
+        checksumDB:    os.Getenv("FILEDO_CHECK_CHECKSUM_DB"),
     }
     // single-reader override: -1 auto (default), 0 force off, 1 force on
     if v := os.Getenv("FILEDO_CHECK_SINGLE_READER"); strings.TrimSpace(v) != "" {
@@ -236,7 +237,7 @@ func HandleCheckArgs(root string, args []string) error {
     warmIdle := fs.Float64("warmup-idle", math.NaN(), "Idle reset for warmup in seconds (FILEDO_CHECK_WARMUP_IDLE_RESET_SECONDS)")
     workers := fs.Int("workers", -1, "Worker count (auto if not set) (FILEDO_CHECK_WORKERS)")
     bufKB := fs.Int("buf-kb", -1, "Read buffer size in KB (FILEDO_CHECK_BUF_KB)")
-    mode := fs.String("mode", "", "Mode: quick|balanced|deep (FILEDO_CHECK_MODE)")
+    mode := fs.String("mode", "", "Mode: quick|balanced|deep|full (FILEDO_CHECK_MODE)")
     balancedMinMB := fs.Int("balanced-min-mb", -1, "Min size in MB for mid-file probe (FILEDO_CHECK_BALANCED_MIN_MB)")
     minMB := fs.Float64("min-mb", math.NaN(), "Min file size in MB to include (FILEDO_CHECK_MIN_MB)")
     maxMB := fs.Float64("max-mb", math.NaN(), "Max file size in MB to include (FILEDO_CHECK_MAX_MB)")
@@ -253,6 +254,7 @@ func HandleCheckArgs(root string, args []string) error {
     report := fs.String("report", "", "Report format: csv|json (FILEDO_CHECK_REPORT)")
     reportFile := fs.String("report-file", "", "Report file path (FILEDO_CHECK_REPORT_FILE)")
     hddSleepMs := fs.Int("hdd-sleep-ms", -1, "Fixed inter-file sleep for HDD in ms (FILEDO_CHECK_HDD_SLEEP_MS)")
+    slowMs := fs.Float64("slow-ms", math.NaN(), "Chunk read latency in ms above which the chunk offset is reported (FILEDO_CHECK_SLOW_CHUNK_MS)")
     singleReader := fs.String("single-reader", "", "auto|on|off (FILEDO_CHECK_SINGLE_READER)")
     ewmaAlpha := fs.Float64("ewma-alpha", math.NaN(), "EWMA alpha [0..1] (FILEDO_CHECK_EWMA_ALPHA)")
     ewmaHigh := fs.Float64("ewma-high-frac", math.NaN(), "High fraction of threshold (FILEDO_CHECK_EWMA_HIGH_FRAC)")
@@ -260,6 +262,10 @@ func HandleCheckArgs(root string, args []string) error {
     maxSleep := fs.Int("max-sleep-ms", -1, "Max adaptive sleep in ms (FILEDO_CHECK_MAX_SLEEP_MS)")
     sleepStep := fs.Int("sleep-step-ms", -1, "Adaptive sleep step in ms (FILEDO_CHECK_SLEEP_STEP_MS)")
     goodList := fs.String("good-list", "", "Path to good files list (FILEDO_CHECK_GOODLIST)")
+    verify := fs.Bool("verify", false, "Read files fully and compare checksums with the database or .md5/.sha256 files (FILEDO_CHECK_VERIFY=1)")
+    baseline := fs.Bool("baseline", false, "Read files fully and record their checksums (FILEDO_CHECK_BASELINE=1)")
+    hashAlg := fs.String("hash", "", "Checksum algorithm for --baseline: md5|sha256|xxh3|blake3 (FILEDO_CHECK_HASH)")
+    checksumDB := fs.String("checksum-db", "", "Checksum database path, default .filedo_checksums.json in the folder (FILEDO_CHECK_CHECKSUM_DB)")
 
     if err := fs.Parse(args); err != nil {
         return err
@@ -311,6 +317,8 @@ func HandleCheckArgs(root string, args []string) error {
             os.Setenv("FILEDO_CHECK_REPORT_FILE", *reportFile)
         case "hdd-sleep-ms":
             os.Setenv("FILEDO_CHECK_HDD_SLEEP_MS", fmt.Sprintf("%d", *hddSleepMs))
+        case "slow-ms":
+            os.Setenv("FILEDO_CHECK_SLOW_CHUNK_MS", fmt.Sprintf("%g", *slowMs))
         case "single-reader":
             v := strings.ToLower(strings.TrimSpace(*singleReader))
             switch v {
@@ -336,6 +344,14 @@ func HandleCheckArgs(root string, args []string) error {
             os.Setenv("FILEDO_CHECK_SLEEP_STEP_MS", fmt.Sprintf("%d", *sleepStep))
         case "good-list":
             os.Setenv("FILEDO_CHECK_GOODLIST", *goodList)
+        case "verify":
+            if *verify { os.Setenv("FILEDO_CHECK_VERIFY", "1") } else { os.Setenv("FILEDO_CHECK_VERIFY", "0") }
+        case "baseline":
+            if *baseline { os.Setenv("FILEDO_CHECK_BASELINE", "1") } else { os.Setenv("FILEDO_CHECK_BASELINE", "0") }
+        case "hash":
+            os.Setenv("FILEDO_CHECK_HASH", *hashAlg)
+        case "checksum-db":
+            os.Setenv("FILEDO_CHECK_CHECKSUM_DB", *checksumDB)
         }
     }
 
@@ -352,6 +368,9 @@ func HandleCheckArgs(root string, args []string) error {
 // CheckFolder scans all files under root and performs a fast read test.
 // If a file's first read takes > 2s (except a one-time warm-up up to 10s),
 // it is marked as damaged and appended to skip_files.list immediately.
+// With --verify/--baseline files are also read fully and their checksums
+// compared with, or recorded in, the checksum database (see checkVerifier).
+// The good list is not used then, since a file that read fine can still rot.
 func CheckFolder(root string) error {
     info, err := os.Stat(root)
     if err != nil {
@@ -374,6 +393,16 @@ func CheckFolder(root string) error {
     }
     defer damaged.Close()
 
+    var verifier *checkVerifier
+    if cfg.verify || cfg.baseline {
+        if verifier, err = newCheckVerifier(root, cfg); err != nil {
+            return err
+        }
+        if !cfg.quiet {
+            fmt.Println(verifier.describe())
+        }
+    }
+
     // Load good files list (check_files.list) with optional override via env
     wd, _ := os.Getwd()
     goodFile := os.Getenv("FILEDO_CHECK_GOODLIST")
@@ -400,6 +429,7 @@ func CheckFolder(root string) error {
         f.Close()
     }
     goodHas := func(p string) bool {
+        if verifier != nil { return false }
         key := normGood(p)
         goodMu.Lock()
         _, ok := goodSet[key]
@@ -407,7 +437,7 @@ func CheckFolder(root string) error {
         return ok
     }
     goodAppend := func(p string) {
-        if p == "" { return }
+        if p == "" || verifier != nil { return }
         key := normGood(p)
         goodMu.Lock()
         if goodSet[key] {
@@ -430,10 +460,13 @@ func CheckFolder(root string) error {
         }
     }
 
+    // Chunk read timings of the whole run
+    latency := newLatencyRun(cfg.slowChunk, root)
+
     // Optional report
     var rep *reportWriter
     if cfg.report != "" {
-        if r, e := newReportWriter(cfg.report, cfg.reportFile); e == nil {
+        if r, e := newReportWriter(cfg.report, cfg.reportFile, latency); e == nil {
             rep = r
             defer rep.Close()
         } else {
@@ -484,6 +517,7 @@ func CheckFolder(root string) error {
                 if cfg.includeExt != nil && !cfg.includeExt[ext] { return nil }
                 if cfg.excludeExt != nil && cfg.excludeExt[ext] { return nil }
             }
+            if verifier != nil && verifier.isDatabase(p) { return nil }
 
             // Skip if previously checked good
             if goodHas(p) {
@@ -522,6 +556,7 @@ func CheckFolder(root string) error {
                 if cfg.includeExt != nil && !cfg.includeExt[ext] { return nil }
                 if cfg.excludeExt != nil && cfg.excludeExt[ext] { return nil }
             }
+            if verifier != nil && verifier.isDatabase(p) { return nil }
             // Skip previously good and damaged
             if goodHas(p) { return nil }
             if damaged.ShouldSkipFile(p) { return nil }
@@ -613,7 +648,7 @@ func CheckFolder(root string) error {
                     lastDamaged.Store(p)
                     atomic.AddInt64(&damagedFiles, 1)
                     atomic.AddInt64(&processedFiles, 1)
-                    if rep != nil { rep.Write(p, size, 0, "open-error") }
+                    if rep != nil { rep.Write(p, size, 0, "open-error", nil) }
                     continue
                 }
 
@@ -626,6 +661,7 @@ func CheckFolder(root string) error {
                     }
                 }(f)
 
+                lat := newChunkStats(cfg.slowChunk)
                 var firstElapsed time.Duration
                 var status string
                 var damagedMark bool
@@ -649,6 +685,7 @@ func CheckFolder(root string) error {
                     t0 := time.Now()
                     n, rerr := f.Read(buf)
                     d := time.Since(t0)
+                    lat.add(off, n, d)
                     if n > 0 { atomic.AddInt64(&totalReadBytes, int64(n)) }
                     if rerr != nil && rerr.Error() != "EOF" { return d, true }
                     if d > cfg.threshold {
@@ -685,7 +722,21 @@ func CheckFolder(root string) error {
                     }
                 }
 
-                if !damagedMark && cfg.mode != modeQuick {
+                if !damagedMark && cfg.mode == modeFull && verifier == nil {
+                    // Read the rest of the file chunk by chunk (the full
+                    // read of --verify/--baseline does it otherwise)
+                    for off := int64(len(buf)); off < size && !ih.IsInterrupted(); off += int64(len(buf)) {
+                        if e, bad := probe(off); bad {
+                            damaged.LogDamagedFile(p, "check-delay", size, 1, fmt.Sprintf(">%.1fs read delay at offset %d (%.1fs)", cfg.threshold.Seconds(), off, e.Seconds()))
+                            lastDamaged.Store(p)
+                            damagedMark = true
+                            status = "delay-chunk"
+                            break
+                        }
+                    }
+                }
+
+                if !damagedMark && (cfg.mode == modeBalanced || cfg.mode == modeDeep) {
                     minBytes := cfg.minSizeBytes
                     if minBytes == 0 { minBytes = toBytesMBEnv(float64(cfg.balancedMinMB)) }
                     if size >= minBytes {
@@ -710,6 +761,24 @@ func CheckFolder(root string) error {
                 close(done)
                 f.Close()
 
+                if !damagedMark && verifier != nil {
+                    // The full read times every chunk on its own, so the probes are
+                    // not counted twice
+                    lat = newChunkStats(cfg.slowChunk)
+                    vs, verr := verifier.check(ih.Context(), p, buf, &totalReadBytes, lat)
+                    if vs == "" { return } // Interrupted
+                    status = vs
+                    if verr != nil {
+                        reason, detail := "check-read-error", fmt.Sprintf("read error: %v", verr)
+                        if slow, ok := verr.(*slowChunkError); ok {
+                            reason, detail = "check-delay", fmt.Sprintf(">%.1fs read delay at offset %d (%.1fs)", cfg.threshold.Seconds(), slow.off, slow.elapsed.Seconds())
+                        }
+                        damaged.LogDamagedFile(p, reason, size, 1, detail)
+                        lastDamaged.Store(p)
+                        damagedMark = true
+                    }
+                }
+
                 // EWMA update for adaptive throttling
                 if firstElapsed > 0 {
                     if ewma == 0 {
@@ -729,13 +798,15 @@ func CheckFolder(root string) error {
                     if ewma < low { sleepMs = int(math.Max(0, float64(sleepMs-step))) }
                 }
 
+                latency.addFile(job.vol, p, lat)
                 if damagedMark {
                     atomic.AddInt64(&damagedFiles, 1)
                     atomic.AddInt64(&processedFiles, 1)
-                    if rep != nil { rep.Write(p, size, firstElapsed, status) }
+                    if rep != nil { rep.Write(p, size, firstElapsed, status, lat) }
                 } else {
                     atomic.AddInt64(&processedFiles, 1)
-                    if rep != nil { rep.Write(p, size, firstElapsed, "ok") }
+                    if status == "" { status = "ok" }
+                    if rep != nil { rep.Write(p, size, firstElapsed, status, lat) }
                     goodAppend(p)
                 }
 
@@ -782,11 +853,12 @@ func CheckFolder(root string) error {
                         lastDamaged.Store(p)
                         atomic.AddInt64(&damagedFiles, 1)
                         atomic.AddInt64(&processedFiles, 1)
-                        if rep != nil { rep.Write(p, size, 0, "open-error") }
+                        if rep != nil { rep.Write(p, size, 0, "open-error", nil) }
                         continue
                     }
                     done := make(chan struct{})
                     go func(ff *os.File) { select { case <-ih.Context().Done(): ff.Close(); case <-done: } }(f)
+                    lat := newChunkStats(cfg.slowChunk)
                     var firstElapsed time.Duration
                     var status string
                     var damagedMark bool
@@ -803,6 +875,7 @@ func CheckFolder(root string) error {
                         t0 := time.Now()
                         n, rerr := f.Read(buf)
                         d := time.Since(t0)
+                        lat.add(off, n, d)
                         if n > 0 { atomic.AddInt64(&totalReadBytes, int64(n)) }
                         if rerr != nil && rerr.Error() != "EOF" { return d, true }
                         if d > cfg.threshold {
@@ -829,7 +902,18 @@ func CheckFolder(root string) error {
                             if atomic.LoadInt32(&warmupUsed) == 0 && e1 <= cfg.warmupGrace { atomic.StoreInt32(&warmupUsed, 1) } else { damaged.LogDamagedFile(p, "check-delay", size, 1, fmt.Sprintf(">%.1fs read delay (%.1fs)", cfg.threshold.Seconds(), e1.Seconds())); lastDamaged.Store(p); damagedMark = true; status = "delay-first" }
                         }
                     }
-                    if !damagedMark && cfg.mode != modeQuick {
+                    if !damagedMark && cfg.mode == modeFull && verifier == nil {
+                        for off := int64(len(buf)); off < size && !ih.IsInterrupted(); off += int64(len(buf)) {
+                            if e, bad := probe(off); bad {
+                                damaged.LogDamagedFile(p, "check-delay", size, 1, fmt.Sprintf(">%.1fs read delay at offset %d (%.1fs)", cfg.threshold.Seconds(), off, e.Seconds()))
+                                lastDamaged.Store(p)
+                                damagedMark = true
+                                status = "delay-chunk"
+                                break
+                            }
+                        }
+                    }
+                    if !damagedMark && (cfg.mode == modeBalanced || cfg.mode == modeDeep) {
                         minBytes := cfg.minSizeBytes
                         if minBytes == 0 { minBytes = toBytesMBEnv(float64(cfg.balancedMinMB)) }
                         if size >= minBytes {
@@ -851,7 +935,26 @@ func CheckFolder(root string) error {
                     }
                     close(done)
                     f.Close()
-                    if damagedMark { atomic.AddInt64(&damagedFiles, 1); atomic.AddInt64(&processedFiles, 1); if rep != nil { rep.Write(p, size, firstElapsed, status) } } else { atomic.AddInt64(&processedFiles, 1); if rep != nil { rep.Write(p, size, firstElapsed, "ok") }; goodAppend(p) }
+                    if !damagedMark && verifier != nil {
+                        // The full read times every chunk on its own, so the probes are
+                        // not counted twice
+                        lat = newChunkStats(cfg.slowChunk)
+                        vs, verr := verifier.check(ih.Context(), p, buf, &totalReadBytes, lat)
+                        if vs == "" { return } // Interrupted
+                        status = vs
+                        if verr != nil {
+                            reason, detail := "check-read-error", fmt.Sprintf("read error: %v", verr)
+                            if slow, ok := verr.(*slowChunkError); ok {
+                                reason, detail = "check-delay", fmt.Sprintf(">%.1fs read delay at offset %d (%.1fs)", cfg.threshold.Seconds(), slow.off, slow.elapsed.Seconds())
+                            }
+                            damaged.LogDamagedFile(p, reason, size, 1, detail)
+                            lastDamaged.Store(p)
+                            damagedMark = true
+                        }
+                    }
+                    if status == "" { status = "ok" }
+                    latency.addFile(job.vol, p, lat)
+                    if damagedMark { atomic.AddInt64(&damagedFiles, 1); atomic.AddInt64(&processedFiles, 1); if rep != nil { rep.Write(p, size, firstElapsed, status, lat) } } else { atomic.AddInt64(&processedFiles, 1); if rep != nil { rep.Write(p, size, firstElapsed, status, lat) }; goodAppend(p) }
                     if cfg.maxFiles > 0 && atomic.LoadInt64(&processedFiles) >= cfg.maxFiles {
                         stopMu.Lock()
                         atomic.StoreInt32(&stopFlag, 1)
@@ -883,36 +986,49 @@ func CheckFolder(root string) error {
         fmt.Printf("\nCHECK completed: total=%d, skipped(damaged-before)=%d, newly-damaged=%d\n",
             atomic.LoadInt64(&totalFiles), atomic.LoadInt64(&skippedFiles), atomic.LoadInt64(&damagedFiles))
     }
+    if verifier != nil {
+        verifier.finish(cfg)
+    }
+    if !cfg.quiet {
+        latency.printSummary()
+    }
     return nil
 }
 
 // Reporting
+// The JSON report stays an array of file entries; the histogram and slow
+// regions of the run go to a "_latency.json" file next to it.
 type reportWriter struct {
-    kind string
-    f    *os.File
-    n    int
+    kind    string
+    path    string
+    f       *os.File
+    n       int
+    mu      sync.Mutex
+    latency *latencyRun
 }
 
-func newReportWriter(kind, path string) (*reportWriter, error) {
+func newReportWriter(kind, path string, latency *latencyRun) (*reportWriter, error) {
     f, err := os.Create(path)
     if err != nil { return nil, err }
-    w := &reportWriter{kind: kind, f: f}
+    w := &reportWriter{kind: kind, path: path, f: f, latency: latency}
     if kind == "csv" {
-        fmt.Fprintln(f, "path,size,first_read_ms,status")
+        fmt.Fprintln(f, "path,size,first_read_ms,status,chunks,min_ms,p50_ms,p99_ms,max_ms,slow_chunks,slow_offsets")
     } else if kind == "json" {
-        fmt.Fprintln(f, "[")
+        fmt.Fprint(f, "[")
     }
     return w, nil
 }
 
-func (w *reportWriter) Write(path string, size int64, elapsed time.Duration, status string) {
+func (w *reportWriter) Write(path string, size int64, elapsed time.Duration, status string, lat *chunkStats) {
     if w == nil || w.f == nil { return }
+    w.mu.Lock()
+    defer w.mu.Unlock()
     ms := float64(elapsed.Milliseconds())
     if w.kind == "csv" {
-        fmt.Fprintf(w.f, "%q,%d,%.1f,%q\n", path, size, ms, status)
+        fmt.Fprintf(w.f, "%q,%d,%.1f,%q,%s\n", path, size, ms, status, lat.csvFields())
     } else if w.kind == "json" {
-        if w.n > 0 { fmt.Fprintln(w.f, ",") }
-        fmt.Fprintf(w.f, "  {\n    \"path\": %q,\n    \"size\": %d,\n    \"first_read_ms\": %.1f,\n    \"status\": %q\n  }", path, size, ms, status)
+        if w.n > 0 { fmt.Fprint(w.f, ",") }
+        fmt.Fprintf(w.f, "\n  {\n    \"path\": %q,\n    \"size\": %d,\n    \"first_read_ms\": %.1f,\n    \"status\": %q%s\n  }", path, size, ms, status, lat.jsonFields())
         w.n++
     }
 }
@@ -921,10 +1037,23 @@ func (w *reportWriter) Close() {
     if w == nil || w.f == nil { return }
     if w.kind == "json" {
         fmt.Fprintln(w.f, "\n]")
+        if w.latency != nil {
+            if data, err := w.latency.reportJSON("", "  "); err == nil {
+                if err := os.WriteFile(latencyReportPath(w.path), append(data, '\n'), 0644); err != nil {
+                    fmt.Printf("Warning: cannot write latency report: %v\n", err)
+                }
+            }
+        }
     }
     w.f.Close()
 }
 
+// latencyReportPath is the latency file of a JSON report: rep.json -> rep_latency.json
+func latencyReportPath(reportPath string) string {
+    ext := filepath.Ext(reportPath)
+    return strings.TrimSuffix(reportPath, ext) + "_latency" + ext
+}
+
 // Resume state
 type checkState struct {
     LastProcessedPath string    `json:"lastProcessedPath"`
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DEFAULT_SLOW_CHUNK_MS is the chunk read latency above which check records
+// the chunk's offset. It is well below the damage threshold: a disk that
+// retries a sector shows up here long before a read takes seconds.
+const DEFAULT_SLOW_CHUNK_MS = 200
+
+// slowRegionGap merges slow chunks of a file into one region when they are
+// at most this far apart
+const slowRegionGap = 1024 * 1024
+
+// maxSlowOffsetsPerFile caps the offsets listed per file in the report; the
+// slow regions still cover all of them
+const maxSlowOffsetsPerFile = 100
+
+// latencyBucketBounds are the upper bounds of the run-wide histogram; the
+// last bucket holds everything slower
+var latencyBucketBounds = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	20 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2 * time.Second, 5 * time.Second,
+}
+
+// chunkStats collects the read timings of one file
+type chunkStats struct {
+	limit time.Duration
+	times []time.Duration
+	slow  []slowChunk
+}
+
+type slowChunk struct {
+	offset  int64
+	length  int64
+	elapsed time.Duration
+}
+
+func newChunkStats(limit time.Duration) *chunkStats {
+	return &chunkStats{limit: limit}
+}
+
+// add records one read of n bytes at off
+func (c *chunkStats) add(off int64, n int, d time.Duration) {
+	if c == nil {
+		return
+	}
+	c.times = append(c.times, d)
+	if c.limit > 0 && d > c.limit {
+		c.slow = append(c.slow, slowChunk{offset: off, length: int64(n), elapsed: d})
+	}
+}
+
+// percentiles returns min, p50, p99 and max (nearest rank)
+func (c *chunkStats) percentiles() (minD, p50, p99, maxD time.Duration) {
+	if c == nil || len(c.times) == 0 {
+		return 0, 0, 0, 0
+	}
+	sorted := append([]time.Duration(nil), c.times...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	n := len(sorted)
+	return sorted[0], sorted[(n-1)*50/100], sorted[(n-1)*99/100], sorted[n-1]
+}
+
+// slowOffsets are the sorted offsets of the slow chunks, at most
+// maxSlowOffsetsPerFile of them
+func (c *chunkStats) slowOffsets() []int64 {
+	offsets := []int64{}
+	for _, s := range c.slow {
+		offsets = append(offsets, s.offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	if len(offsets) > maxSlowOffsetsPerFile {
+		offsets = offsets[:maxSlowOffsetsPerFile]
+	}
+	return offsets
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// csvFields are the latency columns of a CSV report row
+func (c *chunkStats) csvFields() string {
+	if c == nil || len(c.times) == 0 {
+		return `0,,,,,0,""`
+	}
+	minD, p50, p99, maxD := c.percentiles()
+	var offsets []string
+	for _, off := range c.slowOffsets() {
+		offsets = append(offsets, strconv.FormatInt(off, 10))
+	}
+	return fmt.Sprintf("%d,%.3f,%.3f,%.3f,%.3f,%d,%q", len(c.times), durationMs(minD), durationMs(p50), durationMs(p99), durationMs(maxD),
+		len(c.slow), strings.Join(offsets, ";"))
+}
+
+// jsonFields are the latency fields of a JSON report entry, with a leading
+// comma
+func (c *chunkStats) jsonFields() string {
+	if c == nil || len(c.times) == 0 {
+		return ",\n    \"chunks\": 0"
+	}
+	minD, p50, p99, maxD := c.percentiles()
+	data, _ := json.Marshal(c.slowOffsets())
+	return fmt.Sprintf(",\n    \"chunks\": %d,\n    \"latency_ms\": {\"min\": %.3f, \"p50\": %.3f, \"p99\": %.3f, \"max\": %.3f},\n    \"slow_chunks\": %d,\n    \"slow_offsets\": %s",
+		len(c.times), durationMs(minD), durationMs(p50), durationMs(p99), durationMs(maxD), len(c.slow), data)
+}
+
+// latencyRun aggregates the chunk timings of a whole check: a histogram and
+// the slow regions of each volume
+type latencyRun struct {
+	limit time.Duration
+	root  string // Volume name of paths without a drive letter
+
+	mu         sync.Mutex
+	buckets    []int64
+	chunks     int64
+	slowChunks int64
+	volumes    map[string]*volumeLatency
+}
+
+type volumeLatency struct {
+	files      int
+	slowChunks int
+	regions    []slowRegion
+}
+
+// slowRegion is a run of slow chunks within a file
+type slowRegion struct {
+	Path   string  `json:"path"`
+	Start  int64   `json:"start"`
+	End    int64   `json:"end"`
+	Chunks int     `json:"chunks"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+func newLatencyRun(limit time.Duration, root string) *latencyRun {
+	return &latencyRun{limit: limit, root: root, buckets: make([]int64, len(latencyBucketBounds)+1), volumes: make(map[string]*volumeLatency)}
+}
+
+// addFile adds the timings of a checked file
+func (r *latencyRun) addFile(volume, path string, c *chunkStats) {
+	if r == nil || c == nil || len(c.times) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range c.times {
+		i := sort.Search(len(latencyBucketBounds), func(i int) bool { return d < latencyBucketBounds[i] })
+		r.buckets[i]++
+	}
+	r.chunks += int64(len(c.times))
+	if len(c.slow) == 0 {
+		return
+	}
+	r.slowChunks += int64(len(c.slow))
+
+	if volume == "" {
+		volume = r.root
+	}
+	v := r.volumes[volume]
+	if v == nil {
+		v = &volumeLatency{}
+		r.volumes[volume] = v
+	}
+	v.files++
+	v.slowChunks += len(c.slow)
+	slow := append([]slowChunk(nil), c.slow...)
+	sort.Slice(slow, func(i, j int) bool { return slow[i].offset < slow[j].offset })
+	var cur *slowRegion
+	for _, s := range slow {
+		if cur != nil && s.offset <= cur.End+slowRegionGap {
+			if end := s.offset + s.length; end > cur.End {
+				cur.End = end
+			}
+			cur.Chunks++
+			if ms := durationMs(s.elapsed); ms > cur.MaxMs {
+				cur.MaxMs = ms
+			}
+			continue
+		}
+		v.regions = append(v.regions, slowRegion{Path: path, Start: s.offset, End: s.offset + s.length, Chunks: 1, MaxMs: durationMs(s.elapsed)})
+		cur = &v.regions[len(v.regions)-1]
+	}
+}
+
+// latencyReport is the "latency" section of the JSON check report
+type latencyReport struct {
+	SlowChunkMs float64          `json:"slow_chunk_ms"`
+	Chunks      int64            `json:"chunks"`
+	SlowChunks  int64            `json:"slow_chunks"`
+	Histogram   []latencyBucket  `json:"histogram"`
+	SlowRegions []volumeSlowness `json:"slow_regions"`
+}
+
+type latencyBucket struct {
+	Range string `json:"range"`
+	Count int64  `json:"count"`
+}
+
+type volumeSlowness struct {
+	Volume     string       `json:"volume"`
+	Files      int          `json:"files"`
+	SlowChunks int          `json:"slow_chunks"`
+	Regions    []slowRegion `json:"regions"`
+}
+
+// bucketRange labels histogram bucket i, e.g. "5-10ms"
+func bucketRange(i int) string {
+	label := func(d time.Duration) string {
+		if d >= time.Second {
+			return fmt.Sprintf("%gs", d.Seconds())
+		}
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	switch {
+	case i == 0:
+		return "<" + label(latencyBucketBounds[0])
+	case i == len(latencyBucketBounds):
+		return ">=" + label(latencyBucketBounds[i-1])
+	}
+	lower, upper := label(latencyBucketBounds[i-1]), label(latencyBucketBounds[i])
+	if (latencyBucketBounds[i-1] >= time.Second) == (latencyBucketBounds[i] >= time.Second) {
+		lower = strings.TrimRight(lower, "ms") // Same unit: "5-10ms"
+	}
+	return lower + "-" + upper
+}
+
+// report builds the JSON section; regions are sorted by path and offset
+func (r *latencyRun) report() latencyReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep := latencyReport{SlowChunkMs: durationMs(r.limit), Chunks: r.chunks, SlowChunks: r.slowChunks, SlowRegions: []volumeSlowness{}}
+	for i, n := range r.buckets {
+		rep.Histogram = append(rep.Histogram, latencyBucket{Range: bucketRange(i), Count: n})
+	}
+	for name, v := range r.volumes {
+		regions := append([]slowRegion(nil), v.regions...)
+		sort.Slice(regions, func(i, j int) bool {
+			if regions[i].Path != regions[j].Path {
+				return regions[i].Path < regions[j].Path
+			}
+			return regions[i].Start < regions[j].Start
+		})
+		rep.SlowRegions = append(rep.SlowRegions, volumeSlowness{Volume: name, Files: v.files, SlowChunks: v.slowChunks, Regions: regions})
+	}
+	sort.Slice(rep.SlowRegions, func(i, j int) bool { return rep.SlowRegions[i].Volume < rep.SlowRegions[j].Volume })
+	return rep
+}
+
+// reportJSON is the indented JSON section, without HTML escaping of "<"
+func (r *latencyRun) reportJSON(prefix, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent(prefix, indent)
+	if err := enc.Encode(r.report()); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// printSummary prints the slow chunks of the run, worst regions first
+func (r *latencyRun) printSummary() {
+	rep := r.report()
+	if rep.SlowChunks == 0 {
+		return
+	}
+	fmt.Printf("Slow chunks (>%g ms): %d of %d reads\n", rep.SlowChunkMs, rep.SlowChunks, rep.Chunks)
+	const maxShow = 10
+	for _, v := range rep.SlowRegions {
+		fmt.Printf("  %s: %d slow chunks in %d files, %d regions\n", v.Volume, v.SlowChunks, v.Files, len(v.Regions))
+		worst := append([]slowRegion(nil), v.Regions...)
+		sort.Slice(worst, func(i, j int) bool { return worst[i].MaxMs > worst[j].MaxMs })
+		for i, g := range worst {
+			if i == maxShow {
+				fmt.Printf("    ... and %d more (see JSON report)\n", len(worst)-maxShow)
+				break
+			}
+			fmt.Printf("    %s @ %s-%s: %d chunks, max %.0f ms\n", g.Path, formatBytesShort(uint64(g.Start)), formatBytesShort(uint64(g.End)), g.Chunks, g.MaxMs)
+		}
+	}
+}
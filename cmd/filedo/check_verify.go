@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"filedo/fileduplicates"
+)
+
+// CHECKSUM_DB_FILE is the sidecar database written by "check --baseline" at
+// the root of the checked folder
+const CHECKSUM_DB_FILE = ".filedo_checksums.json"
+
+// CHECKSUM_DB_VERSION is the format version of the checksum database
+const CHECKSUM_DB_VERSION = 1
+
+// Report statuses of --verify and --baseline. A mismatch is silent
+// corruption, not a read problem, so it never goes to the damaged or skip
+// lists.
+const (
+	checkStatusVerified   = "verified"          // Checksum matches
+	checkStatusMismatch   = "checksum-mismatch" // Checksum differs (database: although size and mtime match)
+	checkStatusModified   = "modified"          // Size or mtime changed since the checksum was recorded
+	checkStatusNoChecksum = "no-checksum"       // Nothing recorded to verify against
+	checkStatusBaselined  = "baselined"         // Checksum recorded
+	checkStatusReadError  = "read-error"        // The full read failed
+	checkStatusSlowChunk  = "delay-chunk"       // A chunk of the full read took longer than the threshold
+)
+
+// slowChunkError is returned by the full read when a chunk took longer than
+// the threshold, also on a second try
+type slowChunkError struct {
+	off     int64
+	elapsed time.Duration
+}
+
+func (e *slowChunkError) Error() string {
+	return fmt.Sprintf("read delay at offset %d (%.1fs)", e.off, e.elapsed.Seconds())
+}
+
+// checksumDB is the sidecar database of a folder: the checksum, size and
+// mtime of each file when it was recorded
+type checksumDB struct {
+	Version   int                          `json:"version"`
+	Root      string                       `json:"root"`
+	Algorithm fileduplicates.HashAlgorithm `json:"algorithm"`
+	Updated   time.Time                    `json:"updated"`
+	Files     map[string]checksumEntry     `json:"files"` // By slash-separated relative path
+
+	path    string
+	mu      sync.Mutex
+	changed bool
+}
+
+type checksumEntry struct {
+	Size  int64     `json:"size"`
+	Mtime time.Time `json:"mtime"`
+	Hash  string    `json:"hash"`
+}
+
+// sidecarSum is a checksum found in a .md5/.sha256 (or MD5SUMS/SHA256SUMS)
+// file
+type sidecarSum struct {
+	algorithm fileduplicates.HashAlgorithm
+	hash      string
+}
+
+// checkVerifier reads whole files for "check --verify" and "check
+// --baseline". --verify compares with the database, or with checksum files
+// next to the data, and never changes anything; --baseline records every
+// file anew; both together verify the recorded files and record new and
+// modified ones, keeping the recorded checksum of mismatches.
+type checkVerifier struct {
+	root      string
+	verify    bool
+	baseline  bool
+	threshold time.Duration // Max delay of one chunk
+	db        *checksumDB
+
+	sumsMu sync.Mutex
+	sums   map[string]map[string]sidecarSum // Folder -> relative path -> checksum
+
+	mu         sync.Mutex
+	counts     map[string]int64
+	mismatches []string
+}
+
+// newCheckVerifier opens the checksum database of root. The algorithm of an
+// existing database wins unless --baseline asks for another one, which
+// starts a new database.
+func newCheckVerifier(root string, cfg *checkConfig) (*checkVerifier, error) {
+	algorithm := fileduplicates.HashMD5
+	if cfg.hash != "" {
+		a, ok := fileduplicates.ParseHashAlgorithm(cfg.hash)
+		if !ok {
+			return nil, fmt.Errorf("unknown hash algorithm: %s (allowed: md5|sha256|xxh3|blake3)", cfg.hash)
+		}
+		algorithm = a
+	}
+	path := cfg.checksumDB
+	if path == "" {
+		path = filepath.Join(root, CHECKSUM_DB_FILE)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	absRoot := root
+	if abs, err := filepath.Abs(root); err == nil {
+		absRoot = abs
+	}
+
+	db := &checksumDB{Version: CHECKSUM_DB_VERSION, Root: absRoot, Algorithm: algorithm, Files: make(map[string]checksumEntry), path: path}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		var loaded checksumDB
+		if err := json.Unmarshal(data, &loaded); err != nil || loaded.Version == 0 {
+			return nil, fmt.Errorf("%s is not a FileDO checksum database", path)
+		}
+		if loaded.Version > CHECKSUM_DB_VERSION {
+			return nil, fmt.Errorf("checksum database %s has version %d, this FileDO reads up to %d", path, loaded.Version, CHECKSUM_DB_VERSION)
+		}
+		if cfg.baseline && cfg.hash != "" && loaded.Algorithm != algorithm {
+			fmt.Printf("Checksum database %s uses %s; starting a new %s baseline\n", path, loaded.Algorithm, algorithm)
+			break
+		}
+		if loaded.Files == nil {
+			loaded.Files = make(map[string]checksumEntry)
+		}
+		db.Algorithm, db.Files, db.Updated = loaded.Algorithm, loaded.Files, loaded.Updated
+	case os.IsNotExist(err):
+	default:
+		return nil, fmt.Errorf("cannot read checksum database: %w", err)
+	}
+
+	return &checkVerifier{
+		root:      root,
+		verify:    cfg.verify,
+		baseline:  cfg.baseline,
+		threshold: cfg.threshold,
+		db:        db,
+		sums:      make(map[string]map[string]sidecarSum),
+		counts:    make(map[string]int64),
+	}, nil
+}
+
+// describe is the line printed when the check starts
+func (v *checkVerifier) describe() string {
+	mode := "verify"
+	switch {
+	case v.verify && v.baseline:
+		mode = "verify and record new/modified files"
+	case v.baseline:
+		mode = "record checksums"
+	}
+	return fmt.Sprintf("Integrity check (%s): %s, %d files recorded, %s", mode, v.db.path, len(v.db.Files), v.db.Algorithm)
+}
+
+// isDatabase reports whether path is the database itself, which is not checked
+func (v *checkVerifier) isDatabase(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(abs, v.db.path) || strings.EqualFold(abs, v.db.path+".tmp")
+}
+
+// check reads the whole file and returns its report status. err is set for
+// read errors (status read-error) and slow chunks (status delay-chunk, err
+// is a *slowChunkError); an empty status means the check was interrupted.
+// The reads are timed in lat.
+func (v *checkVerifier) check(ctx context.Context, path string, buf []byte, readBytes *int64, lat *chunkStats) (string, error) {
+	status, err := v.checkFile(ctx, path, buf, readBytes, lat)
+	if status == "" {
+		return "", err
+	}
+	v.mu.Lock()
+	v.counts[status]++
+	if status == checkStatusMismatch {
+		v.mismatches = append(v.mismatches, path)
+	}
+	v.mu.Unlock()
+	return status, err
+}
+
+func (v *checkVerifier) checkFile(ctx context.Context, path string, buf []byte, readBytes *int64, lat *chunkStats) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return checkStatusReadError, err
+	}
+	rel, err := filepath.Rel(v.root, path)
+	if err != nil {
+		rel = path
+	}
+	rel = filepath.ToSlash(rel)
+
+	v.db.mu.Lock()
+	entry, recorded := v.db.Files[rel]
+	v.db.mu.Unlock()
+	modified := recorded && (entry.Size != info.Size() || !entry.Mtime.Equal(info.ModTime()))
+
+	var expected sidecarSum
+	haveExpected := false
+	if v.verify {
+		if recorded && !modified {
+			expected, haveExpected = sidecarSum{algorithm: v.db.Algorithm, hash: entry.Hash}, true
+		} else if !recorded {
+			expected, haveExpected = v.sidecar(path)
+		}
+	}
+	record := v.baseline && (!v.verify || !recorded || modified)
+	if !haveExpected && !record {
+		if modified {
+			return checkStatusModified, nil
+		}
+		return checkStatusNoChecksum, nil
+	}
+
+	// One read serves both the comparison and the record when they use the
+	// same algorithm
+	algorithm := v.db.Algorithm
+	if haveExpected {
+		algorithm = expected.algorithm
+	}
+	hash, err := hashWholeFile(ctx, path, algorithm, buf, readBytes, lat, v.threshold)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if _, slow := err.(*slowChunkError); slow {
+		return checkStatusSlowChunk, err
+	}
+	if err != nil {
+		return checkStatusReadError, err
+	}
+
+	status := checkStatusBaselined
+	if haveExpected {
+		if hash != expected.hash {
+			return checkStatusMismatch, nil
+		}
+		status = checkStatusVerified
+	}
+	if record {
+		if algorithm != v.db.Algorithm {
+			if hash, err = hashWholeFile(ctx, path, v.db.Algorithm, buf, readBytes, nil, v.threshold); err != nil {
+				if ctx.Err() != nil {
+					return "", ctx.Err()
+				}
+				if _, slow := err.(*slowChunkError); slow {
+					return checkStatusSlowChunk, err
+				}
+				return checkStatusReadError, err
+			}
+		}
+		v.db.mu.Lock()
+		v.db.Files[rel] = checksumEntry{Size: info.Size(), Mtime: info.ModTime(), Hash: hash}
+		v.db.changed = true
+		v.db.mu.Unlock()
+		if modified {
+			status = checkStatusModified
+		}
+	}
+	return status, nil
+}
+
+// hashWholeFile reads a file to the end, counting the bytes read and timing
+// each read in lat (may be nil). A chunk slower than threshold is re-read
+// once, as the probes do, and stops the read with a *slowChunkError if it
+// is still slow. An interrupt closes the file to unblock a hanging read.
+func hashWholeFile(ctx context.Context, path string, algorithm fileduplicates.HashAlgorithm, buf []byte, readBytes *int64, lat *chunkStats, threshold time.Duration) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			f.Close()
+		case <-done:
+		}
+	}()
+
+	hasher := fileduplicates.NewHasher(algorithm)
+	var off int64
+	for {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		t0 := time.Now()
+		n, err := f.Read(buf)
+		d := time.Since(t0)
+		if n > 0 || err != io.EOF {
+			lat.add(off, n, d)
+		}
+		if n > 0 {
+			hasher.Write(buf[:n])
+			atomic.AddInt64(readBytes, int64(n))
+		}
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		if threshold > 0 && d > threshold {
+			if d2, slow := rereadChunk(path, off, buf, readBytes, threshold, d); slow {
+				return "", &slowChunkError{off: off, elapsed: d2}
+			}
+		}
+		off += int64(n)
+		if err == io.EOF {
+			break
+		}
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// rereadChunk retries a slow chunk through a new handle when the delay is
+// within the retry window and returns the delay that counts
+func rereadChunk(path string, off int64, buf []byte, readBytes *int64, threshold, d time.Duration) (time.Duration, bool) {
+	if d > threshold+checkRetryWindow {
+		return d, true
+	}
+	time.Sleep(checkRetrySleep)
+	f, err := os.Open(path)
+	if err != nil {
+		return d, true
+	}
+	defer f.Close()
+	t0 := time.Now()
+	n, err := f.ReadAt(buf, off)
+	d2 := time.Since(t0)
+	if n > 0 {
+		atomic.AddInt64(readBytes, int64(n))
+	}
+	if err != nil && err != io.EOF {
+		return d2, true
+	}
+	return d2, d2 > threshold
+}
+
+// sidecar looks for the checksum of path in the checksum files of its folder
+// and of the folders above it, up to the checked root
+func (v *checkVerifier) sidecar(path string) (sidecarSum, bool) {
+	root := filepath.Clean(v.root)
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if rel, err := filepath.Rel(dir, path); err == nil {
+			if sum, ok := v.dirSums(dir)[sumKey(rel)]; ok {
+				return sum, true
+			}
+		}
+		if filepath.Clean(dir) == root || filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	return sidecarSum{}, false
+}
+
+// dirSums loads the checksum files of a folder once
+func (v *checkVerifier) dirSums(dir string) map[string]sidecarSum {
+	v.sumsMu.Lock()
+	defer v.sumsMu.Unlock()
+	if sums, ok := v.sums[dir]; ok {
+		return sums
+	}
+	sums := make(map[string]sidecarSum)
+	v.sums[dir] = sums
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return sums
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := strings.ToLower(e.Name())
+		var algorithm fileduplicates.HashAlgorithm
+		switch {
+		case strings.HasSuffix(name, ".md5") || name == "md5sums":
+			algorithm = fileduplicates.HashMD5
+		case strings.HasSuffix(name, ".sha256") || name == "sha256sums":
+			algorithm = fileduplicates.HashSHA256
+		default:
+			continue
+		}
+		parseSumFile(filepath.Join(dir, e.Name()), algorithm, sums)
+	}
+	return sums
+}
+
+// parseSumFile reads the GNU ("<hash>  <name>", "<hash> *<name>") and BSD
+// ("MD5 (<name>) = <hash>") formats. A bare hash in "<file>.md5" is the
+// checksum of <file>.
+func parseSumFile(path string, algorithm fileduplicates.HashAlgorithm, sums map[string]sidecarSum) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	bare := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	hexLen := 32
+	if algorithm == fileduplicates.HashSHA256 {
+		hexLen = 64
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		var hash, name string
+		if i := strings.Index(line, ") = "); i > 0 && strings.Contains(line[:i], " (") {
+			name = line[strings.Index(line, " (")+2 : i]
+			hash = line[i+4:]
+		} else {
+			fields := strings.SplitN(line, " ", 2)
+			hash = fields[0]
+			if len(fields) == 2 {
+				name = strings.TrimPrefix(strings.TrimLeft(fields[1], " "), "*")
+			} else {
+				name = bare
+			}
+		}
+		hash = strings.ToLower(strings.TrimSpace(hash))
+		if len(hash) != hexLen || name == "" {
+			continue
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			continue
+		}
+		sums[sumKey(name)] = sidecarSum{algorithm: algorithm, hash: hash}
+	}
+}
+
+// sumKey normalizes a path listed in a checksum file
+func sumKey(name string) string {
+	k := strings.TrimPrefix(filepath.ToSlash(strings.ReplaceAll(name, `\`, "/")), "./")
+	if runtime.GOOS == "windows" {
+		k = strings.ToLower(k)
+	}
+	return k
+}
+
+// finish prints the integrity summary and saves the database
+func (v *checkVerifier) finish(cfg *checkConfig) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !cfg.quiet {
+		fmt.Printf("Integrity: verified=%d, mismatched=%d, modified=%d, no-checksum=%d, recorded=%d, read-errors=%d, slow=%d\n",
+			v.counts[checkStatusVerified], v.counts[checkStatusMismatch], v.counts[checkStatusModified],
+			v.counts[checkStatusNoChecksum], v.counts[checkStatusBaselined], v.counts[checkStatusReadError],
+			v.counts[checkStatusSlowChunk])
+	}
+	if len(v.mismatches) > 0 {
+		sort.Strings(v.mismatches)
+		fmt.Printf("\n⚠️ Checksum mismatches (possible silent corruption):\n")
+		const maxShow = 50
+		for i, p := range v.mismatches {
+			if i == maxShow {
+				fmt.Printf("   ... and %d more (see report)\n", len(v.mismatches)-maxShow)
+				break
+			}
+			fmt.Printf("   • %s\n", p)
+		}
+	}
+
+	if !v.baseline || !v.db.changed {
+		return
+	}
+	if cfg.dryRun {
+		fmt.Printf("Dry run: checksum database not saved\n")
+		return
+	}
+	if err := v.db.save(); err != nil {
+		fmt.Printf("Warning: cannot save checksum database: %v\n", err)
+		return
+	}
+	if !cfg.quiet {
+		fmt.Printf("Checksum database saved to %s (%d files)\n", v.db.path, len(v.db.Files))
+	}
+}
+
+func (db *checksumDB) save() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.Updated = time.Now()
+	data, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := db.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, db.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -6,18 +6,25 @@ import (
     "path/filepath"
     "runtime"
     "sort"
+    "strconv"
     "strings"
     "sync"
+    "sync/atomic"
     "time"
+
+    "filedo/fileduplicates"
 )
 
 type fileMeta struct {
+    rel  string // Relative path as found on disk (slash-separated)
     size int64
     mod  time.Time
+    hash string // Recorded hash (manifest sides only)
 }
 
 type diffEntry struct {
-    relPath string
+    key     string // Matching key of the pair
+    relPath string // Source path as found on disk
     srcSize int64
     dstSize int64
 }
@@ -44,46 +51,286 @@ type CompareResult struct {
     DiffTargetSize int64
 
     Diffs []diffEntry
+
+    // Filled in with --content: pairs of equal size are only "same" when
+    // their contents match
+    ContentChecked   bool
+    ContentDiffFiles int64
+    ContentDiffSize  int64
+    ContentDiffs     []diffEntry
+    ContentErrors    []contentError // Pairs that could not be read
+
+    // Filled in with --moves: files only on one side by path that match a
+    // file only on the other side by size and content hash
+    MovedFiles      int64
+    MovedSize       int64
+    Moves           []moveEntry
+    RenamedOnTarget int64
+
+    // Folders and files that could not be scanned; their paths are left out
+    // on both sides
+    SourceUnscanned []scanIssue
+    TargetUnscanned []scanIssue
+
+    // Paths more than one file of a side matches under the match policy;
+    // left out on both sides
+    Collisions []matchCollision
+
+    filter      *compareFilter
+    srcManifest *Manifest // Set when a side is a manifest instead of a folder
+    dstManifest *Manifest
+    srcFiles   map[string]fileMeta // Scanned files by normalized key
+    dstFiles   map[string]fileMeta
+    onlySource []string // Keys only in source (moved files excluded)
+    onlyTarget []string
+    deleted    map[string]bool // "side:rel" of files removed by the del operation
+    dirTimeout time.Duration   // Per-folder listing timeout, for rescans
+    match      matchPolicy
+}
+
+// moveEntry is a file that exists on both sides under different relative
+// paths: oldRel on the target, newRel on the source
+type moveEntry struct {
+    oldRel string
+    newRel string
+    size   int64
+}
+
+type contentError struct {
+    key     string
+    relPath string
+    err     error
+}
+
+// compareOptions are the "--" options of the compare command
+type compareOptions struct {
+    content     bool                         // Check the contents of pairs with equal size
+    byteCompare bool                         // Compare byte by byte instead of by (cached) hash
+    algorithm   fileduplicates.HashAlgorithm // Hash used by --content and --moves
+    moves       bool                         // Detect renamed and moved files
+    dirTimeout  time.Duration                // Give up on a folder listing after this long
+    match       matchPolicy                  // How paths of the two sides are matched
+
+    // sync operation
+    dryRun       bool    // Only print and log the plan
+    deleteExtra  bool    // Delete files only on the target
+    maxDelete    float64 // Abort when more than this percentage of target files would be deleted
+    maxDeleteSet bool
+
+    // Filters, on top of the .filedoignore files at both roots
+    include []string // Glob patterns files must match
+    exclude []string // Glob patterns excluding files and folders
+    minSize int64    // Bytes, 0 = no lower bound
+    maxSize int64    // Bytes, 0 = no upper bound
+
+    reports    []string // Structured report formats: json, csv, html
+    reportFile string   // Report path when a single format is written
+}
+
+// parseCompareOptions takes the "--" options out of the extra arguments and
+// returns the remaining ones (the operation)
+func parseCompareOptions(args []string) (compareOptions, []string, error) {
+    opts := compareOptions{algorithm: fileduplicates.HashMD5, match: defaultMatchPolicy()}
+    var rest []string
+    for i := 0; i < len(args); i++ {
+        switch strings.ToLower(args[i]) {
+        case "--content":
+            opts.content = true
+        case "--moves", "--renames":
+            opts.moves = true
+        case "--dry-run":
+            opts.dryRun = true
+        case "--delete":
+            opts.deleteExtra = true
+        case "--max-delete":
+            if i+1 >= len(args) {
+                return opts, nil, fmt.Errorf("--max-delete requires a percentage")
+            }
+            percent, err := parsePercent(args[i+1])
+            if err != nil {
+                return opts, nil, err
+            }
+            opts.maxDelete = percent
+            opts.maxDeleteSet = true
+            i++
+        case "--report":
+            if i+1 >= len(args) {
+                return opts, nil, fmt.Errorf("--report requires a format: json|csv|html")
+            }
+            formats, err := parseReportFormats(args[i+1])
+            if err != nil {
+                return opts, nil, err
+            }
+            opts.reports = append(opts.reports, formats...)
+            i++
+        case "--report-file":
+            if i+1 >= len(args) {
+                return opts, nil, fmt.Errorf("--report-file requires a file name")
+            }
+            opts.reportFile = args[i+1]
+            i++
+        case "--include", "--exclude":
+            if i+1 >= len(args) {
+                return opts, nil, fmt.Errorf("%s requires a pattern", args[i])
+            }
+            if strings.ToLower(args[i]) == "--include" {
+                opts.include = append(opts.include, args[i+1])
+            } else {
+                opts.exclude = append(opts.exclude, args[i+1])
+            }
+            i++
+        case "--min-mb", "--max-mb":
+            if i+1 >= len(args) {
+                return opts, nil, fmt.Errorf("%s requires a size in MB", args[i])
+            }
+            mb, err := strconv.ParseFloat(args[i+1], 64)
+            if err != nil || mb < 0 {
+                return opts, nil, fmt.Errorf("invalid size for %s: %s", args[i], args[i+1])
+            }
+            if strings.ToLower(args[i]) == "--min-mb" {
+                opts.minSize = fileduplicates.MBToBytes(mb)
+            } else {
+                opts.maxSize = fileduplicates.MBToBytes(mb)
+            }
+            i++
+        case "--match":
+            if i+1 >= len(args) {
+                return opts, nil, fmt.Errorf("--match requires a policy: exact|nocase|unicode")
+            }
+            match, err := parseMatchPolicy(args[i+1])
+            if err != nil {
+                return opts, nil, err
+            }
+            opts.match = match
+            i++
+        case "--dir-timeout":
+            if i+1 >= len(args) {
+                return opts, nil, fmt.Errorf("--dir-timeout requires a number of seconds")
+            }
+            seconds, err := strconv.ParseFloat(args[i+1], 64)
+            if err != nil || seconds <= 0 {
+                return opts, nil, fmt.Errorf("invalid --dir-timeout: %s", args[i+1])
+            }
+            opts.dirTimeout = time.Duration(seconds * float64(time.Second))
+            i++
+        case "--bytes":
+            opts.content = true
+            opts.byteCompare = true
+        case "--hash":
+            if i+1 >= len(args) {
+                return opts, nil, fmt.Errorf("--hash requires an algorithm: md5|sha256|xxh3|blake3")
+            }
+            algorithm, ok := fileduplicates.ParseHashAlgorithm(args[i+1])
+            if !ok {
+                return opts, nil, fmt.Errorf("unknown hash algorithm: %s (allowed: md5|sha256|xxh3|blake3)", args[i+1])
+            }
+            opts.algorithm = algorithm
+            opts.content = true
+            i++
+        default:
+            rest = append(rest, args[i])
+        }
+    }
+    return opts, rest, nil
 }
 
 func handleCompareCommand(sourcePath, targetPath string, extraArgs ...string) error {
+    opts, extraArgs, err := parseCompareOptions(extraArgs)
+    if err != nil {
+        return err
+    }
+    if opts.reportFile != "" && len(opts.reports) == 0 {
+        format := strings.TrimPrefix(strings.ToLower(filepath.Ext(opts.reportFile)), ".")
+        if opts.reports, err = parseReportFormats(format); err != nil || len(opts.reports) == 0 {
+            return fmt.Errorf("cannot tell the report format of %s, use --report json|csv|html", opts.reportFile)
+        }
+    }
+    if len(extraArgs) > 0 && strings.ToLower(extraArgs[0]) == "rename" {
+        opts.moves = true
+    }
+
     // Normalize roots
     src := filepath.Clean(sourcePath)
     dst := filepath.Clean(targetPath)
 
-    // Validate
-    if info, err := os.Stat(src); err != nil || !info.IsDir() {
-        return fmt.Errorf("source is not a directory: %s", sourcePath)
+    // Validate: each side is a folder or a manifest file
+    srcInfo, err := os.Stat(src)
+    if err != nil {
+        return fmt.Errorf("source is not a directory or manifest: %s", sourcePath)
+    }
+    dstInfo, err := os.Stat(dst)
+    if err != nil {
+        return fmt.Errorf("target is not a directory or manifest: %s", targetPath)
     }
-    if info, err := os.Stat(dst); err != nil || !info.IsDir() {
-        return fmt.Errorf("target is not a directory: %s", targetPath)
+    if (!srcInfo.IsDir() || !dstInfo.IsDir()) && len(extraArgs) > 0 {
+        return fmt.Errorf("%s needs both folders; a manifest can only be compared", extraArgs[0])
     }
 
     start := time.Now()
     fmt.Printf("🔍 Comparing folders...\n  Source: %s\n  Target: %s\n\n", src, dst)
 
-    res, err := compareFolders(src, dst)
+    res, err := compareFolders(src, dst, opts)
     if err != nil {
         return err
     }
+    if res.srcManifest != nil {
+        fmt.Printf("Source is a %s\n", describeManifest(res.srcManifest))
+    }
+    if res.dstManifest != nil {
+        fmt.Printf("Target is a %s\n", describeManifest(res.dstManifest))
+    }
+    if filters := res.filter.describe(); filters != "" {
+        fmt.Printf("Filters: %s\n", filters)
+    }
+    if res.match != defaultMatchPolicy() {
+        fmt.Printf("Matching: %s\n", res.match)
+    }
 
     // Print summary to console (pre-delete snapshot)
     fmt.Printf("Summary:\n")
     fmt.Printf("  Only in source: %d files, %s\n", res.OnlySourceFiles, formatBytesShort(uint64(res.OnlySourceSize)))
     fmt.Printf("  Only in target: %d files, %s\n", res.OnlyTargetFiles, formatBytesShort(uint64(res.OnlyTargetSize)))
-    fmt.Printf("  Present in both (same size): %d files, %s\n", res.SameFiles, formatBytesShort(uint64(res.SameSize)))
+    if res.ContentChecked {
+        fmt.Printf("  Present in both (same content): %d files, %s\n", res.SameFiles, formatBytesShort(uint64(res.SameSize)))
+        fmt.Printf("  Present in both (same size, different content): %d files, %s\n", res.ContentDiffFiles, formatBytesShort(uint64(res.ContentDiffSize)))
+        if len(res.ContentErrors) > 0 {
+            fmt.Printf("  Content not checked (read errors): %d files\n", len(res.ContentErrors))
+        }
+    } else {
+        fmt.Printf("  Present in both (same size): %d files, %s\n", res.SameFiles, formatBytesShort(uint64(res.SameSize)))
+    }
     if res.DiffFiles > 0 {
         fmt.Printf("  Present in both (different size): %d files, src=%s, dst=%s\n", res.DiffFiles, formatBytesShort(uint64(res.DiffSourceSize)), formatBytesShort(uint64(res.DiffTargetSize)))
     } else {
         fmt.Printf("  Present in both (different size): %d files\n", res.DiffFiles)
     }
+    if opts.moves {
+        fmt.Printf("  Moved/renamed: %d files, %s\n", res.MovedFiles, formatBytesShort(uint64(res.MovedSize)))
+    }
     fmt.Printf("  Total on source: %d files, %s\n", res.SourceTotalFiles, formatBytesShort(uint64(res.SourceTotalSize)))
     fmt.Printf("  Total on target: %d files, %s\n", res.TargetTotalFiles, formatBytesShort(uint64(res.TargetTotalSize)))
+    if len(res.SourceUnscanned) > 0 || len(res.TargetUnscanned) > 0 {
+        fmt.Printf("  Not scanned (unreadable, timed out or on the skip list): %d on source, %d on target; left out on both sides, see log\n", len(res.SourceUnscanned), len(res.TargetUnscanned))
+    }
+    if len(res.Collisions) > 0 {
+        fmt.Printf("  Name collisions (several files match one path, %s): %d paths; left out on both sides, see log\n", res.match, len(res.Collisions))
+    }
 
     // Optional deletion phase
     if len(extraArgs) > 0 {
         op := strings.ToLower(extraArgs[0])
-        if op == "delete" || op == "del" {
+        if op == "rename" {
+            applyRenames(res)
+        } else if op == "sync" {
+            if err := performSync(res, opts); err != nil {
+                return err
+            }
+        } else if op == "bisync" {
+            apply := len(extraArgs) >= 2 && strings.ToLower(extraArgs[1]) == "apply"
+            if err := performBisync(res, opts, apply); err != nil {
+                return err
+            }
+        } else if op == "delete" || op == "del" {
             if len(extraArgs) < 2 {
                 return fmt.Errorf("DELETE requires a mode: source|target|old|new|small|big")
             }
@@ -97,7 +344,7 @@ func handleCompareCommand(sourcePath, targetPath string, extraArgs ...string) er
                     return fmt.Errorf("invalid side qualifier: %s (allowed: source|target)", s)
                 }
             }
-            if err := performDelete(src, dst, mode, sideOnly); err != nil {
+            if err := performDelete(src, dst, mode, sideOnly, res); err != nil {
                 return err
             }
         }
@@ -112,19 +359,34 @@ func handleCompareCommand(sourcePath, targetPath string, extraArgs ...string) er
     } else {
         fmt.Printf("Log saved to %s\n", logName)
     }
+    writeCompareReports(res, opts, logName, time.Since(start))
 
     return nil
 }
 
-func compareFolders(srcRoot, dstRoot string) (*CompareResult, error) {
-    srcMap, srcFiles, srcSize, err := scanFiles(srcRoot)
+func compareFolders(srcRoot, dstRoot string, opts compareOptions) (*CompareResult, error) {
+    filter, err := newCompareFilter(srcRoot, dstRoot, opts)
+    if err != nil {
+        return nil, err
+    }
+    srcRaw, srcManifest, srcIssues, err := loadCompareSide(srcRoot, filter, opts.dirTimeout)
     if err != nil {
         return nil, err
     }
-    dstMap, dstFiles, dstSize, err := scanFiles(dstRoot)
+    dstRaw, dstManifest, dstIssues, err := loadCompareSide(dstRoot, filter, opts.dirTimeout)
     if err != nil {
         return nil, err
     }
+    srcMap, dstMap, collisions := matchSides(opts.match, srcRaw, dstRaw)
+    if opts.content || opts.moves {
+        if opts, err = manifestHashOptions(opts, srcManifest, dstManifest); err != nil {
+            return nil, err
+        }
+    }
+    dropUnscanned(opts.match, srcIssues, dstIssues, srcMap, dstMap)
+    filterSizes(filter, srcMap, dstMap)
+    srcFiles, srcSize := fileTotals(srcMap)
+    dstFiles, dstSize := fileTotals(dstMap)
 
     res := &CompareResult{
         SourceRoot:       srcRoot,
@@ -133,75 +395,379 @@ func compareFolders(srcRoot, dstRoot string) (*CompareResult, error) {
         SourceTotalSize:  srcSize,
         TargetTotalFiles: dstFiles,
         TargetTotalSize:  dstSize,
+        SourceUnscanned:  srcIssues,
+        TargetUnscanned:  dstIssues,
+        Collisions:       collisions,
+        filter:           filter,
+        srcManifest:      srcManifest,
+        dstManifest:      dstManifest,
+        srcFiles:         srcMap,
+        dstFiles:         dstMap,
+        dirTimeout:       opts.dirTimeout,
+        match:            opts.match,
     }
 
     // Compute sets
+    var pairs, onlySrc, onlyDst []string
     for rel, sMeta := range srcMap {
         if dMeta, ok := dstMap[rel]; ok {
             if sMeta.size == dMeta.size {
                 res.SameFiles++
                 res.SameSize += sMeta.size
+                pairs = append(pairs, rel)
             } else {
                 res.DiffFiles++
                 res.DiffSourceSize += sMeta.size
                 res.DiffTargetSize += dMeta.size
-                res.Diffs = append(res.Diffs, diffEntry{relPath: rel, srcSize: sMeta.size, dstSize: dMeta.size})
+                res.Diffs = append(res.Diffs, diffEntry{key: rel, relPath: sMeta.rel, srcSize: sMeta.size, dstSize: dMeta.size})
             }
         } else {
             res.OnlySourceFiles++
             res.OnlySourceSize += sMeta.size
+            onlySrc = append(onlySrc, rel)
         }
     }
     for rel, dMeta := range dstMap {
         if _, ok := srcMap[rel]; !ok {
             res.OnlyTargetFiles++
             res.OnlyTargetSize += dMeta.size
+            onlyDst = append(onlyDst, rel)
         }
     }
 
+    if opts.content {
+        verifyContent(res, pairs, srcMap, opts)
+    }
+    res.onlySource, res.onlyTarget = onlySrc, onlyDst
+    if opts.moves {
+        detectMoves(res, opts)
+    }
+
     // Sort diffs for stable logging
     sort.Slice(res.Diffs, func(i, j int) bool { return res.Diffs[i].relPath < res.Diffs[j].relPath })
+    sort.Slice(res.ContentDiffs, func(i, j int) bool { return res.ContentDiffs[i].relPath < res.ContentDiffs[j].relPath })
+    sort.Slice(res.ContentErrors, func(i, j int) bool { return res.ContentErrors[i].relPath < res.ContentErrors[j].relPath })
     return res, nil
 }
 
-func scanFiles(root string) (map[string]fileMeta, int64, int64, error) {
-    m := make(map[string]fileMeta, 1024)
-    var files int64
-    var total int64
-    normalizeKey := func(rel string) string {
-        p := filepath.ToSlash(rel)
-        if runtime.GOOS == "windows" {
-            p = strings.ToLower(p)
+// verifyContent checks the pairs of equal size in parallel and moves those
+// whose contents differ from "same" to their own category. Hashes come from
+// the duplicate finder's cache, so a repeated compare only hashes files that
+// changed since.
+func verifyContent(res *CompareResult, pairs []string, srcMap map[string]fileMeta, opts compareOptions) {
+    res.ContentChecked = true
+    if len(pairs) == 0 {
+        return
+    }
+
+    var cache *fileduplicates.HashCache
+    if !opts.byteCompare {
+        var err error
+        cache, err = fileduplicates.LoadHashCache()
+        if err != nil {
+            fmt.Printf("Warning: hash cache unavailable: %v\n", err)
+        }
+        defer cache.Close()
+    }
+
+    method := fmt.Sprintf("%s hash", opts.algorithm)
+    if opts.byteCompare {
+        method = "byte comparison"
+    }
+    fmt.Printf("Checking content of %d pairs (%s)...\n", len(pairs), method)
+
+    workerCount := runtime.NumCPU()
+    if workerCount > 8 {
+        workerCount = 8 // Disk bound; more readers only add seeking
+    }
+    pairsCh := make(chan string, workerCount*4)
+    var mu sync.Mutex
+    var done int64
+    var wg sync.WaitGroup
+    for i := 0; i < workerCount; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for rel := range pairsCh {
+                same, err := res.sameContent(rel, opts, cache)
+                mu.Lock()
+                if err != nil {
+                    res.ContentErrors = append(res.ContentErrors, contentError{key: rel, relPath: srcMap[rel].rel, err: err})
+                } else if !same {
+                    size := srcMap[rel].size
+                    res.SameFiles--
+                    res.SameSize -= size
+                    res.ContentDiffFiles++
+                    res.ContentDiffSize += size
+                    res.ContentDiffs = append(res.ContentDiffs, diffEntry{key: rel, relPath: srcMap[rel].rel, srcSize: size, dstSize: size})
+                }
+                mu.Unlock()
+                if n := atomic.AddInt64(&done, 1); n%100 == 0 {
+                    fmt.Printf("  Checked %d/%d pairs\r", n, len(pairs))
+                }
+            }
+        }()
+    }
+    for _, rel := range pairs {
+        pairsCh <- rel
+    }
+    close(pairsCh)
+    wg.Wait()
+    fmt.Printf("  Checked %d/%d pairs\n\n", len(pairs), len(pairs))
+
+    if cache != nil {
+        if err := cache.Save(); err != nil {
+            fmt.Printf("Warning: cannot save hash cache: %v\n", err)
+        }
+    }
+}
+
+// sameContent compares the two files of a pair of equal size
+func (res *CompareResult) sameContent(key string, opts compareOptions, cache *fileduplicates.HashCache) (bool, error) {
+    s, d := res.srcFiles[key], res.dstFiles[key]
+    if opts.byteCompare {
+        return fileduplicates.FilesIdentical(filepath.Join(res.SourceRoot, s.rel), filepath.Join(res.TargetRoot, d.rel))
+    }
+    srcHash, err := res.fileHash("source", s, opts, cache)
+    if err != nil {
+        return false, err
+    }
+    dstHash, err := res.fileHash("target", d, opts, cache)
+    if err != nil {
+        return false, err
+    }
+    return srcHash == dstHash, nil
+}
+
+// fileHash returns the content hash of a file of one side: recorded in the
+// manifest, or computed through the hash cache
+func (res *CompareResult) fileHash(side string, meta fileMeta, opts compareOptions, cache *fileduplicates.HashCache) (string, error) {
+    root, manifest := res.SourceRoot, res.srcManifest
+    if side == "target" {
+        root, manifest = res.TargetRoot, res.dstManifest
+    }
+    if manifest != nil {
+        if meta.hash == "" {
+            return "", fmt.Errorf("no hash recorded in the manifest")
         }
-        return p
+        return meta.hash, nil
     }
+    return fileduplicates.HashFile(cache, filepath.Join(root, meta.rel), opts.algorithm)
+}
 
-    err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+// loadCompareSide lists the files of a folder, or of a manifest when path is
+// a file, keyed by relative path, with the paths that could not be scanned
+func loadCompareSide(path string, filter *compareFilter, timeout time.Duration) (map[string]fileMeta, *Manifest, []scanIssue, error) {
+    if info, err := os.Stat(path); err == nil && !info.IsDir() {
+        manifest, err := loadManifest(path)
         if err != nil {
-            // Skip entries we cannot read
-            return nil
+            return nil, nil, nil, err
         }
-        if d.IsDir() {
-            return nil
+        return manifestFiles(manifest, filter), manifest, manifest.Unscanned, nil
+    }
+    files, issues, err := scanFiles(path, filter, timeout)
+    return files, nil, issues, err
+}
+
+// manifestHashOptions makes content and move checks use the hash algorithm
+// of the manifests involved; their hashes cannot be recomputed
+func manifestHashOptions(opts compareOptions, manifests ...*Manifest) (compareOptions, error) {
+    var algorithm fileduplicates.HashAlgorithm
+    for _, m := range manifests {
+        if m == nil {
+            continue
         }
-    info, ierr := d.Info()
-        if ierr != nil {
-            return nil
+        if opts.byteCompare {
+            return opts, fmt.Errorf("--bytes needs both folders; %s is a manifest", m.path)
         }
-        rel, rerr := filepath.Rel(root, path)
-        if rerr != nil {
-            return nil
+        if m.Algorithm == "" {
+            return opts, fmt.Errorf("manifest %s has no hashes; create it with --hash to check contents or moves", m.path)
         }
-    key := normalizeKey(rel)
-    m[key] = fileMeta{size: info.Size(), mod: info.ModTime()}
-        files++
-        total += info.Size()
-        return nil
-    })
+        if algorithm != "" && m.Algorithm != algorithm {
+            return opts, fmt.Errorf("manifests were hashed with %s and %s and cannot be compared by content", algorithm, m.Algorithm)
+        }
+        algorithm = m.Algorithm
+    }
+    if algorithm != "" {
+        opts.algorithm = algorithm
+    }
+    return opts, nil
+}
+
+// detectMoves pairs files that are only on one side by path but have the
+// same size and content hash as a file only on the other side. Only sizes
+// present on both sides are hashed. Identical copies are paired in path
+// order; the rest stays "only in source/target".
+func detectMoves(res *CompareResult, opts compareOptions) {
+    srcMap, dstMap := res.srcFiles, res.dstFiles
+    onlySrc, onlyDst := res.onlySource, res.onlyTarget
+    sort.Strings(onlySrc)
+    sort.Strings(onlyDst)
+    srcBySize := make(map[int64][]string)
+    for _, rel := range onlySrc {
+        srcBySize[srcMap[rel].size] = append(srcBySize[srcMap[rel].size], rel)
+    }
+    dstBySize := make(map[int64][]string)
+    for _, rel := range onlyDst {
+        dstBySize[dstMap[rel].size] = append(dstBySize[dstMap[rel].size], rel)
+    }
+
+    type candidate struct {
+        side string // "source" or "target"
+        key  string
+        meta fileMeta
+    }
+    var candidates []candidate
+    for size, srcKeys := range srcBySize {
+        dstKeys, ok := dstBySize[size]
+        if !ok {
+            continue
+        }
+        for _, key := range srcKeys {
+            candidates = append(candidates, candidate{"source", key, srcMap[key]})
+        }
+        for _, key := range dstKeys {
+            candidates = append(candidates, candidate{"target", key, dstMap[key]})
+        }
+    }
+    if len(candidates) == 0 {
+        return
+    }
+
+    cache, err := fileduplicates.LoadHashCache()
     if err != nil {
-        return nil, 0, 0, err
+        fmt.Printf("Warning: hash cache unavailable: %v\n", err)
+    }
+    defer cache.Close()
+    fmt.Printf("Looking for moved/renamed files among %d candidates...\n", len(candidates))
+
+    hashes := make(map[string]string) // side + key -> hash
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    candCh := make(chan candidate, 64)
+    for i := 0; i < runtime.NumCPU() && i < 8; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for c := range candCh {
+                hash, err := res.fileHash(c.side, c.meta, opts, cache)
+                if err != nil {
+                    continue // Stays "only in ..."
+                }
+                mu.Lock()
+                hashes[c.side+":"+c.key] = hash
+                mu.Unlock()
+            }
+        }()
+    }
+    for _, c := range candidates {
+        candCh <- c
+    }
+    close(candCh)
+    wg.Wait()
+    if err := cache.Save(); err != nil {
+        fmt.Printf("Warning: cannot save hash cache: %v\n", err)
+    }
+
+    moved := make(map[string]bool) // "source:key" and "target:key" of paired files
+    for size, srcKeys := range srcBySize {
+        // Targets by hash, in path order
+        byHash := make(map[string][]string)
+        for _, key := range dstBySize[size] {
+            if hash, ok := hashes["target:"+key]; ok {
+                byHash[hash] = append(byHash[hash], key)
+            }
+        }
+        for _, key := range srcKeys {
+            hash, ok := hashes["source:"+key]
+            if !ok || len(byHash[hash]) == 0 {
+                continue
+            }
+            dstKey := byHash[hash][0]
+            byHash[hash] = byHash[hash][1:]
+            res.Moves = append(res.Moves, moveEntry{oldRel: dstMap[dstKey].rel, newRel: srcMap[key].rel, size: size})
+            moved["source:"+key] = true
+            moved["target:"+dstKey] = true
+            res.MovedFiles++
+            res.MovedSize += size
+            res.OnlySourceFiles--
+            res.OnlySourceSize -= size
+            res.OnlyTargetFiles--
+            res.OnlyTargetSize -= size
+        }
     }
-    return m, files, total, nil
+    sort.Slice(res.Moves, func(i, j int) bool { return res.Moves[i].newRel < res.Moves[j].newRel })
+    res.onlySource = withoutMoved(onlySrc, "source:", moved)
+    res.onlyTarget = withoutMoved(onlyDst, "target:", moved)
+    fmt.Println()
+}
+
+func withoutMoved(keys []string, side string, moved map[string]bool) []string {
+    var rest []string
+    for _, key := range keys {
+        if !moved[side+key] {
+            rest = append(rest, key)
+        }
+    }
+    return rest
+}
+
+// applyRenames moves files on the target to the relative path they have on
+// the source, so a mirror matches again without copying their data
+func applyRenames(res *CompareResult) {
+    if len(res.Moves) == 0 {
+        fmt.Println("No moved or renamed files to apply.")
+        return
+    }
+    fmt.Printf("Renaming %d files on target...\n", len(res.Moves))
+    var errs []string
+    for _, m := range res.Moves {
+        from := filepath.Join(res.TargetRoot, m.oldRel)
+        to := filepath.Join(res.TargetRoot, m.newRel)
+        if _, err := os.Lstat(to); err == nil {
+            errs = append(errs, fmt.Sprintf("%s: destination exists", m.newRel))
+            continue
+        }
+        if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+            errs = append(errs, fmt.Sprintf("%s: %v", m.newRel, err))
+            continue
+        }
+        if err := os.Rename(from, to); err != nil {
+            errs = append(errs, fmt.Sprintf("%s: %v", m.newRel, err))
+            continue
+        }
+        res.RenamedOnTarget++
+    }
+    fmt.Printf("Renamed on target: %d files\n", res.RenamedOnTarget)
+    for _, e := range errs {
+        fmt.Printf("  Not renamed: %s\n", e)
+    }
+}
+
+// contentDiffKeys returns the matching keys of the pairs whose contents
+// differ or could not be checked
+func contentDiffKeys(res *CompareResult) map[string]bool {
+    if !res.ContentChecked {
+        return nil
+    }
+    keys := make(map[string]bool)
+    for _, d := range res.ContentDiffs {
+        keys[d.key] = true
+    }
+    for _, e := range res.ContentErrors {
+        keys[e.key] = true
+    }
+    return keys
+}
+
+// fileTotals returns the number and total size of files
+func fileTotals(m map[string]fileMeta) (int64, int64) {
+    var files, total int64
+    for _, meta := range m {
+        files++
+        total += meta.size
+    }
+    return files, total
 }
 
 func makeCompareLogName() string {
@@ -214,19 +780,35 @@ func writeCompareLog(path string, res *CompareResult, took time.Duration) error
     b.WriteString("FileDO Compare Report\n")
     b.WriteString(fmt.Sprintf("Generated: %s\n", time.Now().Format(time.RFC3339)))
     b.WriteString(fmt.Sprintf("Source: %s\n", res.SourceRoot))
-    b.WriteString(fmt.Sprintf("Target: %s\n\n", res.TargetRoot))
+    b.WriteString(fmt.Sprintf("Target: %s\n", res.TargetRoot))
+    b.WriteString(fmt.Sprintf("Matching: %s\n\n", res.match))
 
     b.WriteString("Summary\n")
     b.WriteString(fmt.Sprintf("Only in source: %d files, %s\n", res.OnlySourceFiles, formatBytesShort(uint64(res.OnlySourceSize))))
     b.WriteString(fmt.Sprintf("Only in target: %d files, %s\n", res.OnlyTargetFiles, formatBytesShort(uint64(res.OnlyTargetSize))))
-    b.WriteString(fmt.Sprintf("Present in both (same size): %d files, %s\n", res.SameFiles, formatBytesShort(uint64(res.SameSize))))
+    if res.ContentChecked {
+        b.WriteString(fmt.Sprintf("Present in both (same content): %d files, %s\n", res.SameFiles, formatBytesShort(uint64(res.SameSize))))
+        b.WriteString(fmt.Sprintf("Present in both (same size, different content): %d files, %s\n", res.ContentDiffFiles, formatBytesShort(uint64(res.ContentDiffSize))))
+        b.WriteString(fmt.Sprintf("Content not checked (read errors): %d files\n", len(res.ContentErrors)))
+    } else {
+        b.WriteString(fmt.Sprintf("Present in both (same size): %d files, %s\n", res.SameFiles, formatBytesShort(uint64(res.SameSize))))
+    }
     if res.DiffFiles > 0 {
         b.WriteString(fmt.Sprintf("Present in both (different size): %d files, src=%s, dst=%s\n", res.DiffFiles, formatBytesShort(uint64(res.DiffSourceSize)), formatBytesShort(uint64(res.DiffTargetSize))))
     } else {
         b.WriteString(fmt.Sprintf("Present in both (different size): %d files\n", res.DiffFiles))
     }
+    if len(res.Moves) > 0 {
+        b.WriteString(fmt.Sprintf("Moved/renamed: %d files, %s\n", res.MovedFiles, formatBytesShort(uint64(res.MovedSize))))
+    }
     b.WriteString(fmt.Sprintf("Total on source: %d files, %s\n", res.SourceTotalFiles, formatBytesShort(uint64(res.SourceTotalSize))))
     b.WriteString(fmt.Sprintf("Total on target: %d files, %s\n", res.TargetTotalFiles, formatBytesShort(uint64(res.TargetTotalSize))))
+    if len(res.SourceUnscanned) > 0 || len(res.TargetUnscanned) > 0 {
+        b.WriteString(fmt.Sprintf("Not scanned: %d on source, %d on target\n", len(res.SourceUnscanned), len(res.TargetUnscanned)))
+    }
+    if len(res.Collisions) > 0 {
+        b.WriteString(fmt.Sprintf("Name collisions: %d paths\n", len(res.Collisions)))
+    }
     b.WriteString(fmt.Sprintf("Time: %s\n", formatDuration(took)))
 
     if len(res.Diffs) > 0 {
@@ -235,6 +817,41 @@ func writeCompareLog(path string, res *CompareResult, took time.Duration) error
             b.WriteString(fmt.Sprintf("%s | src=%s | dst=%s\n", d.relPath, formatBytesShort(uint64(d.srcSize)), formatBytesShort(uint64(d.dstSize))))
         }
     }
+    if len(res.ContentDiffs) > 0 {
+        b.WriteString("\nFiles with the same size but different content:\n")
+        for _, d := range res.ContentDiffs {
+            b.WriteString(fmt.Sprintf("%s | %s\n", d.relPath, formatBytesShort(uint64(d.srcSize))))
+        }
+    }
+    if len(res.Moves) > 0 {
+        b.WriteString(fmt.Sprintf("\nMoved/renamed files (target -> source path), renamed on target: %d:\n", res.RenamedOnTarget))
+        for _, m := range res.Moves {
+            b.WriteString(fmt.Sprintf("%s -> %s | %s\n", m.oldRel, m.newRel, formatBytesShort(uint64(m.size))))
+        }
+    }
+    if len(res.ContentErrors) > 0 {
+        b.WriteString("\nFiles whose content could not be checked:\n")
+        for _, e := range res.ContentErrors {
+            b.WriteString(fmt.Sprintf("%s | %v\n", e.relPath, e.err))
+        }
+    }
+    if len(res.SourceUnscanned) > 0 || len(res.TargetUnscanned) > 0 {
+        b.WriteString("\nFolders and files that could not be scanned (left out on both sides):\n")
+        for _, side := range []struct {
+            name   string
+            issues []scanIssue
+        }{{"source", res.SourceUnscanned}, {"target", res.TargetUnscanned}} {
+            for _, issue := range side.issues {
+                b.WriteString(fmt.Sprintf("%s | %s | %s\n", side.name, describeIssue(issue), issue.Reason))
+            }
+        }
+    }
+    if len(res.Collisions) > 0 {
+        b.WriteString("\nPaths matched by more than one file of a side (left out on both sides):\n")
+        for _, c := range res.Collisions {
+            b.WriteString(c.key + " | " + describeCollision(c) + "\n")
+        }
+    }
 
     return os.WriteFile(path, []byte(b.String()), 0644)
 }
@@ -253,69 +870,85 @@ type delStats struct {
     dstBytes int64
 }
 
-func performDelete(srcRoot, dstRoot, mode string, sideOnly string) error {
+// performDelete deletes one side of matching pairs. Pairs found to differ in
+// content are never deleted by the source/target modes; moved files found by
+// the comparison count as pairs for them.
+func performDelete(srcRoot, dstRoot, mode string, sideOnly string, res *CompareResult) error {
     allowed := map[string]bool{"source": true, "target": true, "old": true, "new": true, "small": true, "big": true}
     if !allowed[mode] {
         return fmt.Errorf("invalid delete mode: %s (allowed: source|target|old|new|small|big)", mode)
     }
 
-    // Scan both sides using same normalization as compare
-    srcMap, _, _, err := scanFiles(srcRoot)
-    if err != nil {
-        return err
-    }
-    dstMap, _, _, err := scanFiles(dstRoot)
-    if err != nil {
-        return err
+    // Files of the comparison: normalized and filtered, so excluded files
+    // are never deleted. Maps are keyed by the matching key; paths are
+    // built from the names found on each side.
+    srcMap, dstMap := res.srcFiles, res.dstFiles
+
+    // Pairs whose contents differ are not copies of each other
+    var keep map[string]bool
+    if mode == "source" || mode == "target" {
+        keep = contentDiffKeys(res)
     }
 
     // Build tasks
     tasks := make([]delTask, 0, 1024)
-    for rel, s := range srcMap {
-        if d, ok := dstMap[rel]; ok {
+    if mode == "source" || mode == "target" {
+        for _, m := range res.Moves {
+            if mode == "source" {
+                tasks = append(tasks, delTask{side: "source", rel: m.newRel, abs: filepath.Join(srcRoot, m.newRel), size: m.size})
+            } else {
+                tasks = append(tasks, delTask{side: "target", rel: m.oldRel, abs: filepath.Join(dstRoot, m.oldRel), size: m.size})
+            }
+        }
+    }
+    for key, s := range srcMap {
+        if keep[key] {
+            continue
+        }
+        if d, ok := dstMap[key]; ok {
             switch mode {
             case "source":
-                tasks = append(tasks, delTask{side: "source", rel: rel, abs: filepath.Join(srcRoot, rel), size: s.size})
+                tasks = append(tasks, delTask{side: "source", rel: s.rel, abs: filepath.Join(srcRoot, s.rel), size: s.size})
             case "target":
-                tasks = append(tasks, delTask{side: "target", rel: rel, abs: filepath.Join(dstRoot, rel), size: d.size})
+                tasks = append(tasks, delTask{side: "target", rel: d.rel, abs: filepath.Join(dstRoot, d.rel), size: d.size})
             case "old":
                 if s.mod.Before(d.mod) {
                     if sideOnly == "" || sideOnly == "source" {
-                        tasks = append(tasks, delTask{side: "source", rel: rel, abs: filepath.Join(srcRoot, rel), size: s.size})
+                        tasks = append(tasks, delTask{side: "source", rel: s.rel, abs: filepath.Join(srcRoot, s.rel), size: s.size})
                     }
                 } else if d.mod.Before(s.mod) {
                     if sideOnly == "" || sideOnly == "target" {
-                        tasks = append(tasks, delTask{side: "target", rel: rel, abs: filepath.Join(dstRoot, rel), size: d.size})
+                        tasks = append(tasks, delTask{side: "target", rel: d.rel, abs: filepath.Join(dstRoot, d.rel), size: d.size})
                     }
                 }
             case "new":
                 if s.mod.After(d.mod) {
                     if sideOnly == "" || sideOnly == "source" {
-                        tasks = append(tasks, delTask{side: "source", rel: rel, abs: filepath.Join(srcRoot, rel), size: s.size})
+                        tasks = append(tasks, delTask{side: "source", rel: s.rel, abs: filepath.Join(srcRoot, s.rel), size: s.size})
                     }
                 } else if d.mod.After(s.mod) {
                     if sideOnly == "" || sideOnly == "target" {
-                        tasks = append(tasks, delTask{side: "target", rel: rel, abs: filepath.Join(dstRoot, rel), size: d.size})
+                        tasks = append(tasks, delTask{side: "target", rel: d.rel, abs: filepath.Join(dstRoot, d.rel), size: d.size})
                     }
                 }
             case "small":
                 if s.size < d.size {
                     if sideOnly == "" || sideOnly == "source" {
-                        tasks = append(tasks, delTask{side: "source", rel: rel, abs: filepath.Join(srcRoot, rel), size: s.size})
+                        tasks = append(tasks, delTask{side: "source", rel: s.rel, abs: filepath.Join(srcRoot, s.rel), size: s.size})
                     }
                 } else if d.size < s.size {
                     if sideOnly == "" || sideOnly == "target" {
-                        tasks = append(tasks, delTask{side: "target", rel: rel, abs: filepath.Join(dstRoot, rel), size: d.size})
+                        tasks = append(tasks, delTask{side: "target", rel: d.rel, abs: filepath.Join(dstRoot, d.rel), size: d.size})
                     }
                 }
             case "big":
                 if s.size > d.size {
                     if sideOnly == "" || sideOnly == "source" {
-                        tasks = append(tasks, delTask{side: "source", rel: rel, abs: filepath.Join(srcRoot, rel), size: s.size})
+                        tasks = append(tasks, delTask{side: "source", rel: s.rel, abs: filepath.Join(srcRoot, s.rel), size: s.size})
                     }
                 } else if d.size > s.size {
                     if sideOnly == "" || sideOnly == "target" {
-                        tasks = append(tasks, delTask{side: "target", rel: rel, abs: filepath.Join(dstRoot, rel), size: d.size})
+                        tasks = append(tasks, delTask{side: "target", rel: d.rel, abs: filepath.Join(dstRoot, d.rel), size: d.size})
                     }
                 }
             }
@@ -328,6 +961,7 @@ func performDelete(srcRoot, dstRoot, mode string, sideOnly string) error {
     }
 
     fmt.Printf("Deleting %d files (%s mode)...\n", len(tasks), strings.ToUpper(mode))
+    res.deleted = make(map[string]bool, len(tasks))
     start := time.Now()
 
     // Run workers
@@ -351,6 +985,7 @@ func performDelete(srcRoot, dstRoot, mode string, sideOnly string) error {
                 } else {
                     mu.Lock()
                     deleted = append(deleted, t)
+                    res.deleted[t.side+":"+t.rel] = true
                     if t.side == "source" {
                         stats.srcCount++
                         stats.srcBytes += t.size
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"filedo/fileduplicates"
+)
+
+// SYNC_BASELINE_DIR holds the snapshots of two-way syncs, next to the hash cache
+const SYNC_BASELINE_DIR = "sync_baseline"
+
+// Categories of a two-way sync item
+const (
+	bisyncChangedSource  = "changed on source"
+	bisyncChangedTarget  = "changed on target"
+	bisyncChangedBoth    = "changed on both"
+	bisyncDeletedSource  = "deleted on source"
+	bisyncDeletedTarget  = "deleted on target"
+	bisyncNewSource      = "new on source"
+	bisyncNewTarget      = "new on target"
+	bisyncNewBoth        = "new on both, different"
+	bisyncDeletedChanged = "deleted on one side, changed on the other"
+)
+
+// Actions proposed for a two-way sync item
+const (
+	bisyncCopyToTarget   = "copy to target"
+	bisyncCopyToSource   = "copy to source"
+	bisyncDeleteOnTarget = "delete on target"
+	bisyncDeleteOnSource = "delete on source"
+	bisyncReview         = "review" // Conflict, left alone
+)
+
+// syncBaseline is the state of both folders after the last two-way sync.
+// A file is changed on a side when its size or modification time differ from
+// the baseline, and deleted on a side when the baseline has it but the side
+// does not.
+type syncBaseline struct {
+	Source  string
+	Target  string
+	Updated time.Time
+	Files   map[string]baselineEntry // By normalized relative path
+
+	path string
+}
+
+// baselineEntry is a file that was identical on both sides
+type baselineEntry struct {
+	Rel       string
+	Size      int64
+	SourceMod time.Time
+	TargetMod time.Time
+}
+
+// bisyncItem is a file that needs attention, with the proposed action
+type bisyncItem struct {
+	key      string
+	rel      string
+	category string
+	action   string
+	src      *fileMeta // nil when missing on the source
+	dst      *fileMeta
+}
+
+// getBaselinePath returns where the baseline of a pair of folders is stored
+func getBaselinePath(srcRoot, dstRoot string) string {
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(srcRoot) + "|" + strings.ToLower(dstRoot)))
+	return filepath.Join(filepath.Dir(fileduplicates.GetHashCachePath()), SYNC_BASELINE_DIR, fmt.Sprintf("%016x.json", h.Sum64()))
+}
+
+// loadSyncBaseline loads the baseline of a pair of folders. A missing one
+// yields an empty baseline: every file is then new on its side.
+func loadSyncBaseline(srcRoot, dstRoot string) (*syncBaseline, error) {
+	if abs, err := filepath.Abs(srcRoot); err == nil {
+		srcRoot = abs
+	}
+	if abs, err := filepath.Abs(dstRoot); err == nil {
+		dstRoot = abs
+	}
+	base := &syncBaseline{
+		Source: srcRoot,
+		Target: dstRoot,
+		Files:  make(map[string]baselineEntry),
+		path:   getBaselinePath(srcRoot, dstRoot),
+	}
+	data, err := os.ReadFile(base.path)
+	if os.IsNotExist(err) {
+		return base, nil
+	}
+	if err != nil {
+		return base, fmt.Errorf("failed to read sync baseline: %w", err)
+	}
+	var stored syncBaseline
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return base, fmt.Errorf("sync baseline %s is damaged, starting over: %w", base.path, err)
+	}
+	if stored.Source == srcRoot && stored.Target == dstRoot && stored.Files != nil {
+		base.Files = stored.Files
+		base.Updated = stored.Updated
+	}
+	return base, nil
+}
+
+// save writes the baseline atomically
+func (b *syncBaseline) save() error {
+	b.Updated = time.Now()
+	data, err := json.Marshal(b)
+	if err != nil {
+		return fmt.Errorf("failed to encode sync baseline: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		return fmt.Errorf("failed to create sync baseline directory: %w", err)
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write sync baseline: %w", err)
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace sync baseline: %w", err)
+	}
+	return nil
+}
+
+// closeTimes reports whether two modification times are equal within the
+// resolution of FAT drives
+func closeTimes(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d <= syncMtimeTolerance && d >= -syncMtimeTolerance
+}
+
+// sameOnBothSides reports whether a pair holds the same file: equal size and
+// either equal times or, with --content, contents checked equal by this
+// comparison
+func sameOnBothSides(res *CompareResult, diffKeys map[string]bool, key string, s, d fileMeta) bool {
+	if s.size != d.size {
+		return false
+	}
+	if closeTimes(s.mod, d.mod) {
+		return true
+	}
+	if !res.ContentChecked || diffKeys[key] {
+		return false
+	}
+	// The contents were compared by this run; they still hold if neither
+	// file changed since
+	ss, sok := res.srcFiles[key]
+	dd, dok := res.dstFiles[key]
+	return sok && dok && ss.mod.Equal(s.mod) && dd.mod.Equal(d.mod)
+}
+
+// planBisync classifies every file against the baseline
+func planBisync(res *CompareResult, base *syncBaseline) []bisyncItem {
+	diffKeys := contentDiffKeys(res)
+	keys := make(map[string]bool)
+	for key := range res.srcFiles {
+		keys[key] = true
+	}
+	for key := range res.dstFiles {
+		keys[key] = true
+	}
+	for key := range base.Files {
+		keys[key] = true
+	}
+
+	var items []bisyncItem
+	for key := range keys {
+		s, sok := res.srcFiles[key]
+		d, dok := res.dstFiles[key]
+		b, bok := base.Files[key]
+		item := bisyncItem{key: key}
+		if sok {
+			item.src = &s
+			item.rel = s.rel
+		}
+		if dok {
+			item.dst = &d
+			if item.rel == "" {
+				item.rel = d.rel
+			}
+		}
+		if item.rel == "" {
+			continue // Deleted on both sides
+		}
+
+		if !bok {
+			switch {
+			case sok && dok:
+				if sameOnBothSides(res, diffKeys, key, s, d) {
+					continue
+				}
+				item.category, item.action = bisyncNewBoth, bisyncReview
+			case sok:
+				item.category, item.action = bisyncNewSource, bisyncCopyToTarget
+			default:
+				item.category, item.action = bisyncNewTarget, bisyncCopyToSource
+			}
+			items = append(items, item)
+			continue
+		}
+
+		srcChanged := sok && (s.size != b.Size || !closeTimes(s.mod, b.SourceMod))
+		dstChanged := dok && (d.size != b.Size || !closeTimes(d.mod, b.TargetMod))
+		switch {
+		case sok && dok:
+			switch {
+			case !srcChanged && !dstChanged:
+				continue
+			case srcChanged && dstChanged:
+				if sameOnBothSides(res, diffKeys, key, s, d) {
+					continue // Changed the same way on both sides
+				}
+				item.category, item.action = bisyncChangedBoth, bisyncReview
+			case srcChanged:
+				item.category, item.action = bisyncChangedSource, bisyncCopyToTarget
+			default:
+				item.category, item.action = bisyncChangedTarget, bisyncCopyToSource
+			}
+		case dok:
+			if dstChanged {
+				item.category, item.action = bisyncDeletedChanged, bisyncReview
+			} else {
+				item.category, item.action = bisyncDeletedSource, bisyncDeleteOnTarget
+			}
+		default:
+			if srcChanged {
+				item.category, item.action = bisyncDeletedChanged, bisyncReview
+			} else {
+				item.category, item.action = bisyncDeletedTarget, bisyncDeleteOnSource
+			}
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].rel < items[j].rel })
+	return items
+}
+
+// performBisync prints the two-way plan and, with apply, executes every
+// action except conflicts, which are listed for review. The baseline is
+// only written by apply.
+func performBisync(res *CompareResult, opts compareOptions, apply bool) error {
+	base, err := loadSyncBaseline(res.SourceRoot, res.TargetRoot)
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	if len(base.Files) == 0 {
+		fmt.Printf("\nNo sync baseline yet: files that differ on both sides are reported as conflicts\n")
+	} else {
+		fmt.Printf("\nSync baseline from %s (%d files)\n", base.Updated.Format("2006-01-02 15:04:05"), len(base.Files))
+	}
+
+	items := planBisync(res, base)
+	counts := make(map[string]int)
+	actions := make(map[string]int)
+	for _, it := range items {
+		counts[it.category]++
+		actions[it.action]++
+	}
+	fmt.Printf("\nTwo-way sync plan:\n")
+	for _, c := range []string{bisyncNewSource, bisyncNewTarget, bisyncChangedSource, bisyncChangedTarget,
+		bisyncDeletedSource, bisyncDeletedTarget, bisyncChangedBoth, bisyncNewBoth, bisyncDeletedChanged} {
+		if counts[c] > 0 {
+			fmt.Printf("  %s: %d files\n", strings.ToUpper(c[:1])+c[1:], counts[c])
+		}
+	}
+	if len(items) == 0 {
+		fmt.Printf("  Both folders are in sync\n")
+	}
+	for _, it := range items {
+		if it.action == bisyncReview {
+			fmt.Printf("  CONFLICT %s: %s\n", it.rel, it.category)
+		}
+	}
+
+	maxDelete := DEFAULT_SYNC_MAX_DELETE_PERCENT
+	if opts.maxDeleteSet {
+		maxDelete = opts.maxDelete
+	}
+	var tooMany []string
+	for _, side := range []struct {
+		name    string
+		deletes int
+		total   int64
+	}{
+		{"source", actions[bisyncDeleteOnSource], res.SourceTotalFiles},
+		{"target", actions[bisyncDeleteOnTarget], res.TargetTotalFiles},
+	} {
+		if side.total > 0 && float64(side.deletes)*100/float64(side.total) > maxDelete {
+			tooMany = append(tooMany, fmt.Sprintf("%d of %d %s files", side.deletes, side.total, side.name))
+		}
+	}
+
+	if !apply {
+		if len(tooMany) > 0 {
+			fmt.Printf("  ⚠️  Deletions exceed the %.1f%% limit (%s); apply would be aborted\n", maxDelete, strings.Join(tooMany, ", "))
+		}
+		fmt.Printf("Plan only: nothing was changed (add \"apply\" to execute it)\n")
+		if err := writeBisyncLog(res, items, nil, 0); err != nil {
+			fmt.Printf("Warning: cannot write sync log: %v\n", err)
+		}
+		return nil
+	}
+	if len(tooMany) > 0 {
+		return fmt.Errorf("sync aborted: %s would be deleted, limit is %.1f%% (raise it with --max-delete)", strings.Join(tooMany, ", "), maxDelete)
+	}
+
+	start := time.Now()
+	var jobs []copyJob
+	for _, it := range items {
+		switch it.action {
+		case bisyncCopyToTarget:
+			to := it.src.rel
+			if it.dst != nil {
+				to = it.dst.rel
+			}
+			jobs = append(jobs, copyJob{rel: it.rel, from: filepath.Join(res.SourceRoot, it.src.rel), to: filepath.Join(res.TargetRoot, to), size: it.src.size})
+		case bisyncCopyToSource:
+			to := it.dst.rel
+			if it.src != nil {
+				to = it.src.rel
+			}
+			jobs = append(jobs, copyJob{rel: it.rel, from: filepath.Join(res.TargetRoot, it.dst.rel), to: filepath.Join(res.SourceRoot, to), size: it.dst.size})
+		}
+	}
+	result := &syncResult{}
+	if len(jobs) > 0 {
+		result.copied, result.copiedBytes, result.errs = copyJobs(jobs)
+	}
+	for _, it := range items {
+		var path string
+		var size int64
+		switch it.action {
+		case bisyncDeleteOnTarget:
+			path, size = filepath.Join(res.TargetRoot, it.dst.rel), it.dst.size
+		case bisyncDeleteOnSource:
+			path, size = filepath.Join(res.SourceRoot, it.src.rel), it.src.size
+		default:
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			result.errs = append(result.errs, fmt.Sprintf("delete %s: %v", path, err))
+			continue
+		}
+		result.deleted++
+		result.deletedSize += size
+	}
+
+	fmt.Printf("Copied: %d files, %s\n", result.copied, formatBytesShort(uint64(result.copiedBytes)))
+	fmt.Printf("Deleted: %d files, %s\n", result.deleted, formatBytesShort(uint64(result.deletedSize)))
+	fmt.Printf("Left for review: %d conflicts\n", actions[bisyncReview])
+	fmt.Printf("Sync completed in %s\n", formatDuration(time.Since(start)))
+	if len(result.errs) > 0 {
+		fmt.Printf("Errors: %d (see log)\n", len(result.errs))
+	}
+
+	if err := updateSyncBaseline(res, base); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	if err := writeBisyncLog(res, items, result, time.Since(start)); err != nil {
+		fmt.Printf("Warning: cannot write sync log: %v\n", err)
+	}
+	return nil
+}
+
+// updateSyncBaseline rescans both folders and records every file that is now
+// the same on both sides. Files that still differ (conflicts, failed copies
+// or deletions) keep their previous entry, so the next run reports them the
+// same way; so do files under paths that could not be scanned or that
+// collide.
+func updateSyncBaseline(res *CompareResult, base *syncBaseline) error {
+	srcRaw, srcIssues, err := scanFiles(res.SourceRoot, res.filter, res.dirTimeout)
+	if err != nil {
+		return fmt.Errorf("cannot rescan source for the sync baseline: %w", err)
+	}
+	dstRaw, dstIssues, err := scanFiles(res.TargetRoot, res.filter, res.dirTimeout)
+	if err != nil {
+		return fmt.Errorf("cannot rescan target for the sync baseline: %w", err)
+	}
+	srcMap, dstMap, collisions := matchSides(res.match, srcRaw, dstRaw)
+	dropUnscanned(res.match, srcIssues, dstIssues, srcMap, dstMap)
+	filterSizes(res.filter, srcMap, dstMap)
+	leftOut := newUnscannedSet(res.match, res.SourceUnscanned, res.TargetUnscanned, srcIssues, dstIssues)
+	for _, c := range append(collisions, res.Collisions...) {
+		leftOut.files[c.key] = true
+	}
+	diffKeys := contentDiffKeys(res)
+	files := make(map[string]baselineEntry)
+	for key, s := range srcMap {
+		d, ok := dstMap[key]
+		if ok && sameOnBothSides(res, diffKeys, key, s, d) {
+			files[key] = baselineEntry{Rel: s.rel, Size: s.size, SourceMod: s.mod, TargetMod: d.mod}
+		} else if old, had := base.Files[key]; had {
+			files[key] = old
+		}
+	}
+	for key, old := range base.Files {
+		if _, ok := srcMap[key]; !ok {
+			if _, ok := dstMap[key]; ok || leftOut.covers(key) {
+				files[key] = old
+			}
+		}
+	}
+	base.Files = files
+	return base.save()
+}
+
+func writeBisyncLog(res *CompareResult, items []bisyncItem, result *syncResult, took time.Duration) error {
+	ts := time.Now().Format("20060102_150405")
+	kind := "report"
+	if result == nil {
+		kind = "plan"
+	}
+	name := fmt.Sprintf("bisync_%s_%s.log", kind, ts)
+
+	var b strings.Builder
+	if result == nil {
+		b.WriteString("FileDO Two-Way Sync Plan\n")
+	} else {
+		b.WriteString("FileDO Two-Way Sync Report\n")
+	}
+	b.WriteString(fmt.Sprintf("Generated: %s\n", time.Now().Format(time.RFC3339)))
+	b.WriteString(fmt.Sprintf("Source: %s\nTarget: %s\n", res.SourceRoot, res.TargetRoot))
+	if result != nil {
+		b.WriteString(fmt.Sprintf("Time: %s\n", formatDuration(took)))
+		b.WriteString(fmt.Sprintf("Copied: %d files, %s\n", result.copied, formatBytesShort(uint64(result.copiedBytes))))
+		b.WriteString(fmt.Sprintf("Deleted: %d files, %s\n", result.deleted, formatBytesShort(uint64(result.deletedSize))))
+	}
+
+	describe := func(m *fileMeta) string {
+		if m == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%s %s", formatBytesShort(uint64(m.size)), m.mod.Format("2006-01-02 15:04:05"))
+	}
+	b.WriteString("\nItems (category | action | path | source | target):\n")
+	for _, it := range items {
+		b.WriteString(fmt.Sprintf("%s | %s | %s | %s | %s\n", it.category, it.action, it.rel, describe(it.src), describe(it.dst)))
+	}
+	if result != nil && len(result.errs) > 0 {
+		b.WriteString("\nErrors:\n")
+		for _, e := range result.errs {
+			b.WriteString(e + "\n")
+		}
+	}
+	if err := os.WriteFile(name, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Sync log saved to %s\n", name)
+	return nil
+}
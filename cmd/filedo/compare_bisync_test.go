@@ -0,0 +1,199 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlanBisync(t *testing.T) {
+	t0 := time.Date(2025, 1, 2, 15, 4, 0, 0, time.UTC)
+	later := t0.Add(time.Hour)
+	file := func(size int64, mod time.Time) *fileMeta {
+		return &fileMeta{rel: "a.txt", size: size, mod: mod}
+	}
+	synced := &baselineEntry{Rel: "a.txt", Size: 10, SourceMod: t0, TargetMod: t0}
+
+	tests := []struct {
+		name     string
+		src, dst *fileMeta
+		base     *baselineEntry
+		content  bool // --content ran
+		differs  bool // and found the pair different
+		category string
+		action   string // empty: nothing to do
+	}{
+		{name: "unchanged", src: file(10, t0), dst: file(10, t0), base: synced},
+		{name: "mtime within FAT resolution", src: file(10, t0.Add(time.Second)), dst: file(10, t0), base: synced},
+		{name: "changed on source", src: file(12, later), dst: file(10, t0), base: synced,
+			category: bisyncChangedSource, action: bisyncCopyToTarget},
+		{name: "touched on source", src: file(10, later), dst: file(10, t0), base: synced,
+			category: bisyncChangedSource, action: bisyncCopyToTarget},
+		{name: "changed on target", src: file(10, t0), dst: file(12, later), base: synced,
+			category: bisyncChangedTarget, action: bisyncCopyToSource},
+		{name: "changed on both, differently", src: file(11, later), dst: file(12, later), base: synced,
+			category: bisyncChangedBoth, action: bisyncReview},
+		{name: "changed on both, the same way", src: file(12, later), dst: file(12, later), base: synced},
+		{name: "changed on both, contents equal", src: file(12, later), dst: file(12, later.Add(time.Hour)), base: synced,
+			content: true},
+		{name: "changed on both, contents differ", src: file(12, later), dst: file(12, later.Add(time.Hour)), base: synced,
+			content: true, differs: true, category: bisyncChangedBoth, action: bisyncReview},
+		{name: "deleted on source", dst: file(10, t0), base: synced,
+			category: bisyncDeletedSource, action: bisyncDeleteOnTarget},
+		{name: "deleted on target", src: file(10, t0), base: synced,
+			category: bisyncDeletedTarget, action: bisyncDeleteOnSource},
+		{name: "deleted on source, changed on target", dst: file(12, later), base: synced,
+			category: bisyncDeletedChanged, action: bisyncReview},
+		{name: "deleted on target, changed on source", src: file(12, later), base: synced,
+			category: bisyncDeletedChanged, action: bisyncReview},
+		{name: "deleted on both", base: synced},
+		{name: "new on source", src: file(10, t0),
+			category: bisyncNewSource, action: bisyncCopyToTarget},
+		{name: "new on target", dst: file(10, t0),
+			category: bisyncNewTarget, action: bisyncCopyToSource},
+		{name: "new on both, same", src: file(10, t0), dst: file(10, t0)},
+		{name: "new on both, different", src: file(10, t0), dst: file(11, later),
+			category: bisyncNewBoth, action: bisyncReview},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &CompareResult{
+				srcFiles:       make(map[string]fileMeta),
+				dstFiles:       make(map[string]fileMeta),
+				ContentChecked: tt.content,
+			}
+			base := &syncBaseline{Files: make(map[string]baselineEntry)}
+			if tt.src != nil {
+				res.srcFiles["a.txt"] = *tt.src
+			}
+			if tt.dst != nil {
+				res.dstFiles["a.txt"] = *tt.dst
+			}
+			if tt.base != nil {
+				base.Files["a.txt"] = *tt.base
+			}
+			if tt.differs {
+				res.ContentDiffs = append(res.ContentDiffs, diffEntry{key: "a.txt", relPath: "a.txt"})
+			}
+
+			items := planBisync(res, base)
+			if tt.action == "" {
+				if len(items) != 0 {
+					t.Fatalf("planBisync = %+v, want nothing to do", items)
+				}
+				return
+			}
+			if len(items) != 1 {
+				t.Fatalf("planBisync returned %d items, want 1", len(items))
+			}
+			if items[0].category != tt.category || items[0].action != tt.action {
+				t.Errorf("planBisync = %q / %q, want %q / %q", items[0].category, items[0].action, tt.category, tt.action)
+			}
+		})
+	}
+}
+
+// writeSyncFile writes a file below root with the given content and mtime
+func writeSyncFile(t *testing.T, root, rel, content string, mod time.Time) {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateSyncBaseline(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	t0 := time.Date(2025, 1, 2, 15, 4, 0, 0, time.Local)
+	later := t0.Add(time.Hour)
+
+	writeSyncFile(t, src, "same.txt", "same", t0)
+	writeSyncFile(t, dst, "same.txt", "same", t0)
+	writeSyncFile(t, src, "conflict.txt", "source", later)
+	writeSyncFile(t, dst, "conflict.txt", "target!", later)
+	writeSyncFile(t, dst, "only-target.txt", "kept", t0)
+	writeSyncFile(t, src, "sub/new.txt", "new", later)
+	writeSyncFile(t, dst, "sub/new.txt", "new", later)
+
+	old := baselineEntry{Rel: "conflict.txt", Size: 5, SourceMod: t0, TargetMod: t0}
+	base := &syncBaseline{
+		Source: src,
+		Target: dst,
+		Files: map[string]baselineEntry{
+			"conflict.txt":    old,
+			"only-target.txt": {Rel: "only-target.txt", Size: 4, SourceMod: t0, TargetMod: t0},
+			"gone.txt":        {Rel: "gone.txt", Size: 1, SourceMod: t0, TargetMod: t0},
+		},
+		path: filepath.Join(dir, "baseline.json"),
+	}
+	res := &CompareResult{SourceRoot: src, TargetRoot: dst}
+
+	if err := updateSyncBaseline(res, base); err != nil {
+		t.Fatalf("updateSyncBaseline: %v", err)
+	}
+
+	// Files now equal on both sides are recorded with their current state
+	for _, key := range []string{"same.txt", "sub/new.txt"} {
+		entry, ok := base.Files[key]
+		if !ok {
+			t.Errorf("%s missing from the baseline", key)
+			continue
+		}
+		info, _ := os.Stat(filepath.Join(src, filepath.FromSlash(key)))
+		if entry.Size != info.Size() || !entry.SourceMod.Equal(info.ModTime()) {
+			t.Errorf("%s recorded as %+v, want size %d mtime %v", key, entry, info.Size(), info.ModTime())
+		}
+	}
+	// A conflict keeps its previous entry, so the next run reports it again
+	if got := base.Files["conflict.txt"]; got != old {
+		t.Errorf("conflict.txt = %+v, want the previous entry %+v", got, old)
+	}
+	// A file still on one side keeps its entry (a failed delete is retried)
+	if _, ok := base.Files["only-target.txt"]; !ok {
+		t.Error("only-target.txt dropped from the baseline")
+	}
+	// A file gone from both sides is forgotten
+	if _, ok := base.Files["gone.txt"]; ok {
+		t.Error("gone.txt kept in the baseline")
+	}
+
+	if _, err := os.Stat(base.path); err != nil {
+		t.Fatalf("baseline not saved: %v", err)
+	}
+
+	// With the new baseline, the next plan only holds the conflict and the
+	// file deleted on the source
+	srcRaw, _, err := scanFiles(src, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstRaw, _, err := scanFiles(dst, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.srcFiles, res.dstFiles, _ = matchSides(res.match, srcRaw, dstRaw)
+	items := planBisync(res, base)
+	got := make(map[string]string)
+	for _, it := range items {
+		got[it.rel] = it.action
+	}
+	want := map[string]string{"conflict.txt": bisyncReview, "only-target.txt": bisyncDeleteOnTarget}
+	if len(got) != len(want) {
+		t.Fatalf("plan after update = %v, want %v", got, want)
+	}
+	for rel, action := range want {
+		if got[rel] != action {
+			t.Errorf("plan for %s = %q, want %q", rel, got[rel], action)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// COMPARE_IGNORE_FILE lists patterns excluded from compare, read from the
+// root of either folder
+const COMPARE_IGNORE_FILE = ".filedoignore"
+
+// ignoreRule is one line of an ignore file (or an --exclude option). The
+// syntax follows .gitignore: "#" starts a comment, "!" re-includes, a
+// trailing "/" matches directories only, a pattern containing "/" is
+// relative to the root while other patterns match a name at any depth, "*"
+// and "?" stay within a path component and "**" spans components.
+type ignoreRule struct {
+	text    string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// compareFilter decides which files take part in a comparison. The same
+// filter applies to both sides, so an excluded file is never counted,
+// reported, copied or deleted on either of them.
+type compareFilter struct {
+	rules     []ignoreRule     // Ignore files of both roots, then --exclude; the last match wins
+	include   []*regexp.Regexp // --include: files must match one of them
+	minSize   int64            // Bytes, 0 = no lower bound
+	maxSize   int64            // Bytes, 0 = no upper bound
+	sources   []string         // Ignore files read, for the summary
+	fromFiles int              // Rules read from ignore files
+}
+
+// parseIgnoreRule compiles one ignore line; ok is false for blank lines and
+// comments
+func parseIgnoreRule(line string) (rule ignoreRule, ok bool, err error) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false, nil
+	}
+	rule.text = line
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false, nil
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	rule.re, err = globToRegexp(line, anchored)
+	if err != nil {
+		return rule, false, fmt.Errorf("invalid pattern %q: %w", rule.text, err)
+	}
+	return rule, true, nil
+}
+
+// globToRegexp translates a gitignore glob into a case-insensitive regular
+// expression over slash-separated relative paths. Unanchored patterns match
+// the trailing components of a path.
+func globToRegexp(glob string, anchored bool) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?i)")
+	if anchored {
+		b.WriteString("^")
+	} else {
+		b.WriteString("(^|/)")
+	}
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				if i+2 < len(glob) && glob[i+2] == '/' {
+					b.WriteString("(.*/)?")
+					i += 2
+				} else {
+					b.WriteString(".*")
+					i++
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// loadIgnoreFile appends the rules of root's ignore file, if there is one
+func (f *compareFilter) loadIgnoreFile(root string) error {
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return nil // A manifest
+	}
+	path := filepath.Join(root, COMPARE_IGNORE_FILE)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		rule, ok, err := parseIgnoreRule(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if ok {
+			f.rules = append(f.rules, rule)
+			count++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	f.fromFiles += count
+	if count > 0 {
+		f.sources = append(f.sources, fmt.Sprintf("%s (%d rules)", path, count))
+	}
+	return nil
+}
+
+// newCompareFilter builds the filter of a comparison from the ignore files at
+// both roots and the command line options
+func newCompareFilter(srcRoot, dstRoot string, opts compareOptions) (*compareFilter, error) {
+	f := &compareFilter{minSize: opts.minSize, maxSize: opts.maxSize}
+	for _, root := range []string{srcRoot, dstRoot} {
+		if err := f.loadIgnoreFile(root); err != nil {
+			return nil, err
+		}
+	}
+	for _, pattern := range opts.exclude {
+		rule, ok, err := parseIgnoreRule(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			f.rules = append(f.rules, rule)
+		}
+	}
+	for _, pattern := range opts.include {
+		p := filepath.ToSlash(pattern)
+		re, err := globToRegexp(strings.TrimPrefix(p, "/"), strings.Contains(p, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		f.include = append(f.include, re)
+	}
+	return f, nil
+}
+
+// excluded applies the ignore rules to a slash-separated relative path
+func (f *compareFilter) excluded(rel string, isDir bool) bool {
+	if f == nil {
+		return false
+	}
+	excluded := false
+	for _, rule := range f.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
+
+// acceptFile reports whether a file passes the rules and --include. Sizes
+// are checked per pair by filterSizes.
+func (f *compareFilter) acceptFile(rel string) bool {
+	if f == nil {
+		return true
+	}
+	if f.excluded(rel, false) {
+		return false
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptPath is acceptFile for files that were not found by walking
+// (manifest entries): the folders above the file are checked as well
+func (f *compareFilter) acceptPath(rel string) bool {
+	for i := strings.IndexByte(rel, '/'); i >= 0; {
+		if f.excluded(rel[:i], true) {
+			return false
+		}
+		next := strings.IndexByte(rel[i+1:], '/')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return f.acceptFile(rel)
+}
+
+// sizeOK reports whether a size is within the --min-mb/--max-mb bounds
+func (f *compareFilter) sizeOK(size int64) bool {
+	if f == nil {
+		return true
+	}
+	return size >= f.minSize && (f.maxSize == 0 || size <= f.maxSize)
+}
+
+// filterSizes drops files outside the size bounds. A file too big or too
+// small on one side is dropped from both, otherwise it would look like it
+// exists on the other side only and be copied or deleted.
+func filterSizes(f *compareFilter, srcMap, dstMap map[string]fileMeta) {
+	if f == nil || (f.minSize == 0 && f.maxSize == 0) {
+		return
+	}
+	for key, s := range srcMap {
+		if !f.sizeOK(s.size) {
+			delete(srcMap, key)
+			delete(dstMap, key)
+		}
+	}
+	for key, d := range dstMap {
+		if !f.sizeOK(d.size) {
+			delete(srcMap, key)
+			delete(dstMap, key)
+		}
+	}
+}
+
+// describe summarizes the active filter, or returns "" when there is none
+func (f *compareFilter) describe() string {
+	if f == nil {
+		return ""
+	}
+	var parts []string
+	parts = append(parts, f.sources...)
+	if n := len(f.rules) - f.fromFiles; n > 0 {
+		parts = append(parts, fmt.Sprintf("%d exclude patterns", n))
+	}
+	if len(f.include) > 0 {
+		parts = append(parts, fmt.Sprintf("%d include patterns", len(f.include)))
+	}
+	if f.minSize > 0 {
+		parts = append(parts, "min "+formatBytesShort(uint64(f.minSize)))
+	}
+	if f.maxSize > 0 {
+		parts = append(parts, "max "+formatBytesShort(uint64(f.maxSize)))
+	}
+	return strings.Join(parts, ", ")
+}
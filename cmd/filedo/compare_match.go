@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// matchPolicy decides which relative paths of the two sides name the same
+// file
+type matchPolicy struct {
+	foldCase  bool // "Photo.JPG" matches "photo.jpg"
+	normalize bool // Unicode NFC: composed and decomposed spellings match (macOS writes NFD)
+}
+
+// defaultMatchPolicy follows the file system: case-insensitive on Windows
+func defaultMatchPolicy() matchPolicy {
+	return matchPolicy{foldCase: runtime.GOOS == "windows"}
+}
+
+// parseMatchPolicy parses "exact", "nocase", "unicode" or a comma-separated
+// combination such as "nocase,unicode"
+func parseMatchPolicy(s string) (matchPolicy, error) {
+	var p matchPolicy
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		switch strings.TrimSpace(part) {
+		case "exact":
+		case "nocase", "case-insensitive":
+			p.foldCase = true
+		case "unicode", "nfc", "nfd":
+			p.normalize = true
+		default:
+			return p, fmt.Errorf("unknown match policy: %s (allowed: exact|nocase|unicode, comma-separated)", part)
+		}
+	}
+	return p, nil
+}
+
+// key normalizes a relative path for matching the two sides
+func (p matchPolicy) key(rel string) string {
+	k := filepath.ToSlash(rel)
+	if p.normalize {
+		k = norm.NFC.String(k)
+	}
+	if p.foldCase {
+		k = strings.ToLower(k)
+	}
+	return k
+}
+
+func (p matchPolicy) String() string {
+	switch {
+	case p.foldCase && p.normalize:
+		return "case-insensitive, Unicode-normalized"
+	case p.foldCase:
+		return "case-insensitive"
+	case p.normalize:
+		return "Unicode-normalized"
+	}
+	return "exact"
+}
+
+// matchCollision is a path that more than one file of a side maps to, such
+// as "a.txt" and "A.txt" under a case-insensitive policy. Pairing any of them
+// with the other side would be a guess, so the path is left out on both
+// sides and reported with all its files.
+type matchCollision struct {
+	key    string
+	source []fileMeta
+	target []fileMeta
+}
+
+// matchSides keys the files of both sides (keyed by relative path) by the
+// policy and takes the collisions out of both
+func matchSides(p matchPolicy, srcRaw, dstRaw map[string]fileMeta) (srcMap, dstMap map[string]fileMeta, collisions []matchCollision) {
+	srcMap, srcDups := keyFiles(p, srcRaw)
+	dstMap, dstDups := keyFiles(p, dstRaw)
+
+	side := func(m map[string]fileMeta, dups map[string][]fileMeta, key string) []fileMeta {
+		if files, ok := dups[key]; ok {
+			sort.Slice(files, func(i, j int) bool { return files[i].rel < files[j].rel })
+			return files
+		}
+		if meta, ok := m[key]; ok {
+			return []fileMeta{meta}
+		}
+		return nil
+	}
+	for _, dups := range []map[string][]fileMeta{srcDups, dstDups} {
+		for key := range dups {
+			if _, done := srcMap[key]; !done {
+				if _, done := dstMap[key]; !done {
+					continue // Already taken out as a collision of the other side
+				}
+			}
+			collisions = append(collisions, matchCollision{
+				key:    key,
+				source: side(srcMap, srcDups, key),
+				target: side(dstMap, dstDups, key),
+			})
+			delete(srcMap, key)
+			delete(dstMap, key)
+		}
+	}
+	sort.Slice(collisions, func(i, j int) bool { return collisions[i].key < collisions[j].key })
+	return srcMap, dstMap, collisions
+}
+
+// keyFiles keys files by the policy; dups holds every file of keys that more
+// than one file maps to
+func keyFiles(p matchPolicy, raw map[string]fileMeta) (map[string]fileMeta, map[string][]fileMeta) {
+	keyed := make(map[string]fileMeta, len(raw))
+	dups := make(map[string][]fileMeta)
+	for _, meta := range raw {
+		key := p.key(meta.rel)
+		if prev, ok := keyed[key]; ok {
+			if len(dups[key]) == 0 {
+				dups[key] = []fileMeta{prev}
+			}
+			dups[key] = append(dups[key], meta)
+			continue
+		}
+		keyed[key] = meta
+	}
+	return keyed, dups
+}
+
+// describeCollision lists the files of a collision for logs
+func describeCollision(c matchCollision) string {
+	rels := func(files []fileMeta) string {
+		if len(files) == 0 {
+			return "-"
+		}
+		var names []string
+		for _, f := range files {
+			names = append(names, f.rel)
+		}
+		return strings.Join(names, ", ")
+	}
+	return fmt.Sprintf("source: %s | target: %s", rels(c.source), rels(c.target))
+}
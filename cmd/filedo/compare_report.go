@@ -0,0 +1,489 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report formats of the compare command
+const (
+	CompareReportJSON = "json"
+	CompareReportCSV  = "csv"
+	CompareReportHTML = "html"
+)
+
+// Entry categories of a compare report
+const (
+	compareSame        = "same"
+	compareDiffSize    = "different_size"
+	compareDiffContent = "different_content"
+	compareUnchecked   = "unchecked" // Content could not be read
+	compareOnlySource  = "only_source"
+	compareOnlyTarget  = "only_target"
+	compareMoved       = "moved"
+	compareUnscanned   = "unscanned" // Folder or file that could not be scanned
+	compareCollision   = "collision" // One of several files of a side matching the same path
+)
+
+// CompareReport is the structured form of a comparison, written as JSON and
+// embedded in the HTML report
+type CompareReport struct {
+	Generated       time.Time            `json:"generated"`
+	Source          string               `json:"source"`
+	Target          string               `json:"target"`
+	Filters         string               `json:"filters,omitempty"`
+	Matching        string               `json:"matching"`
+	ContentChecked  bool                 `json:"content_checked"`
+	DurationSeconds float64              `json:"duration_seconds"`
+	Summary         CompareReportSummary `json:"summary"`
+	Entries         []CompareReportEntry `json:"entries"`
+}
+
+// CompareReportSummary holds the totals printed by the compare command
+type CompareReportSummary struct {
+	SourceFiles      int64 `json:"source_files"`
+	SourceSize       int64 `json:"source_size"`
+	TargetFiles      int64 `json:"target_files"`
+	TargetSize       int64 `json:"target_size"`
+	OnlySourceFiles  int64 `json:"only_source_files"`
+	OnlySourceSize   int64 `json:"only_source_size"`
+	OnlyTargetFiles  int64 `json:"only_target_files"`
+	OnlyTargetSize   int64 `json:"only_target_size"`
+	SameFiles        int64 `json:"same_files"`
+	SameSize         int64 `json:"same_size"`
+	DiffSizeFiles    int64 `json:"different_size_files"`
+	DiffContentFiles int64 `json:"different_content_files"`
+	UncheckedFiles   int   `json:"unchecked_files"`
+	MovedFiles       int64 `json:"moved_files"`
+	DeletedFiles     int   `json:"deleted_files"`
+	UnscannedPaths   int   `json:"unscanned_paths"`
+	CollisionPaths   int   `json:"collision_paths"`
+}
+
+// CompareReportEntry is one file, or one pair of files, of a comparison.
+// Fields of a side the file is missing on are left out.
+type CompareReportEntry struct {
+	Category    string     `json:"category"`
+	Path        string     `json:"path"`               // Relative, slash-separated (source path for moved files)
+	OldPath     string     `json:"old_path,omitempty"` // Moved files: path on the target
+	SourceSize  *int64     `json:"source_size,omitempty"`
+	SourceMtime *time.Time `json:"source_mtime,omitempty"`
+	TargetSize  *int64     `json:"target_size,omitempty"`
+	TargetMtime *time.Time `json:"target_mtime,omitempty"`
+	Deleted     string     `json:"deleted,omitempty"` // Side removed by the del operation
+	Error       string     `json:"error,omitempty"`
+	Side        string     `json:"side,omitempty"` // Unscanned and collision entries: side of the path
+	Dir         bool       `json:"dir,omitempty"`  // Unscanned entries: the path is a folder
+}
+
+// parseReportFormats parses "json,csv,html"
+func parseReportFormats(s string) ([]string, error) {
+	var formats []string
+	for _, f := range strings.Split(strings.ToLower(s), ",") {
+		f = strings.TrimSpace(f)
+		switch f {
+		case CompareReportJSON, CompareReportCSV, CompareReportHTML:
+			formats = append(formats, f)
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown report format: %s (allowed: json|csv|html)", f)
+		}
+	}
+	return formats, nil
+}
+
+// buildCompareReport lists every file of the comparison with its category
+func buildCompareReport(res *CompareResult, took time.Duration) CompareReport {
+	report := CompareReport{
+		Generated:       time.Now(),
+		Source:          res.SourceRoot,
+		Target:          res.TargetRoot,
+		Filters:         res.filter.describe(),
+		Matching:        res.match.String(),
+		ContentChecked:  res.ContentChecked,
+		DurationSeconds: took.Seconds(),
+		Summary: CompareReportSummary{
+			SourceFiles:      res.SourceTotalFiles,
+			SourceSize:       res.SourceTotalSize,
+			TargetFiles:      res.TargetTotalFiles,
+			TargetSize:       res.TargetTotalSize,
+			OnlySourceFiles:  res.OnlySourceFiles,
+			OnlySourceSize:   res.OnlySourceSize,
+			OnlyTargetFiles:  res.OnlyTargetFiles,
+			OnlyTargetSize:   res.OnlyTargetSize,
+			SameFiles:        res.SameFiles,
+			SameSize:         res.SameSize,
+			DiffSizeFiles:    res.DiffFiles,
+			DiffContentFiles: res.ContentDiffFiles,
+			UncheckedFiles:   len(res.ContentErrors),
+			MovedFiles:       res.MovedFiles,
+			DeletedFiles:     len(res.deleted),
+			UnscannedPaths:   len(res.SourceUnscanned) + len(res.TargetUnscanned),
+			CollisionPaths:   len(res.Collisions),
+		},
+	}
+
+	diffKeys := make(map[string]bool)
+	for _, d := range res.ContentDiffs {
+		diffKeys[d.key] = true
+	}
+	readErrs := make(map[string]string)
+	for _, e := range res.ContentErrors {
+		readErrs[e.key] = e.err.Error()
+	}
+	movedTo := make(map[string]string)     // Source rel -> target rel
+	movedFrom := make(map[string]fileMeta) // Target rel -> target file
+	for _, m := range res.Moves {
+		movedTo[m.newRel] = m.oldRel
+		movedFrom[m.oldRel] = fileMeta{}
+	}
+	if len(res.Moves) > 0 {
+		for _, d := range res.dstFiles {
+			if _, ok := movedFrom[d.rel]; ok {
+				movedFrom[d.rel] = d
+			}
+		}
+	}
+
+	for key, s := range res.srcFiles {
+		e := CompareReportEntry{Path: s.rel}
+		e.setSource(s)
+		if d, ok := res.dstFiles[key]; ok {
+			e.setTarget(d)
+			switch {
+			case s.size != d.size:
+				e.Category = compareDiffSize
+			case readErrs[key] != "":
+				e.Category, e.Error = compareUnchecked, readErrs[key]
+			case diffKeys[key]:
+				e.Category = compareDiffContent
+			default:
+				e.Category = compareSame
+			}
+			e.Deleted = res.deletedSide(s.rel, d.rel)
+		} else if old, ok := movedTo[s.rel]; ok {
+			e.Category, e.OldPath = compareMoved, old
+			e.setTarget(movedFrom[old])
+			e.Deleted = res.deletedSide(s.rel, old)
+		} else {
+			e.Category = compareOnlySource
+		}
+		report.Entries = append(report.Entries, e)
+	}
+	for key, d := range res.dstFiles {
+		if _, ok := res.srcFiles[key]; ok {
+			continue
+		}
+		if _, ok := movedFrom[d.rel]; ok {
+			continue
+		}
+		e := CompareReportEntry{Path: d.rel, Category: compareOnlyTarget}
+		e.setTarget(d)
+		report.Entries = append(report.Entries, e)
+	}
+	for _, issue := range res.SourceUnscanned {
+		report.Entries = append(report.Entries, CompareReportEntry{Category: compareUnscanned, Path: issue.Path, Side: "source", Dir: issue.Dir, Error: issue.Reason})
+	}
+	for _, issue := range res.TargetUnscanned {
+		report.Entries = append(report.Entries, CompareReportEntry{Category: compareUnscanned, Path: issue.Path, Side: "target", Dir: issue.Dir, Error: issue.Reason})
+	}
+	for _, c := range res.Collisions {
+		for _, s := range c.source {
+			e := CompareReportEntry{Category: compareCollision, Path: s.rel, Side: "source", Error: "matches " + describeCollision(c)}
+			e.setSource(s)
+			report.Entries = append(report.Entries, e)
+		}
+		for _, d := range c.target {
+			e := CompareReportEntry{Category: compareCollision, Path: d.rel, Side: "target", Error: "matches " + describeCollision(c)}
+			e.setTarget(d)
+			report.Entries = append(report.Entries, e)
+		}
+	}
+	sort.Slice(report.Entries, func(i, j int) bool { return report.Entries[i].Path < report.Entries[j].Path })
+	return report
+}
+
+func (e *CompareReportEntry) setSource(m fileMeta) {
+	size, mod := m.size, m.mod
+	e.SourceSize, e.SourceMtime = &size, &mod
+}
+
+func (e *CompareReportEntry) setTarget(m fileMeta) {
+	size, mod := m.size, m.mod
+	e.TargetSize, e.TargetMtime = &size, &mod
+}
+
+// deletedSide returns which file of a pair the del operation removed
+func (res *CompareResult) deletedSide(srcRel, dstRel string) string {
+	switch {
+	case res.deleted["source:"+srcRel]:
+		return "source"
+	case res.deleted["target:"+dstRel]:
+		return "target"
+	}
+	return ""
+}
+
+// writeCompareReport writes the report in one format
+func writeCompareReport(path, format string, report CompareReport) error {
+	switch format {
+	case CompareReportJSON:
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error marshaling compare report: %w", err)
+		}
+		return os.WriteFile(path, data, 0644)
+	case CompareReportCSV:
+		return writeCompareCSV(path, report)
+	case CompareReportHTML:
+		return writeCompareHTML(path, report)
+	}
+	return fmt.Errorf("unknown report format: %s", format)
+}
+
+// writeCompareCSV writes one row per entry. Run metadata is only available in
+// the JSON and HTML formats.
+func writeCompareCSV(path string, report CompareReport) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	optSize := func(v *int64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatInt(*v, 10)
+	}
+	optTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"category", "path", "old_path", "source_size", "source_mtime", "target_size", "target_mtime", "deleted", "error", "side"})
+	for _, e := range report.Entries {
+		writer.Write([]string{
+			e.Category,
+			e.Path,
+			e.OldPath,
+			optSize(e.SourceSize),
+			optTime(e.SourceMtime),
+			optSize(e.TargetSize),
+			optTime(e.TargetMtime),
+			e.Deleted,
+			e.Error,
+			e.Side,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeCompareHTML writes a single page without external resources: the
+// entries are embedded as JSON and rendered as a collapsible folder tree
+func writeCompareHTML(path string, report CompareReport) error {
+	tmpl, err := template.New("compare").Parse(compareHTMLTemplate)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return tmpl.Execute(file, report)
+}
+
+// writeCompareReports writes the requested formats next to the text log,
+// or to --report-file
+func writeCompareReports(res *CompareResult, opts compareOptions, logName string, took time.Duration) {
+	if len(opts.reports) == 0 {
+		return
+	}
+	report := buildCompareReport(res, took)
+	for _, format := range opts.reports {
+		name := strings.TrimSuffix(logName, filepath.Ext(logName)) + "." + format
+		if opts.reportFile != "" && len(opts.reports) == 1 {
+			name = opts.reportFile
+		}
+		if err := writeCompareReport(name, format, report); err != nil {
+			fmt.Printf("Warning: cannot write %s report: %v\n", strings.ToUpper(format), err)
+			continue
+		}
+		fmt.Printf("%s report saved to %s\n", strings.ToUpper(format), name)
+	}
+}
+
+const compareHTMLTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>FileDO Compare Report</title>
+<style>
+body { font-family: Segoe UI, Arial, sans-serif; font-size: 14px; margin: 20px; color: #222; }
+h1 { font-size: 20px; margin: 0 0 8px; }
+table.meta td { padding: 1px 12px 1px 0; }
+.controls { margin: 14px 0; padding: 8px; background: #f4f4f4; border: 1px solid #ddd; }
+.controls label { margin-right: 14px; white-space: nowrap; }
+.controls input[type=text] { width: 260px; }
+details { margin-left: 18px; }
+summary { cursor: pointer; padding: 1px 0; }
+summary .totals, .file .sizes { color: #666; margin-left: 8px; }
+.file { margin-left: 36px; padding: 1px 0; }
+.badge { display: inline-block; font-size: 11px; padding: 0 5px; border-radius: 3px; margin-left: 4px; color: #fff; }
+.same { background: #4caf50; } .only_source { background: #2196f3; } .only_target { background: #9c27b0; }
+.different_size { background: #f44336; } .different_content { background: #e91e63; }
+.unchecked { background: #ff9800; } .moved { background: #607d8b; } .unscanned { background: #795548; }
+.collision { background: #ff5722; }
+.deleted { text-decoration: line-through; }
+</style>
+</head>
+<body>
+<h1>FileDO Compare Report</h1>
+<table class="meta">
+<tr><td>Source</td><td>{{.Source}}</td></tr>
+<tr><td>Target</td><td>{{.Target}}</td></tr>
+<tr><td>Generated</td><td>{{.Generated.Format "2006-01-02 15:04:05"}}</td></tr>
+{{if .Filters}}<tr><td>Filters</td><td>{{.Filters}}</td></tr>{{end}}
+<tr><td>Matching</td><td>{{.Matching}}</td></tr>
+<tr><td>Content checked</td><td>{{.ContentChecked}}</td></tr>
+</table>
+<div class="controls">
+<div id="categories"></div>
+<p><input type="text" id="search" placeholder="Filter by path...">
+<button id="expand">Expand all</button> <button id="collapse">Collapse all</button></p>
+</div>
+<div id="tree"></div>
+<script>
+const report = {{.}};
+const names = {
+  same: "Same", different_size: "Different size", different_content: "Different content",
+  unchecked: "Not checked", only_source: "Only in source", only_target: "Only in target", moved: "Moved",
+  unscanned: "Not scanned", collision: "Name collision"
+};
+const enabled = {};
+const counts = {};
+for (const e of report.entries || []) counts[e.category] = (counts[e.category] || 0) + 1;
+const categories = document.getElementById("categories");
+for (const c of Object.keys(names)) {
+  if (!counts[c]) continue;
+  enabled[c] = c !== "same";
+  const label = document.createElement("label");
+  const box = document.createElement("input");
+  box.type = "checkbox";
+  box.checked = enabled[c];
+  box.onchange = () => { enabled[c] = box.checked; render(); };
+  label.append(box, " ", names[c] + " (" + counts[c] + ")");
+  categories.append(label);
+}
+
+function formatBytes(n) {
+  if (n === undefined || n === null) return "-";
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+  return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+}
+
+function buildTree(entries, query) {
+  const root = { name: "", path: "", dirs: {}, files: [], count: 0, src: 0, dst: 0, cats: {} };
+  for (const e of entries) {
+    if (!enabled[e.category]) continue;
+    if (query && !e.path.toLowerCase().includes(query) && !(e.old_path || "").toLowerCase().includes(query)) continue;
+    const parts = e.path.split("/");
+    const chain = [root];
+    let node = root;
+    for (const part of parts.slice(0, -1)) {
+      if (!node.dirs[part]) {
+        node.dirs[part] = { name: part, path: node.path + part + "/", dirs: {}, files: [], count: 0, src: 0, dst: 0, cats: {} };
+      }
+      node = node.dirs[part];
+      chain.push(node);
+    }
+    node.files.push(e);
+    for (const n of chain) {
+      n.count++;
+      n.src += e.source_size || 0;
+      n.dst += e.target_size || 0;
+      n.cats[e.category] = (n.cats[e.category] || 0) + 1;
+    }
+  }
+  return root;
+}
+
+const open = new Set([""]);
+function badge(category, text) {
+  const span = document.createElement("span");
+  span.className = "badge " + category;
+  span.textContent = text;
+  return span;
+}
+
+function renderDir(node, parent) {
+  for (const name of Object.keys(node.dirs).sort()) {
+    const dir = node.dirs[name];
+    const details = document.createElement("details");
+    details.open = open.has(dir.path);
+    details.ontoggle = () => { if (details.open) open.add(dir.path); else open.delete(dir.path); };
+    const summary = document.createElement("summary");
+    const totals = document.createElement("span");
+    totals.className = "totals";
+    totals.textContent = dir.count + " files, source " + formatBytes(dir.src) + ", target " + formatBytes(dir.dst);
+    summary.append(name + "/", totals);
+    for (const c of Object.keys(dir.cats)) summary.append(badge(c, dir.cats[c]));
+    details.append(summary);
+    renderDir(dir, details);
+    parent.append(details);
+  }
+  for (const e of node.files) {
+    const div = document.createElement("div");
+    div.className = "file" + (e.deleted ? " deleted" : "");
+    let label = e.path.split("/").pop();
+    if (e.old_path) label += " (was " + e.old_path + ")";
+    if (e.dir) label += "/";
+    const sizes = document.createElement("span");
+    sizes.className = "sizes";
+    sizes.textContent = "source " + formatBytes(e.source_size) + (e.source_mtime ? " " + e.source_mtime.slice(0, 19).replace("T", " ") : "") +
+      " | target " + formatBytes(e.target_size) + (e.target_mtime ? " " + e.target_mtime.slice(0, 19).replace("T", " ") : "") +
+      (e.deleted ? " | deleted on " + e.deleted : "") + (e.category === "unscanned" ? " | not scanned on " + e.side : "") + (e.error ? " | " + e.error : "");
+    div.append(label, badge(e.category, names[e.category] || e.category), sizes);
+    parent.append(div);
+  }
+}
+
+function render() {
+  const tree = document.getElementById("tree");
+  const root = buildTree(report.entries || [], document.getElementById("search").value.toLowerCase());
+  tree.replaceChildren();
+  const total = document.createElement("p");
+  total.textContent = root.count + " files shown, source " + formatBytes(root.src) + ", target " + formatBytes(root.dst);
+  tree.append(total);
+  renderDir(root, tree);
+}
+
+function collectDirs(node, all) {
+  for (const dir of Object.values(node.dirs)) { all.push(dir.path); collectDirs(dir, all); }
+  return all;
+}
+document.getElementById("search").oninput = render;
+document.getElementById("expand").onclick = () => {
+  for (const p of collectDirs(buildTree(report.entries || [], ""), [])) open.add(p);
+  render();
+};
+document.getElementById("collapse").onclick = () => { open.clear(); render(); };
+render();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DEFAULT_SCAN_DIR_TIMEOUT is how long compare waits for the listing of one
+// folder before giving up on it
+const DEFAULT_SCAN_DIR_TIMEOUT = 30 * time.Second
+
+// COMPARE_SCAN_WORKERS is the number of folders listed at the same time.
+// Listing is bound by latency rather than throughput, so on network shares
+// more requests in flight help.
+const COMPARE_SCAN_WORKERS = 16
+
+var errListingTimeout = errors.New("listing timed out")
+
+// scanIssue is a folder or file that could not be scanned: unreadable, timed
+// out, or on the damaged files skip list. Its path is left out of the
+// comparison on both sides and listed in the logs and reports.
+type scanIssue struct {
+	Path   string `json:"path"` // Relative, slash-separated
+	Dir    bool   `json:"dir,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// dirEntryInfo is one entry of a folder listing, with the file info of files
+type dirEntryInfo struct {
+	name string
+	dir  bool
+	info os.FileInfo
+	err  error
+}
+
+// treeScanner lists a folder tree with a bounded pool of workers. Each folder
+// is listed with its own timeout, so one hung folder only costs that folder.
+type treeScanner struct {
+	root    string
+	filter  *compareFilter
+	timeout time.Duration
+	skip    *DamagedDiskHandler
+	ctx     context.Context
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []string // Relative folders waiting to be listed ("" is the root)
+	pending int      // Folders queued or being listed
+	files   map[string]fileMeta
+	issues  []scanIssue
+}
+
+// scanFiles lists the files under root accepted by filter, keyed by relative
+// path (sizes are filtered later, per pair). Folders that cannot be listed within timeout,
+// and files and folders on the damaged files skip list, are returned as
+// issues instead of failing the scan. Folders that time out are added to the
+// skip list.
+func scanFiles(root string, filter *compareFilter, timeout time.Duration) (map[string]fileMeta, []scanIssue, error) {
+	if timeout <= 0 {
+		timeout = DEFAULT_SCAN_DIR_TIMEOUT
+	}
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs // Skip list entries are absolute
+	}
+	skip, _ := NewDamagedDiskHandlerQuiet()
+	defer skip.Close()
+	ctx := context.Background()
+	if globalInterruptHandler != nil {
+		ctx = globalInterruptHandler.Context()
+	}
+
+	s := &treeScanner{
+		root:    root,
+		filter:  filter,
+		timeout: timeout,
+		skip:    skip,
+		ctx:     ctx,
+		queue:   []string{""},
+		pending: 1,
+		files:   make(map[string]fileMeta, 1024),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	var wg sync.WaitGroup
+	for i := 0; i < COMPARE_SCAN_WORKERS; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work()
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, nil, fmt.Errorf("scan of %s interrupted", root)
+	}
+	for _, issue := range s.issues {
+		if issue.Path == "" {
+			return nil, nil, fmt.Errorf("cannot read %s: %s", root, issue.Reason)
+		}
+	}
+	sort.Slice(s.issues, func(i, j int) bool { return s.issues[i].Path < s.issues[j].Path })
+	return s.files, s.issues, nil
+}
+
+// work lists queued folders until the whole tree is done
+func (s *treeScanner) work() {
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && s.pending > 0 {
+			s.cond.Wait()
+		}
+		if s.ctx.Err() != nil {
+			s.pending -= len(s.queue)
+			s.queue = nil
+		}
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		rel := s.queue[len(s.queue)-1]
+		s.queue = s.queue[:len(s.queue)-1]
+		s.mu.Unlock()
+
+		files, dirs, issues := s.scanDir(rel)
+
+		s.mu.Lock()
+		for _, f := range files {
+			s.files[f.rel] = f
+		}
+		s.issues = append(s.issues, issues...)
+		s.queue = append(s.queue, dirs...)
+		s.pending += len(dirs) - 1
+		s.mu.Unlock()
+		s.cond.Broadcast()
+	}
+}
+
+// scanDir lists one folder and returns its accepted files, its subfolders to
+// scan and the entries that could not be scanned
+func (s *treeScanner) scanDir(rel string) (files []fileMeta, dirs []string, issues []scanIssue) {
+	dir := filepath.Join(s.root, filepath.FromSlash(rel))
+	entries, err := readDirWithTimeout(s.ctx, dir, s.timeout)
+	if err != nil {
+		if s.ctx.Err() != nil {
+			return nil, nil, nil
+		}
+		reason := err.Error()
+		if errors.Is(err, errListingTimeout) && rel != "" {
+			s.skip.LogDamagedFile(dir, "folder listing timed out", 0, 1, reason)
+			reason += ", added to " + filepath.Base(s.skip.config.SkipListFile)
+		}
+		fmt.Printf("Warning: cannot scan %s: %s\n", dir, reason)
+		return nil, nil, []scanIssue{{Path: rel, Dir: true, Reason: reason}}
+	}
+
+	for _, e := range entries {
+		childRel := e.name
+		if rel != "" {
+			childRel = rel + "/" + e.name
+		}
+		if (e.dir && s.filter.excluded(childRel, true)) || (!e.dir && !s.filter.acceptFile(childRel)) {
+			continue
+		}
+		if s.skip.ShouldSkipFile(filepath.Join(dir, e.name)) {
+			issues = append(issues, scanIssue{Path: childRel, Dir: e.dir, Reason: "on the damaged files skip list"})
+			continue
+		}
+		switch {
+		case e.dir:
+			dirs = append(dirs, childRel)
+		case e.err != nil:
+			issues = append(issues, scanIssue{Path: childRel, Reason: e.err.Error()})
+		default:
+			files = append(files, fileMeta{rel: childRel, size: e.info.Size(), mod: e.info.ModTime()})
+		}
+	}
+	return files, dirs, issues
+}
+
+// readDirWithTimeout lists a folder and reads the info of its files, giving
+// up after timeout. As with statWithTimeout, a call that hangs is left
+// running in the background and its result is discarded.
+func readDirWithTimeout(parent context.Context, dir string, timeout time.Duration) ([]dirEntryInfo, error) {
+	type readResult struct {
+		entries []dirEntryInfo
+		err     error
+	}
+
+	ch := make(chan readResult, 1)
+	go func() {
+		list, err := os.ReadDir(dir)
+		if err != nil {
+			ch <- readResult{nil, err}
+			return
+		}
+		entries := make([]dirEntryInfo, 0, len(list))
+		for _, e := range list {
+			entry := dirEntryInfo{name: e.Name(), dir: e.IsDir()}
+			if !entry.dir {
+				entry.info, entry.err = e.Info()
+			}
+			entries = append(entries, entry)
+		}
+		ch <- readResult{entries, nil}
+	}()
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	select {
+	case result := <-ch:
+		return result.entries, result.err
+	case <-ctx.Done():
+		if parent.Err() != nil {
+			return nil, parent.Err()
+		}
+		return nil, fmt.Errorf("%w after %v", errListingTimeout, timeout)
+	}
+}
+
+// unscannedSet answers whether a compare key lies in or under an unscanned
+// path
+type unscannedSet struct {
+	files map[string]bool
+	dirs  map[string]bool
+}
+
+func newUnscannedSet(match matchPolicy, issues ...[]scanIssue) unscannedSet {
+	set := unscannedSet{files: make(map[string]bool), dirs: make(map[string]bool)}
+	for _, list := range issues {
+		for _, issue := range list {
+			if issue.Dir {
+				set.dirs[match.key(issue.Path)] = true
+			} else {
+				set.files[match.key(issue.Path)] = true
+			}
+		}
+	}
+	return set
+}
+
+func (set unscannedSet) covers(key string) bool {
+	if len(set.files) == 0 && len(set.dirs) == 0 {
+		return false
+	}
+	if set.files[key] || set.dirs[key] {
+		return true
+	}
+	for i := strings.LastIndexByte(key, '/'); i > 0; i = strings.LastIndexByte(key[:i], '/') {
+		if set.dirs[key[:i]] {
+			return true
+		}
+	}
+	return false
+}
+
+// dropUnscanned removes the files covered by the issues of either side from
+// both sides. A folder that could not be listed on one side would otherwise
+// look like its files exist on the other side only, and be copied or deleted.
+func dropUnscanned(match matchPolicy, srcIssues, dstIssues []scanIssue, srcMap, dstMap map[string]fileMeta) {
+	set := newUnscannedSet(match, srcIssues, dstIssues)
+	for _, m := range []map[string]fileMeta{srcMap, dstMap} {
+		for key := range m {
+			if set.covers(key) {
+				delete(m, key)
+			}
+		}
+	}
+}
+
+// describeIssue is the path of an issue as printed, with "/" after folders
+func describeIssue(issue scanIssue) string {
+	if issue.Dir {
+		return issue.Path + "/"
+	}
+	return issue.Path
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DEFAULT_SYNC_MAX_DELETE_PERCENT is the share of target files a sync may
+// delete before it refuses to run (--max-delete overrides it)
+const DEFAULT_SYNC_MAX_DELETE_PERCENT = 10.0
+
+// syncMtimeTolerance absorbs the 2 second timestamp resolution of FAT drives
+const syncMtimeTolerance = 2 * time.Second
+
+// syncCopy is a file to copy from source to target
+type syncCopy struct {
+	srcRel string // Relative path on the source
+	dstRel string // Relative path on the target (keeps the target's case for updates)
+	size   int64
+	reason string // "new" or "changed"
+}
+
+// syncDelete is a file only on the target, removed by --delete
+type syncDelete struct {
+	rel  string
+	size int64
+}
+
+// syncPlan lists what a sync does, in execution order: renames, copies and
+// deletions
+type syncPlan struct {
+	renames     []moveEntry
+	copies      []syncCopy
+	deletes     []syncDelete
+	skipped     []contentError // Pairs left alone because their content could not be checked
+	copyBytes   int64
+	deleteBytes int64
+}
+
+// parsePercent reads "20" or "20%"
+func parsePercent(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || v < 0 || v > 100 {
+		return 0, fmt.Errorf("invalid percentage: %s (expected 0-100)", s)
+	}
+	return v, nil
+}
+
+// planSync turns a comparison into the actions that make the target a mirror
+// of the source. Pairs are updated when their size differs, when --content
+// found different contents, or (without --content) when their modification
+// times differ.
+func planSync(res *CompareResult, opts compareOptions) *syncPlan {
+	plan := &syncPlan{renames: res.Moves}
+
+	for _, key := range res.onlySource {
+		meta := res.srcFiles[key]
+		plan.copies = append(plan.copies, syncCopy{srcRel: meta.rel, dstRel: meta.rel, size: meta.size, reason: "new"})
+		plan.copyBytes += meta.size
+	}
+
+	unchecked := make(map[string]bool)
+	for _, e := range res.ContentErrors {
+		unchecked[e.key] = true
+		plan.skipped = append(plan.skipped, e)
+	}
+	changed := make(map[string]bool)
+	for _, d := range res.Diffs {
+		changed[d.key] = true
+	}
+	for _, d := range res.ContentDiffs {
+		changed[d.key] = true
+	}
+	for key, sMeta := range res.srcFiles {
+		dMeta, ok := res.dstFiles[key]
+		if !ok || unchecked[key] {
+			continue
+		}
+		if !changed[key] {
+			// Checked contents are trusted; otherwise the times must agree
+			diff := sMeta.mod.Sub(dMeta.mod)
+			if res.ContentChecked || (diff <= syncMtimeTolerance && diff >= -syncMtimeTolerance) {
+				continue
+			}
+		}
+		plan.copies = append(plan.copies, syncCopy{srcRel: sMeta.rel, dstRel: dMeta.rel, size: sMeta.size, reason: "changed"})
+		plan.copyBytes += sMeta.size
+	}
+	sort.Slice(plan.copies, func(i, j int) bool { return plan.copies[i].srcRel < plan.copies[j].srcRel })
+
+	if opts.deleteExtra {
+		for _, key := range res.onlyTarget {
+			meta := res.dstFiles[key]
+			plan.deletes = append(plan.deletes, syncDelete{rel: meta.rel, size: meta.size})
+			plan.deleteBytes += meta.size
+		}
+		sort.Slice(plan.deletes, func(i, j int) bool { return plan.deletes[i].rel < plan.deletes[j].rel })
+	}
+	return plan
+}
+
+// deletePercent is the share of target files the plan deletes
+func (p *syncPlan) deletePercent(res *CompareResult) float64 {
+	if res.TargetTotalFiles == 0 {
+		return 0
+	}
+	return float64(len(p.deletes)) * 100 / float64(res.TargetTotalFiles)
+}
+
+// syncResult counts what a sync actually did
+type syncResult struct {
+	renamed     int64
+	copied      int64
+	copiedBytes int64
+	deleted     int64
+	deletedSize int64
+	errs        []string
+}
+
+// performSync mirrors the source onto the target with the fast copy engine.
+// With --dry-run only the plan is printed and logged. Deletions are refused
+// as a whole when they exceed the --max-delete share of the target, so a
+// wrong or empty source cannot wipe a backup.
+func performSync(res *CompareResult, opts compareOptions) error {
+	plan := planSync(res, opts)
+	maxDelete := DEFAULT_SYNC_MAX_DELETE_PERCENT
+	if opts.maxDeleteSet {
+		maxDelete = opts.maxDelete
+	}
+
+	fmt.Printf("\nSync plan:\n")
+	if len(plan.renames) > 0 {
+		fmt.Printf("  Rename on target: %d files\n", len(plan.renames))
+	}
+	fmt.Printf("  Copy to target: %d files, %s\n", len(plan.copies), formatBytesShort(uint64(plan.copyBytes)))
+	if opts.deleteExtra {
+		fmt.Printf("  Delete from target: %d files, %s (%.1f%% of target, limit %.1f%%)\n",
+			len(plan.deletes), formatBytesShort(uint64(plan.deleteBytes)), plan.deletePercent(res), maxDelete)
+	} else if len(res.onlyTarget) > 0 {
+		fmt.Printf("  Kept on target (only there, use --delete to remove): %d files\n", len(res.onlyTarget))
+	}
+	if len(plan.skipped) > 0 {
+		fmt.Printf("  Left alone (content could not be checked): %d files\n", len(plan.skipped))
+	}
+
+	tooMany := opts.deleteExtra && plan.deletePercent(res) > maxDelete
+	if opts.dryRun {
+		if tooMany {
+			fmt.Printf("  ⚠️  Deletions exceed the %.1f%% limit; a real run would be aborted\n", maxDelete)
+		}
+		fmt.Printf("Dry run: nothing was changed\n")
+		if err := writeSyncLog(res, plan, nil, opts, 0); err != nil {
+			fmt.Printf("Warning: cannot write sync log: %v\n", err)
+		}
+		return nil
+	}
+	if tooMany {
+		return fmt.Errorf("sync aborted: %d of %d target files (%.1f%%) would be deleted, limit is %.1f%% (raise it with --max-delete)",
+			len(plan.deletes), res.TargetTotalFiles, plan.deletePercent(res), maxDelete)
+	}
+	if len(plan.renames) == 0 && len(plan.copies) == 0 && len(plan.deletes) == 0 {
+		fmt.Printf("Target is already in sync\n")
+		return nil
+	}
+
+	start := time.Now()
+	result := &syncResult{}
+	if len(plan.renames) > 0 {
+		applyRenames(res)
+		result.renamed = res.RenamedOnTarget
+	}
+	if len(plan.copies) > 0 {
+		jobs := make([]copyJob, len(plan.copies))
+		for i, c := range plan.copies {
+			jobs[i] = copyJob{
+				rel:  c.srcRel,
+				from: filepath.Join(res.SourceRoot, c.srcRel),
+				to:   filepath.Join(res.TargetRoot, c.dstRel),
+				size: c.size,
+			}
+		}
+		result.copied, result.copiedBytes, result.errs = copyJobs(jobs)
+	}
+	for _, d := range plan.deletes {
+		if err := os.Remove(filepath.Join(res.TargetRoot, d.rel)); err != nil {
+			result.errs = append(result.errs, fmt.Sprintf("delete %s: %v", d.rel, err))
+			continue
+		}
+		result.deleted++
+		result.deletedSize += d.size
+	}
+
+	fmt.Printf("Copied to target: %d files, %s\n", result.copied, formatBytesShort(uint64(result.copiedBytes)))
+	if opts.deleteExtra {
+		fmt.Printf("Deleted from target: %d files, %s\n", result.deleted, formatBytesShort(uint64(result.deletedSize)))
+	}
+	fmt.Printf("Sync completed in %s\n", formatDuration(time.Since(start)))
+	if len(result.errs) > 0 {
+		fmt.Printf("Errors: %d (see log)\n", len(result.errs))
+	}
+	if err := writeSyncLog(res, plan, result, opts, time.Since(start)); err != nil {
+		fmt.Printf("Warning: cannot write sync log: %v\n", err)
+	}
+	return nil
+}
+
+// copyJob is one file copied by a sync
+type copyJob struct {
+	rel      string // Relative path, for reports
+	from, to string
+	size     int64
+}
+
+// copyJobs copies files in parallel with the fast copy engine and returns the
+// number of files and bytes copied and the errors. Every file is written to a
+// temporary name next to its destination and renamed over it when complete,
+// so an interrupted sync never leaves a half-written file behind.
+func copyJobs(jobs []copyJob) (int64, int64, []string) {
+	var totalBytes int64
+	for _, j := range jobs {
+		totalBytes += j.size
+	}
+	handler := globalInterruptHandler
+	if handler == nil {
+		handler = NewInterruptHandler()
+	}
+	config := NewFastCopyConfig()
+	progress := &FastCopyProgress{
+		StartTime:       time.Now(),
+		LastSpeedUpdate: time.Now(),
+		TotalFiles:      int64(len(jobs)),
+		TotalSize:       totalBytes,
+		ActualFiles:     int64(len(jobs)),
+		ActualSize:      totalBytes,
+		MaxThreads:      config.MaxConcurrentFiles,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				showFastProgress(progress)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var copied, copiedBytes int64
+	var errs []string
+	tasks := make(chan copyJob)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < config.MaxConcurrentFiles; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range tasks {
+				err := copyJobFile(j, progress, config, handler)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("copy %s: %v", j.rel, err))
+				} else {
+					copied++
+					copiedBytes += j.size
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, j := range jobs {
+		if handler.IsCancelled() {
+			break
+		}
+		tasks <- j
+	}
+	close(tasks)
+	wg.Wait()
+	close(done)
+	fmt.Println()
+
+	if handler.IsCancelled() {
+		errs = append(errs, "operation cancelled by user")
+	}
+	return copied, copiedBytes, errs
+}
+
+func copyJobFile(j copyJob, progress *FastCopyProgress, config FastCopyConfig, handler *InterruptHandler) error {
+	srcPath, dstPath := j.from, j.to
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return err
+	}
+	tmpPath := dstPath + ".filedo-sync.tmp"
+	if err := copyFileSingle(srcPath, tmpPath, info, progress, config, handler); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	// Damaged files are skipped by the copy engine without an error
+	if tmpInfo, err := os.Stat(tmpPath); err != nil || tmpInfo.Size() != info.Size() {
+		os.Remove(tmpPath)
+		return fmt.Errorf("not copied (damaged or skipped source file)")
+	}
+	if err := os.Chtimes(tmpPath, info.ModTime(), info.ModTime()); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, dstPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+func writeSyncLog(res *CompareResult, plan *syncPlan, result *syncResult, opts compareOptions, took time.Duration) error {
+	ts := time.Now().Format("20060102_150405")
+	kind := "report"
+	if result == nil {
+		kind = "plan"
+	}
+	name := fmt.Sprintf("sync_%s_%s.log", kind, ts)
+
+	var b strings.Builder
+	if result == nil {
+		b.WriteString("FileDO Sync Plan (dry run)\n")
+	} else {
+		b.WriteString("FileDO Sync Report\n")
+	}
+	b.WriteString(fmt.Sprintf("Generated: %s\n", time.Now().Format(time.RFC3339)))
+	b.WriteString(fmt.Sprintf("Source: %s\nTarget: %s\n", res.SourceRoot, res.TargetRoot))
+	b.WriteString(fmt.Sprintf("Delete extra files: %t\n", opts.deleteExtra))
+	if result != nil {
+		b.WriteString(fmt.Sprintf("Time: %s\n", formatDuration(took)))
+	}
+
+	b.WriteString("\nSummary\n")
+	b.WriteString(fmt.Sprintf("Planned renames: %d files\n", len(plan.renames)))
+	b.WriteString(fmt.Sprintf("Planned copies: %d files, %s\n", len(plan.copies), formatBytesShort(uint64(plan.copyBytes))))
+	b.WriteString(fmt.Sprintf("Planned deletions: %d files, %s\n", len(plan.deletes), formatBytesShort(uint64(plan.deleteBytes))))
+	if result != nil {
+		b.WriteString(fmt.Sprintf("Renamed: %d files\n", result.renamed))
+		b.WriteString(fmt.Sprintf("Copied: %d files, %s\n", result.copied, formatBytesShort(uint64(result.copiedBytes))))
+		b.WriteString(fmt.Sprintf("Deleted: %d files, %s\n", result.deleted, formatBytesShort(uint64(result.deletedSize))))
+	}
+
+	if len(plan.renames) > 0 {
+		b.WriteString("\nRename on target (old -> new):\n")
+		for _, m := range plan.renames {
+			b.WriteString(fmt.Sprintf("%s -> %s | %s\n", m.oldRel, m.newRel, formatBytesShort(uint64(m.size))))
+		}
+	}
+	if len(plan.copies) > 0 {
+		b.WriteString("\nCopy to target:\n")
+		for _, c := range plan.copies {
+			b.WriteString(fmt.Sprintf("%s | %s | %s\n", c.reason, c.srcRel, formatBytesShort(uint64(c.size))))
+		}
+	}
+	if len(plan.deletes) > 0 {
+		b.WriteString("\nDelete from target:\n")
+		for _, d := range plan.deletes {
+			b.WriteString(fmt.Sprintf("%s | %s\n", d.rel, formatBytesShort(uint64(d.size))))
+		}
+	}
+	if len(plan.skipped) > 0 {
+		b.WriteString("\nLeft alone (content could not be checked):\n")
+		for _, e := range plan.skipped {
+			b.WriteString(fmt.Sprintf("%s | %v\n", e.relPath, e.err))
+		}
+	}
+	if result != nil && len(result.errs) > 0 {
+		b.WriteString("\nErrors:\n")
+		for _, e := range result.errs {
+			b.WriteString(e + "\n")
+		}
+	}
+	if err := os.WriteFile(name, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Sync log saved to %s\n", name)
+	return nil
+}
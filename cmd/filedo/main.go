@@ -214,6 +214,7 @@ MAIN OPERATIONS:
   copy     → Copy files/folders with optimization
   wipe     → Fast wipe folder contents
   compare  → Compare directory trees
+  manifest → Save a folder listing for offline compare
   check    → Check files for corruption
 
 TARGETS:
@@ -297,7 +298,32 @@ File Organization:
   filedo.exe C: cd old move E:\Dups → Move older duplicate files to E:\Dups
   filedo.exe device D: cd del new  → Delete newer duplicate files (order doesn't matter)
   filedo.exe device D: cd del old  → Delete older duplicate files (flexible order)
-  filedo.exe cd from list dups.lst del new → Process duplicates from saved list file
+  filedo.exe D: cd hash xxh3       → Hash with xxh3 (md5|sha256|xxh3|blake3, default md5)
+  filedo.exe D: cd old del verify  → Byte-compare each duplicate with the original before deleting
+  filedo.exe D: cd hardlink verify → Replace duplicates with hard links to the original (same volume)
+  filedo.exe /data cd reflink      → Replace duplicates with copy-on-write clones (Linux btrfs/xfs)
+  filedo.exe D:\Photos cd add E:\Photos → Search several roots in one run (add may repeat)
+  filedo.exe E:\Staging cd ref D:\Master new del → Files under a reference root are never touched
+  filedo.exe D: cd --exclude .git --exclude node_modules --include-ext jpg,png --min-mb 1 --skip-hidden
+                                   → Filter like CHECK (--include/--exclude globs, --max-mb, extensions)
+  filedo.exe D: cd list dups.json  → Save JSON report (.csv for CSV, otherwise .lst text)
+  filedo.exe D:\Projects cd folders → Report identical folder trees as single groups (del/move whole trees with verify)
+  filedo.exe D:\Photos cd similar  → Group resized/re-encoded JPEG/PNG/GIF copies (dhash by default)
+  filedo.exe D:\Photos cd phash dist 6 list sim.json → ahash|dhash|phash, max Hamming distance (default 10)
+  filedo.exe D:\Photos cd similar review list sim.lst → Similar images are only deleted/moved after review
+                                     (or with allow-similar)
+  filedo.exe D: cd prefer D:\Master keep avoid:copy keep shortest del → Ordered rules choose the original
+                                   → prefer:<folder> prefer-name:<pattern> avoid:<pattern> shortest longest
+                                     shallowest deepest oldest newest alpha alpha-desc
+  filedo.exe D: cd policy keep.txt del → Read rules from a file (one per line, # comments)
+  filedo.exe E: cd mem 512 spill D:\Temp → Memory budget in MB (default 1024); larger file lists spill to disk
+  filedo.exe D:\Photos cd update   → Refresh the saved index: only changed folders are read, new files hashed
+  filedo.exe D:\Photos cd watch    → Keep the index current and report new duplicates until Ctrl+C
+  filedo.exe D: cd review list plan.lst → Step through groups, choose what to keep, undo; writes a plan
+  filedo.exe D: cd new move E:\Dups → Moves are journaled (E:\Dups\filedo_journal_*.jsonl, or journal <file>)
+  filedo.exe D: cd new quarantine E:\Q → Like del, but moves files to a dated folder E:\Q\quarantine_<date>
+  filedo.exe cd undo E:\Dups\filedo_journal_2025-01-02_150405.jsonl → Restore moved files (hash-verified)
+  filedo.exe cd from list dups.lst del new → Process duplicates from saved list file (.lst, .json)
 
 ═══════════════════════════════════════════════════════════════════════════════
 FOLDER OPERATIONS (Local directories)
@@ -429,10 +455,38 @@ Folder Compare:
 		filedo.exe cmp D:\Source E:\Target del big target    → Delete only when bigger side is Target
 		filedo.exe cmp D:\Source E:\Target del old target    → Delete only when older side is Target
 		filedo.exe cmp D:\Source E:\Target del new source    → Delete only when newer side is Source
-	Notes: matching by relative path; size-only comparison; mtime used for old/new; permanent delete; no confirmation
+	filedo.exe cmp D:\Source E:\Target --content   → Also compare contents of equal-size pairs (cached hashes)
+	filedo.exe cmp D:\Source E:\Target --bytes     → Same, byte by byte; --hash md5|sha256|xxh3|blake3
+	filedo.exe cmp D:\Source E:\Target --moves     → Report moved/renamed files (same size and content hash)
+	filedo.exe cmp D:\Source E:\Target rename      → Rename moved files on Target to their Source paths (no recopy)
+	filedo.exe cmp D:\Source E:\Target sync        → Mirror Source to Target: copy new/changed files, keep mtimes
+	filedo.exe cmp D:\Source E:\Target sync --delete --dry-run → Also delete files only in Target; only show the plan
+	filedo.exe cmp D:\Source E:\Target sync --delete --max-delete 25 → Abort if >25%% of Target would be deleted (default 10)
+	filedo.exe cmp D:\Laptop E:\NAS bisync        → Two-way plan against the last sync: changed/new/deleted per side, conflicts
+	filedo.exe cmp D:\Laptop E:\NAS bisync apply  → Execute all non-conflicting actions, keep conflicts for review
+	filedo.exe cmp D:\Source E:\Target --exclude "*.tmp" --exclude cache/ → Skip files/folders (gitignore-style globs)
+	filedo.exe cmp D:\Source E:\Target --include "*.jpg" --min-mb 1 --max-mb 500 → Only matching files within size bounds
+	  (patterns in .filedoignore at the root of either folder are always applied; excluded files are never counted or deleted)
+	filedo.exe cmp D:\Source E:\Target --report json,csv,html → Also write every entry as JSON/CSV and an HTML tree report
+	filedo.exe cmp D:\Source E:\Target --report-file audit.html → Write one report to the given file (format from extension)
+	filedo.exe cmp D:\FromMac E:\Target --match nocase,unicode → Match paths case-insensitively and across NFC/NFD spellings
+	  (--match exact|nocase|unicode, comma-separated; default nocase on Windows, exact elsewhere; paths several files match are reported as collisions)
+	filedo.exe cmp \\NAS\Share E:\Target --dir-timeout 60 → Give up on a folder listing after 60s (default 30s)
+	  (unreadable, timed-out and skip_files.list folders are left out on both sides and listed in the log; timed-out folders join the skip list)
+
+Manifests (offline snapshots; accepted by cmp in place of either folder):
+	filedo.exe manifest create E:\Backup backup.manifest          → Record path, size and mtime of every file
+	filedo.exe manifest create E:\Backup backup.manifest --hash xxh3 → Also record content hashes
+	filedo.exe cmp D:\Data backup.manifest                        → What changed since the backup (disk not attached)
+	filedo.exe cmp E:\Backup backup.manifest --content            → Is the disk still identical to its manifest
+	filedo.exe manifest diff jan.manifest feb.manifest             → Differences between two dates
+	Notes: matching by relative path; size-only comparison unless --content; mtime used for old/new; permanent delete; no confirmation
 
 Folder Health Check:
 	filedo.exe check D:\Data                 → Read-check all files; mark damaged on read delay > 2.0s
+	filedo.exe check D:\Data --baseline --hash sha256 → Read fully and record checksums in D:\Data\.filedo_checksums.json
+	filedo.exe check D:\Data --verify        → Read fully and compare with the recorded checksums (or .md5/.sha256 files)
+	filedo.exe check D:\Data --mode full --slow-ms 100 --report json → Time every chunk; report latency per file and slow regions
 	Notes: one-time warm-up up to 10.0s before first read; uses 'skip_files.list' immediately; parallel workers; Ctrl+C supported
 
 ═══════════════════════════════════════════════════════════════════════════════
@@ -565,10 +619,11 @@ var list_of_flags_for_smartcopy = []string{"smartcopy", "smart", "auto"}
 var list_of_flags_for_safecopy = []string{"safecopy", "safe", "rescue", "damaged"}
 var list_of_flags_for_check = []string{"check"}
 var list_of_flags_for_wipe = []string{"wipe", "w"}
+var list_of_flags_for_manifest = []string{"manifest", "mf"}
 var list_fo_flags_for_help = []string{"?", "/?", "-?", "--help", "help", "h", "/help"}
 var list_fo_flags_for_short_help = []string{"?", "/?", "-?", "--help"}
 var list_fo_flags_for_full_help = []string{"help", "h", "/help"}
-var list_of_flags_for_all = append(append(append(append(append(append(append(append(append(append(append(append(append(append(append(append(list_of_flags_for_device, list_of_flags_for_folder...), list_of_flags_for_file...), list_of_flags_for_network...), list_of_flags_for_from...), list_of_flags_for_hist...), list_of_flags_for_duplicates...), list_of_flags_for_compare...), list_of_flags_for_copy...), list_of_flags_for_fastcopy...), list_of_flags_for_synccopy...), list_of_flags_for_balanced...), list_of_flags_for_maxcopy...), list_of_flags_for_smartcopy...), list_of_flags_for_safecopy...), list_of_flags_for_check...), list_of_flags_for_wipe...)
+var list_of_flags_for_all = append(append(append(append(append(append(append(append(append(append(append(append(append(append(append(append(append(list_of_flags_for_device, list_of_flags_for_folder...), list_of_flags_for_file...), list_of_flags_for_network...), list_of_flags_for_from...), list_of_flags_for_hist...), list_of_flags_for_duplicates...), list_of_flags_for_compare...), list_of_flags_for_copy...), list_of_flags_for_fastcopy...), list_of_flags_for_synccopy...), list_of_flags_for_balanced...), list_of_flags_for_maxcopy...), list_of_flags_for_smartcopy...), list_of_flags_for_safecopy...), list_of_flags_for_check...), list_of_flags_for_wipe...), list_of_flags_for_manifest...)
 
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
@@ -756,8 +811,8 @@ func executeInternalCommand(args []string) error {
 		runGenericCommand(networkCmd, CommandNetwork, add_args, internalLogger)
 	case contains(list_of_flags_for_duplicates, command):
 		// Handle check-duplicates command
-		if len(args) > 1 && strings.ToLower(args[1]) == "from" {
-			internalLogger.SetCommand(command, "from", "check-duplicates")
+		if len(args) > 1 && (strings.ToLower(args[1]) == "from" || strings.ToLower(args[1]) == "undo") {
+			internalLogger.SetCommand(command, strings.ToLower(args[1]), "check-duplicates")
 			err := handleCheckDuplicatesCommand(args)
 			if err != nil {
 				internalLogger.SetError(err)
@@ -877,6 +932,16 @@ func executeInternalCommand(args []string) error {
 			return err
 		}
 		internalLogger.SetSuccess()
+	case contains(list_of_flags_for_manifest, command):
+		if len(args) < 4 {
+			return fmt.Errorf("manifest command requires an operation and two paths")
+		}
+		internalLogger.SetCommand(command, args[2], "manifest")
+		if err := handleManifestCommand(args[1:]); err != nil {
+			internalLogger.SetError(err)
+			return err
+		}
+		internalLogger.SetSuccess()
 	case contains(list_of_flags_for_from, command):
 		// Handle from file command (nested call)
 		if len(args) < 2 {
@@ -1164,8 +1229,8 @@ func main() {
 	}
 
 	// Check for direct cd from command (without device/folder/network context)
-	if contains(list_of_flags_for_duplicates, lowerArgs[1]) && len(args) > 2 && lowerArgs[2] == "from" {
-		historyLogger.SetCommand(lowerArgs[1], "from", "check-duplicates")
+	if contains(list_of_flags_for_duplicates, lowerArgs[1]) && len(args) > 2 && (lowerArgs[2] == "from" || lowerArgs[2] == "undo") {
+		historyLogger.SetCommand(lowerArgs[1], lowerArgs[2], "check-duplicates")
 		// Pass original command to handler (preserve case in paths)
 		err := handleCheckDuplicatesCommand(args[1:])
 		if err != nil {
@@ -1394,6 +1459,19 @@ func main() {
 		}
 		historyLogger.SetSuccess()
 		return
+	case contains(list_of_flags_for_manifest, command):
+		if len(add_args) < 3 {
+			fmt.Fprintf(os.Stderr, "Error: MANIFEST command requires an operation and two paths\n")
+			return
+		}
+		historyLogger.SetCommand(command, add_args[1], "manifest")
+		if err := handleManifestCommand(add_args); err != nil {
+			historyLogger.SetError(err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return
+		}
+		historyLogger.SetSuccess()
+		return
 	default:
 		fmt.Fprintf(os.Stderr, "Error: Unknown command '%s'\n\n", os.Args[1])
 		fmt.Println(usage)
@@ -12,7 +12,10 @@ func findDuplicatesUsingPackage(rootPath string, args []string) error {
 	startTime := time.Now()
 
 	// Parse options from command line arguments
-	options := fileduplicates.ParseArguments(args)
+	options, err := fileduplicates.ParseArguments(args)
+	if err != nil {
+		return err
+	}
 
 	// Run the duplicate finder
 	result, err := fileduplicates.FindDuplicates(rootPath, options)
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"filedo/fileduplicates"
 	"filedo/helpers"
 )
 
@@ -11,7 +12,7 @@ import (
 // including processing from a file list.
 func handleCheckDuplicatesCommand(args []string) error {
 	if len(args) < 3 {
-		return fmt.Errorf("not enough arguments for the command. Usage: cd from list <file_path> [options]")
+		return fmt.Errorf("not enough arguments for the command. Usage: cd from list <file_path> [options] or cd undo <journal>")
 	}
 
 	cmd := strings.ToLower(args[0])
@@ -19,6 +20,11 @@ func handleCheckDuplicatesCommand(args []string) error {
 		return fmt.Errorf("unknown command: %s", args[0])
 	}
 
+	// "cd undo journal.jsonl" restores files moved by an earlier run
+	if strings.ToLower(args[1]) == "undo" {
+		return fileduplicates.UndoJournal(args[2])
+	}
+
 	// Проверяем, что команда имеет формат "cd from list file.lst [options]"
 	if strings.ToLower(args[1]) != "from" || strings.ToLower(args[2]) != "list" {
 		return fmt.Errorf("invalid command format. Usage: cd from list <file_path> [options]")
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"filedo/fileduplicates"
+)
+
+// MANIFEST_VERSION is the format version written by "manifest create"
+const MANIFEST_VERSION = 1
+
+// Manifest is a saved listing of a folder. Compare accepts it in place of
+// either folder, so a disk can be checked against its last state, or two
+// states against each other, without the other copy attached.
+type Manifest struct {
+	Version   int                          `json:"version"`
+	Root      string                       `json:"root"`
+	Created   time.Time                    `json:"created"`
+	Algorithm fileduplicates.HashAlgorithm `json:"algorithm,omitempty"` // Empty when no hashes were recorded
+	Files     []ManifestFile               `json:"files"`
+	Unscanned []scanIssue                  `json:"unscanned,omitempty"` // Folders and files that could not be read
+
+	path string
+}
+
+// ManifestFile is one file of a manifest
+type ManifestFile struct {
+	Path    string    `json:"path"` // Relative, slash-separated
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Hash    string    `json:"hash,omitempty"`
+}
+
+// handleManifestCommand runs "manifest create <folder> <file>" and
+// "manifest diff <old> <new>"
+func handleManifestCommand(args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("usage: manifest create <folder> <file> [--hash md5|sha256|xxh3|blake3] | manifest diff <old> <new>")
+	}
+	switch strings.ToLower(args[0]) {
+	case "create":
+		opts, rest, err := parseCompareOptions(args[3:])
+		if err != nil {
+			return err
+		}
+		if len(rest) > 0 {
+			return fmt.Errorf("unknown manifest option: %s", rest[0])
+		}
+		return createManifest(args[1], args[2], opts)
+	case "diff":
+		for _, path := range args[1:3] {
+			if info, err := os.Stat(path); err != nil || info.IsDir() {
+				return fmt.Errorf("not a manifest file: %s", path)
+			}
+		}
+		return handleCompareCommand(args[1], args[2], args[3:]...)
+	}
+	return fmt.Errorf("unknown manifest operation: %s (allowed: create|diff)", args[0])
+}
+
+// createManifest records the files of root, with hashes when --hash (or
+// --content) was given. The filters and .filedoignore of compare apply.
+func createManifest(root, path string, opts compareOptions) error {
+	root = filepath.Clean(root)
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return fmt.Errorf("not a directory: %s", root)
+	}
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	start := time.Now()
+	fmt.Printf("📋 Creating manifest of %s...\n", root)
+
+	filter, err := newCompareFilter(root, root, compareOptions{include: opts.include, exclude: opts.exclude, minSize: opts.minSize, maxSize: opts.maxSize})
+	if err != nil {
+		return err
+	}
+	files, issues, err := scanFiles(root, filter, opts.dirTimeout)
+	if err != nil {
+		return err
+	}
+	filterSizes(filter, files, map[string]fileMeta{})
+
+	manifest := &Manifest{Version: MANIFEST_VERSION, Root: root, Created: time.Now(), Unscanned: issues}
+	for _, meta := range files {
+		manifest.Files = append(manifest.Files, ManifestFile{Path: meta.rel, Size: meta.size, ModTime: meta.mod})
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool { return manifest.Files[i].Path < manifest.Files[j].Path })
+
+	if opts.content {
+		manifest.Algorithm = opts.algorithm
+		hashManifest(manifest)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling manifest: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("cannot write manifest: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("cannot write manifest: %w", err)
+	}
+
+	var total int64
+	for _, f := range manifest.Files {
+		total += f.Size
+	}
+	if filters := filter.describe(); filters != "" {
+		fmt.Printf("Filters: %s\n", filters)
+	}
+	fmt.Printf("Manifest saved to %s: %d files, %s", path, len(manifest.Files), formatBytesShort(uint64(total)))
+	if manifest.Algorithm != "" {
+		fmt.Printf(", %s hashes", manifest.Algorithm)
+	}
+	fmt.Println()
+	if len(issues) > 0 {
+		fmt.Printf("Not scanned: %d folders and files, recorded in the manifest and left out when it is compared\n", len(issues))
+	}
+	fmt.Printf("Completed in %s\n", formatDuration(time.Since(start)))
+	return nil
+}
+
+// hashManifest hashes the files of a manifest in parallel through the
+// shared hash cache. Files that cannot be read are left without a hash.
+func hashManifest(manifest *Manifest) {
+	cache, err := fileduplicates.LoadHashCache()
+	if err != nil {
+		fmt.Printf("Warning: hash cache unavailable: %v\n", err)
+	}
+	defer cache.Close()
+	fmt.Printf("Hashing %d files (%s)...\n", len(manifest.Files), manifest.Algorithm)
+
+	workerCount := runtime.NumCPU()
+	if workerCount > 8 {
+		workerCount = 8 // Disk bound; more readers only add seeking
+	}
+	indexes := make(chan int, workerCount*4)
+	var done, failed int64
+	var wg sync.WaitGroup
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				f := &manifest.Files[i]
+				hash, err := fileduplicates.HashFile(cache, filepath.Join(manifest.Root, f.Path), manifest.Algorithm)
+				if err != nil {
+					atomic.AddInt64(&failed, 1)
+					fmt.Printf("\nWarning: cannot hash %s: %v\n", f.Path, err)
+				} else {
+					f.Hash = hash
+				}
+				if n := atomic.AddInt64(&done, 1); n%100 == 0 {
+					fmt.Printf("  Hashed %d/%d files\r", n, len(manifest.Files))
+				}
+			}
+		}()
+	}
+	for i := range manifest.Files {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	fmt.Printf("  Hashed %d/%d files\n", len(manifest.Files)-int(failed), len(manifest.Files))
+
+	if err := cache.Save(); err != nil {
+		fmt.Printf("Warning: cannot save hash cache: %v\n", err)
+	}
+}
+
+// loadManifest reads a manifest written by "manifest create"
+func loadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.Version == 0 {
+		return nil, fmt.Errorf("%s is not a FileDO manifest", path)
+	}
+	if manifest.Version > MANIFEST_VERSION {
+		return nil, fmt.Errorf("manifest %s has version %d, this FileDO reads up to %d", path, manifest.Version, MANIFEST_VERSION)
+	}
+	manifest.path = path
+	return &manifest, nil
+}
+
+// manifestFiles returns the files of a manifest accepted by filter, keyed
+// by relative path like a folder scan
+func manifestFiles(manifest *Manifest, filter *compareFilter) map[string]fileMeta {
+	m := make(map[string]fileMeta, len(manifest.Files))
+	for _, f := range manifest.Files {
+		if !filter.acceptPath(f.Path) {
+			continue
+		}
+		m[f.Path] = fileMeta{rel: f.Path, size: f.Size, mod: f.ModTime, hash: f.Hash}
+	}
+	return m
+}
+
+// describeManifest is the line printed for a manifest side of a comparison
+func describeManifest(manifest *Manifest) string {
+	s := fmt.Sprintf("manifest of %s, created %s, %d files", manifest.Root, manifest.Created.Format("2006-01-02 15:04:05"), len(manifest.Files))
+	if manifest.Algorithm != "" {
+		s += ", " + string(manifest.Algorithm) + " hashes"
+	}
+	return s
+}
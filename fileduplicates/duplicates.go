@@ -6,9 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"time"
 )
 
@@ -20,6 +19,7 @@ type DuplicateResult struct {
 	DuplicateSize   int64                          // Total size of duplicate files
 	Groups          map[string][]DuplicateFileInfo // Map of groups by hash
 	ProcessingTime  time.Duration                  // Total processing time
+	Roots           []string                       // All roots that were scanned
 }
 
 // Progress information for ongoing search
@@ -33,56 +33,95 @@ type ProgressInfo struct {
 	EstimatedETA string        // Estimated time remaining
 }
 
-// FindDuplicates finds duplicate files in a directory tree
+// FindDuplicates finds duplicate files in a directory tree. Roots listed in
+// options.ExtraRoots and options.ReferenceRoots are scanned in the same run and
+// share one size/hash index, so duplicates between different drives are found.
 func FindDuplicates(rootPath string, options DuplicateOptions) (*DuplicateResult, error) {
 	startTime := time.Now()
 
 	// Create a result structure
+	roots := collectRoots(rootPath, options)
 	result := &DuplicateResult{
 		Groups: make(map[string][]DuplicateFileInfo),
+		Roots:  roots,
 	}
 
 	// Load hash cache
 	cache, err := LoadHashCache()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: Failed to load hash cache: %v\n", err)
-		cache = &HashCache{
-			Entries: make(map[string]CacheEntry),
-		}
 	}
+	defer cache.Close()
 
-	// Create worker pool for hash calculation
+	// Folder and similar modes start a worker pool where they hash; the
+	// pipeline hashes on its own goroutines
 	workerCount := GetOptimalWorkerCount()
-	worker := NewHashWorker(workerCount)
+
+	if options.HashAlgorithm == "" {
+		options.HashAlgorithm = HashMD5
+	}
 
 	if options.Verbose {
-		fmt.Printf("Using %d workers for hash calculation\n", workerCount)
+		fmt.Printf("Cache loaded with %d entries.\n", len(cache.index))
+		algorithm := options.HashAlgorithm
+		if options.Similar {
+			algorithm = options.SimilarAlgorithm
+		}
+		fmt.Printf("Using %d workers for hash calculation (%s)\n", workerCount, algorithm)
+		if len(options.Policy) > 0 {
+			fmt.Printf("Selection policy: %s\n", options.Policy)
+		}
+		if len(roots) > 1 {
+			for _, root := range roots {
+				if isUnderAnyRoot(root, options.ReferenceRoots) {
+					fmt.Printf("  Root: %s (reference, never modified)\n", root)
+				} else {
+					fmt.Printf("  Root: %s\n", root)
+				}
+			}
+		}
+	}
+
+	// Indexed modes only look at what changed since the previous run
+	if (options.Update || options.Watch) && (options.Folders || options.Similar) {
+		fmt.Println("Warning: update and watch work on files only, running a full search")
+	} else if options.Watch {
+		return watchDuplicates(result, options, cache)
+	} else if options.Update {
+		return findDuplicatesIndexed(result, options, cache, startTime)
 	}
 
+	// Folder mode builds its own tree of the roots
+	if options.Folders {
+		return findDuplicateFolders(result, options, cache, startTime)
+	}
+
+	// A root inside another root is covered by the outer one
+	walkRoots := disjointRoots(roots)
+
 	// First scan to estimate total file count (for progress reporting)
 	totalFiles := 0
 	if options.Verbose {
 		fmt.Println("Scanning directory for files...")
-		err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return nil // Skip files with errors
-			}
-			if !info.IsDir() && info.Size() >= MIN_DUPLICATE_FILE_SIZE {
+		for _, root := range walkRoots {
+			err := walkFiltered(root, options.Filter, func(path string, info os.FileInfo) {
 				totalFiles++
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Error during file scan: %v\n", err)
 			}
-			return nil
-		})
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: Error during file scan: %v\n", err)
 		}
 		fmt.Printf("Found %d files to check.\n", totalFiles)
 	}
 
-	// Maps to track duplicates
+	// Files are bucketed by size within the memory budget; similar mode only
+	// keeps the images, which it compares all with each other
+	buckets := newSizeBuckets(options)
+	defer buckets.cleanup()
 	filesBySize := make(map[int64][]DuplicateFileInfo)
-	filesByQuickHash := make(map[string][]DuplicateFileInfo)
+	var bucketErr error
 
-	// Scan files and calculate quick hashes
+	// Scan files
 	filesScanned := 0
 	fmt.Println("Scanning for duplicates...")
 
@@ -90,240 +129,97 @@ func FindDuplicates(rootPath string, options DuplicateOptions) (*DuplicateResult
 	lastProgressUpdate := time.Now()
 	progressUpdateInterval := 500 * time.Millisecond
 
-	err = filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip files with errors
-		}
-
-		if !info.IsDir() && info.Size() >= MIN_DUPLICATE_FILE_SIZE {
-			filesScanned++
-
-			// Update progress
-			now := time.Now()
-			if options.Verbose && now.Sub(lastProgressUpdate) > progressUpdateInterval {
-				lastProgressUpdate = now
-				elapsed := now.Sub(startTime)
-
-				// Calculate progress percentage and ETA
-				percentDone := 0.0
-				eta := "unknown"
-				if totalFiles > 0 {
-					percentDone = float64(filesScanned) * 100 / float64(totalFiles)
-					if filesScanned > 0 && percentDone > 0 {
-						timePerFile := elapsed.Seconds() / float64(filesScanned)
-						remainingFiles := totalFiles - filesScanned
-						rs := timePerFile * float64(remainingFiles)
-						eta = formatETA(time.Duration(rs) * time.Second)
-					}
-				}
+	scanFile := func(path string, info os.FileInfo) {
+		filesScanned++
 
-				// Update progress display
-				fmt.Printf("\rScanning: %s [%d/%d files, %.1f%%, ETA: %s]",
-					path, filesScanned, totalFiles, percentDone, eta)
-			}
+		if options.Similar && !isSimilarCandidate(path) {
+			return
+		}
 
-			// Get file info
-			fileInfo, err := GetFileInfo(path)
-			if err != nil {
-				return nil // Skip problematic files
+		// Update progress
+		now := time.Now()
+		if options.Verbose && now.Sub(lastProgressUpdate) > progressUpdateInterval {
+			lastProgressUpdate = now
+			elapsed := now.Sub(startTime)
+
+			// Calculate progress percentage and ETA
+			percentDone := 0.0
+			eta := "unknown"
+			if totalFiles > 0 {
+				percentDone = float64(filesScanned) * 100 / float64(totalFiles)
+				if filesScanned > 0 && percentDone > 0 {
+					timePerFile := elapsed.Seconds() / float64(filesScanned)
+					remainingFiles := totalFiles - filesScanned
+					rs := timePerFile * float64(remainingFiles)
+					eta = formatETA(time.Duration(rs) * time.Second)
+				}
 			}
 
-			// Group by file size first
-			filesBySize[fileInfo.Size] = append(filesBySize[fileInfo.Size], fileInfo)
+			// Update progress display
+			fmt.Printf("\rScanning: %s [%d/%d files, %.1f%%, ETA: %s]",
+				path, filesScanned, totalFiles, percentDone, eta)
 		}
-		return nil
-	})
-
-	if options.Verbose {
-		fmt.Println() // End the progress line
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("error walking directory: %v", err)
-	}
 
-	// Process potential duplicates by size
-	var potentialDuplicates []DuplicateFileInfo
-	for _, files := range filesBySize {
-		if len(files) > 1 {
-			potentialDuplicates = append(potentialDuplicates, files...)
+		if !options.Similar {
+			if bucketErr == nil {
+				bucketErr = buckets.add(sizedFile{path: path, size: info.Size(), modTime: info.ModTime()})
+			}
+			return
 		}
-	}
 
-	if len(potentialDuplicates) == 0 {
-		fmt.Println("No duplicate files found.")
-		return result, nil
+		// Get file info
+		fileInfo, err := GetFileInfo(path)
+		if err != nil {
+			return // Skip problematic files
+		}
+		fileInfo.Algorithm = options.HashAlgorithm
+		fileInfo.IsReference = isUnderAnyRoot(path, options.ReferenceRoots)
+		filesBySize[fileInfo.Size] = append(filesBySize[fileInfo.Size], fileInfo)
 	}
 
-	// Calculate quick hashes for potential duplicates
-	if options.Verbose {
-		fmt.Printf("Found %d potential duplicates by size. Calculating quick hashes...\n",
-			len(potentialDuplicates))
-	}
-
-	// Submit jobs to calculate quick hashes.
-	// Flow: cache lookup (read-only) -> misses go to the worker pool ->
-	// results are stored in the cache and aggregated by a consumer goroutine.
-	resultsMutex := sync.Mutex{}
-	processedCount := int64(0)
-	totalCount := int64(len(potentialDuplicates))
-	quickHashStartTime := time.Now()
-
-	reportQuick := func() {
-		processed := atomic.AddInt64(&processedCount, 1)
-		if options.Verbose && processed%100 == 0 {
-			elapsed := time.Since(quickHashStartTime)
-			percent := float64(processed) / float64(totalCount) * 100
-			eta := "unknown"
-			if processed > 0 {
-				timePerFile := elapsed.Seconds() / float64(processed)
-				remainingFiles := totalCount - processed
-				rs := timePerFile * float64(remainingFiles)
-				eta = formatETA(time.Duration(rs) * time.Second)
-			}
-			fmt.Printf("Quick hash progress: %d/%d (%.1f%%, ETA: %s)\r",
-				processed, totalCount, percent, eta)
-		}
-	}
-
-	// Consumer goroutine: started BEFORE submitting so workers never block on a
-	// full results channel (which would otherwise deadlock the submit loop).
-	quickConsumerDone := make(chan struct{})
-	go func() {
-		defer close(quickConsumerDone)
-		for result := range worker.results {
-			if result.err == nil {
-				cache.StoreHash(result.file, QuickHash)
-				resultsMutex.Lock()
-				filesByQuickHash[result.file.QuickHash] = append(
-					filesByQuickHash[result.file.QuickHash], result.file)
-				resultsMutex.Unlock()
-			}
-			reportQuick()
-		}
-	}()
-
-	for _, file := range potentialDuplicates {
-		// Use a copy of file to avoid race condition in the closure
-		fileCopy := file
-
-		// Read-only cache lookup; never compute on the main goroutine.
-		if quickHash, found := cache.LookupHash(fileCopy, QuickHash); found {
-			fileCopy.QuickHash = quickHash
-			resultsMutex.Lock()
-			filesByQuickHash[quickHash] = append(filesByQuickHash[quickHash], fileCopy)
-			resultsMutex.Unlock()
-			reportQuick()
-		} else {
-			// Cache miss: let a worker compute the hash.
-			worker.AddJob(fileCopy, QuickHash)
+	for _, root := range walkRoots {
+		if err = walkFiltered(root, options.Filter, scanFile); err != nil {
+			break
 		}
 	}
 
-	// Wait for all queued jobs, then for the consumer to drain all results.
-	worker.Wait()
-	<-quickConsumerDone
-
-	// Final progress update
 	if options.Verbose {
-		fmt.Printf("Quick hash progress: %d/%d (100.0%%) - Complete\n",
-			totalCount, totalCount)
+		fmt.Println() // End the progress line
 	}
 
-	// Process files with matching quick hashes for full hash comparison
-	var duplicateGroups [][]DuplicateFileInfo
-
-	// New worker for full hashes
-	worker = NewHashWorker(workerCount)
-	filesByFullHash := make(map[string][]DuplicateFileInfo)
-
-	// Count files that need full hash calculation
-	fullHashCount := int64(0)
-	for _, files := range filesByQuickHash {
-		if len(files) > 1 {
-			fullHashCount += int64(len(files))
-		}
+	if err != nil {
+		return nil, fmt.Errorf("error walking directory: %v", err)
 	}
-
-	fullHashProcessed := int64(0)
-	fullHashStartTime := time.Now()
-	if options.Verbose && fullHashCount > 0 {
-		fmt.Printf("Found %d files requiring full hash calculation...\n", fullHashCount)
+	if bucketErr != nil {
+		return nil, bucketErr
 	}
 
-	reportFull := func() {
-		processed := atomic.AddInt64(&fullHashProcessed, 1)
-		if options.Verbose && processed%50 == 0 {
-			elapsed := time.Since(fullHashStartTime)
-			percent := float64(processed) / float64(fullHashCount) * 100
-			eta := "unknown"
-			if processed > 0 {
-				timePerFile := elapsed.Seconds() / float64(processed)
-				remainingFiles := fullHashCount - processed
-				rs := timePerFile * float64(remainingFiles)
-				eta = formatETA(time.Duration(rs) * time.Second)
-			}
-			fmt.Printf("Full hash progress: %d/%d (%.1f%%, ETA: %s)\r",
-				processed, fullHashCount, percent, eta)
-		}
-	}
-
-	// Consumer goroutine: started BEFORE submitting (see quick-hash stage).
-	fullConsumerDone := make(chan struct{})
-	go func() {
-		defer close(fullConsumerDone)
-		for result := range worker.results {
-			if result.err == nil {
-				cache.StoreHash(result.file, FullHash)
-				resultsMutex.Lock()
-				filesByFullHash[result.file.FullHash] = append(
-					filesByFullHash[result.file.FullHash], result.file)
-				resultsMutex.Unlock()
-			}
-			reportFull()
-		}
-	}()
-
-	// Find groups with matching quick hashes
-	for _, files := range filesByQuickHash {
-		if len(files) > 1 {
-			// Submit for full hash calculation
-			for _, file := range files {
-				fileCopy := file
-
-				// Read-only cache lookup; never compute on the main goroutine.
-				if fullHash, found := cache.LookupHash(fileCopy, FullHash); found {
-					fileCopy.FullHash = fullHash
-					resultsMutex.Lock()
-					filesByFullHash[fullHash] = append(filesByFullHash[fullHash], fileCopy)
-					resultsMutex.Unlock()
-					reportFull()
-				} else {
-					// Cache miss: let a worker compute the hash.
-					worker.AddJob(fileCopy, FullHash)
-				}
-			}
+	// Similar mode compares pictures, not bytes, so every image is a candidate
+	if options.Similar {
+		if options.Action == HardlinkAction || options.Action == ReflinkAction {
+			fmt.Println("Warning: similar images are not identical, links are not created (report only)")
+			options.Action = NoAction
+		} else if options.Action != NoAction && !options.Review && !options.AllowSimilar {
+			fmt.Println("Warning: similar images are not identical, nothing is deleted or moved without review")
+			fmt.Println("         (use review, or allow-similar to act on every group as found)")
+			options.Action = NoAction
 		}
-	}
-
-	// Wait for all queued jobs, then for the consumer to drain all results.
-	worker.Wait()
-	<-fullConsumerDone
-
-	// Final progress update
-	if options.Verbose && fullHashCount > 0 {
-		fmt.Printf("Full hash progress: %d/%d (100.0%%) - Complete\n",
-			fullHashCount, fullHashCount)
-	}
-
-	// Find true duplicates by full hash
-	for fullHash, files := range filesByFullHash {
-		if len(files) > 1 {
-			duplicateGroups = append(duplicateGroups, files)
-			result.Groups[fullHash] = files
+		duplicateGroups := findSimilarImages(filesBySize, options, cache)
+		for i, group := range duplicateGroups {
+			result.Groups[fmt.Sprintf("%s#%d", FormatHash(group[0].Algorithm, group[0].FullHash), i+1)] = group
 		}
+		return finishDuplicateSearch(result, options, duplicateGroups, cache, startTime, filesScanned)
 	}
 
+	// Size buckets, quick hashes and full hashes are processed as a pipeline;
+	// each group is handled as soon as its bucket is done
+	return processDuplicateStream(result, options, buckets, cache, startTime, filesScanned)
+}
+
+// finishDuplicateSearch saves the cache, applies the action to the found
+// groups and fills in the statistics of the result.
+func finishDuplicateSearch(result *DuplicateResult, options DuplicateOptions, duplicateGroups [][]DuplicateFileInfo,
+	cache *HashCache, startTime time.Time, filesScanned int) (*DuplicateResult, error) {
 	// Save cache
 	if err := cache.Save(); err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: Failed to save hash cache: %v\n", err)
@@ -335,24 +231,40 @@ func FindDuplicates(rootPath string, options DuplicateOptions) (*DuplicateResult
 		return result, nil
 	}
 
-	// Process duplicate groups - mark original files and apply actions
-	options.BatchMode = ProcessDuplicateGroups(duplicateGroups, options)
+	// Confirm groups byte-for-byte before anything is moved or deleted.
+	// Similar images differ by definition, so there is nothing to verify.
+	if options.Verify && options.Similar {
+		fmt.Println("Verify: skipped, similar images are not byte-identical")
+	} else if options.Verify && options.Action != NoAction {
+		duplicateGroups = VerifyDuplicateGroups(duplicateGroups, options)
+		result.Groups = make(map[string][]DuplicateFileInfo)
+		for i, group := range duplicateGroups {
+			result.Groups[fmt.Sprintf("%s#%d", group[0].FullHash, i+1)] = group
+		}
+	}
+
+	// Review mode only writes the curated plan; "cd from list" applies it
+	if options.Review {
+		result.DuplicateGroups = len(duplicateGroups)
+		decided := ReviewDuplicateGroups(duplicateGroups, options, os.Stdin)
+		planPath := planPathFor(options)
+		if err := WriteReviewPlan(planPath, result, decided); err != nil {
+			return result, err
+		}
+		fmt.Printf("Plan with %d groups saved to: %s\n", len(decided), planPath)
+		fmt.Printf("Apply it with: filedo cd from list %s del (or move <folder>)\n", planPath)
+		options.OutputFileSpecified = false // The plan replaces the report
+	} else {
+		// Process duplicate groups - mark original files and apply actions
+		options.BatchMode = ProcessDuplicateGroups(duplicateGroups, options)
+	}
 
 	// Calculate statistics
 	result.TotalFiles = filesScanned
 	result.DuplicateGroups = len(duplicateGroups)
 	result.ProcessingTime = time.Since(startTime)
-
-	// Count duplicates and size
 	for _, group := range duplicateGroups {
-		// Count all but one file in each group as duplicates
-		result.DuplicateFiles += len(group) - 1
-
-		// Calculate wasted space
-		if len(group) > 0 {
-			// Multiply by number of duplicates (all files minus the original)
-			result.DuplicateSize += group[0].Size * int64(len(group)-1)
-		}
+		addGroupStats(result, group)
 	}
 
 	// Output results
@@ -363,130 +275,252 @@ func FindDuplicates(rootPath string, options DuplicateOptions) (*DuplicateResult
 	return result, nil
 }
 
+// addGroupStats counts the files of a processed group in the result: all but
+// the original are duplicates, and their sizes are wasted space (similar
+// images may differ in size)
+func addGroupStats(result *DuplicateResult, group []DuplicateFileInfo) {
+	result.DuplicateFiles += len(group) - 1
+	for j := 1; j < len(group); j++ {
+		result.DuplicateSize += group[j].Size
+	}
+}
+
 // ProcessDuplicateGroups marks original files and processes duplicates according to options
 // It returns a boolean indicating if the batch mode was enabled during processing.
 func ProcessDuplicateGroups(duplicateGroups [][]DuplicateFileInfo, options DuplicateOptions) bool {
-	// Process each group
+	processor := newGroupProcessor(options)
 	for i := range duplicateGroups {
-		group := duplicateGroups[i]
+		// Update the group in case files were moved/deleted
+		duplicateGroups[i] = processor.process(duplicateGroups[i])
+	}
+	return processor.close()
+}
 
+// groupProcessor applies the action to one group at a time, so groups can be
+// handled as soon as they are found. All groups of a run share one journal.
+type groupProcessor struct {
+	options      DuplicateOptions
+	journal      *Journal // Moved and quarantined files, so the run can be undone
+	linkFailures []string // Files that could not be replaced by a link (e.g. on another volume)
+}
+
+// newGroupProcessor prepares the journal for actions that move files away
+func newGroupProcessor(options DuplicateOptions) *groupProcessor {
+	p := &groupProcessor{options: options}
+	p.journal = prepareJournal(&p.options)
+	return p
+}
+
+// process marks the original of a group and applies the action to the other
+// files. It returns the group in its final order.
+func (p *groupProcessor) process(group []DuplicateFileInfo) []DuplicateFileInfo {
+	if p.options.KeepMarked {
+		// Reviewed plan: the marked files are kept, whatever the
+		// selection mode. A group without a kept file is never touched.
+		sort.SliceStable(group, func(a, b int) bool {
+			return group[a].IsOriginal && !group[b].IsOriginal
+		})
+		if len(group) == 0 || !group[0].IsOriginal {
+			return group
+		}
+	} else {
+		// Marks read from an ordinary list describe the run that wrote
+		// it; the selection mode decides again
+		for j := range group {
+			group[j].IsOriginal = false
+		}
 		// Sort the group according to selection mode
-		sortDuplicateGroup(&group, options.SelectionMode)
+		sortDuplicateGroup(&group, p.options)
+	}
 
-		// Mark the first file as original
-		if len(group) > 0 {
-			group[0].IsOriginal = true
+	// Mark the first file as original. Files under a reference root are
+	// always originals (sorting puts them first).
+	if len(group) > 0 {
+		group[0].IsOriginal = true
+	}
+	for j := range group {
+		if group[j].IsReference {
+			group[j].IsOriginal = true
 		}
+	}
 
-		// Apply action to duplicate files (all but first)
-		if options.Action != NoAction {
-			for j := 1; j < len(group); j++ {
-				file := group[j]
+	// Never act on duplicates when the original they rely on is gone or
+	// has changed since scanning
+	if p.options.Action != NoAction && len(group) > 0 {
+		if reason := checkUnchanged(group[0]); reason != "" {
+			fmt.Printf("Skipped group of %s: original %s\n", group[0].Path, reason)
+			return group
+		}
+	}
 
-				// Check if file still exists before processing
-				if _, err := os.Stat(file.Path); os.IsNotExist(err) {
-					// File doesn't exist anymore (already processed), skip
-					continue
+	// Apply action to duplicate files (all but first)
+	if p.options.Action != NoAction {
+		for j := 1; j < len(group); j++ {
+			file := group[j]
+
+			// Files under a reference root and files kept by a reviewed
+			// plan are never moved or deleted
+			if file.IsReference || file.IsOriginal {
+				continue
+			}
+
+			// Check if file still exists before processing
+			if _, err := os.Stat(file.Path); os.IsNotExist(err) {
+				// File doesn't exist anymore (already processed), skip
+				continue
+			}
+
+			// Re-stat right before acting: a file modified since scanning
+			// may no longer be a duplicate
+			if reason := checkUnchanged(file); reason != "" {
+				fmt.Printf("Skipped: %s (%s)\n", file.Path, reason)
+				continue
+			}
+
+			switch p.options.Action {
+			case DeleteAction:
+				// Check if we're in interactive mode
+				if !p.options.BatchMode {
+					// Ask for confirmation if deleting
+					kind := "file"
+					if file.IsDir {
+						kind = fmt.Sprintf("folder (%d files)", file.FileCount)
+					}
+					verb := "Delete"
+					if p.options.Quarantine != "" {
+						verb = "Quarantine"
+					}
+					fmt.Printf("%s duplicate %s: %s? (y/n/a, a=all): ", verb, kind, file.Path)
+					var response string
+					fmt.Scanln(&response)
+					responseLower := strings.ToLower(response)
+
+					if responseLower == "a" {
+						// Set batch mode to true so we don't ask for future files
+						p.options.BatchMode = true
+						// Fall through to delete code
+					} else if responseLower != "y" {
+						// Skip this file if response is not "y" or "a"
+						fmt.Println("Skipped")
+						continue
+					}
 				}
 
-				switch options.Action {
-				case DeleteAction:
-					// Check if we're in interactive mode
-					if !options.BatchMode {
-						// Ask for confirmation if deleting
-						fmt.Printf("Delete duplicate file: %s? (y/n/a, a=all): ", file.Path)
-						var response string
-						fmt.Scanln(&response)
-						responseLower := strings.ToLower(response)
-
-						if responseLower == "a" {
-							// Set batch mode to true so we don't ask for future files
-							options.BatchMode = true
-							// Fall through to delete code
-						} else if responseLower != "y" {
-							// Skip this file if response is not "y" or "a"
-							fmt.Println("Skipped")
-							continue
+				// Quarantine keeps the file in a dated folder instead
+				if p.options.Quarantine != "" {
+					quarantined := quarantinePath(p.options.Quarantine, file.Path)
+					err := os.MkdirAll(filepath.Dir(quarantined), 0755)
+					if err == nil {
+						err = moveFile(file.Path, quarantined)
+					}
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Error quarantining file %s: %v\n", file.Path, err)
+					} else {
+						fmt.Printf("Quarantined: %s -> %s\n", file.Path, quarantined)
+						if p.journal != nil {
+							p.journal.Record("quarantine", file, quarantined)
 						}
 					}
+					continue
+				}
 
-					// Delete the file
-					if err := os.Remove(file.Path); err != nil {
-						fmt.Fprintf(os.Stderr, "Error deleting file %s: %v\n", file.Path, err)
-					} else {
-						fmt.Printf("Deleted: %s\n", file.Path)
+				// Delete the file, or the whole tree of a duplicate folder
+				remove := os.Remove
+				if file.IsDir {
+					remove = os.RemoveAll
+				}
+				if err := remove(file.Path); err != nil {
+					fmt.Fprintf(os.Stderr, "Error deleting file %s: %v\n", file.Path, err)
+				} else {
+					fmt.Printf("Deleted: %s\n", file.Path)
+				}
+
+			case MoveAction:
+				if p.options.TargetDir != "" {
+					// Create target directory if it doesn't exist
+					if err := os.MkdirAll(p.options.TargetDir, 0755); err != nil {
+						fmt.Fprintf(os.Stderr, "Error creating target directory: %v\n", err)
+						continue
 					}
 
-				case MoveAction:
-					if options.TargetDir != "" {
-						// Create target directory if it doesn't exist
-						if err := os.MkdirAll(options.TargetDir, 0755); err != nil {
-							fmt.Fprintf(os.Stderr, "Error creating target directory: %v\n", err)
-							continue
-						}
+					// Get base filename
+					fileName := filepath.Base(file.Path)
+					targetPath := filepath.Join(p.options.TargetDir, fileName)
 
-						// Get base filename
-						fileName := filepath.Base(file.Path)
-						targetPath := filepath.Join(options.TargetDir, fileName)
-
-						// Handle filename collision
-						counter := 1
-						for {
-							if _, err := os.Stat(targetPath); os.IsNotExist(err) {
-								break // File doesn't exist, so we can use this name
-							}
-
-							ext := filepath.Ext(fileName)
-							name := fileName[:len(fileName)-len(ext)]
-							targetPath = filepath.Join(options.TargetDir,
-								fmt.Sprintf("%s_(%d)%s", name, counter, ext))
-							counter++
+					// Handle filename collision
+					counter := 1
+					for {
+						if _, err := os.Stat(targetPath); os.IsNotExist(err) {
+							break // File doesn't exist, so we can use this name
 						}
 
-						// Move the file
-						if err := os.Rename(file.Path, targetPath); err != nil {
-							fmt.Fprintf(os.Stderr, "Error moving file %s: %v\n", file.Path, err)
-						} else {
-							fmt.Printf("Moved: %s -> %s\n", file.Path, targetPath)
+						ext := filepath.Ext(fileName)
+						name := fileName[:len(fileName)-len(ext)]
+						targetPath = filepath.Join(p.options.TargetDir,
+							fmt.Sprintf("%s_(%d)%s", name, counter, ext))
+						counter++
+					}
+
+					// Move the file, copying it across volumes
+					if err := moveFile(file.Path, targetPath); err != nil {
+						fmt.Fprintf(os.Stderr, "Error moving file %s: %v\n", file.Path, err)
+					} else {
+						fmt.Printf("Moved: %s -> %s\n", file.Path, targetPath)
+						if p.journal != nil {
+							p.journal.Record("move", file, targetPath)
 						}
 					}
 				}
+
+			case HardlinkAction, ReflinkAction:
+				if err := replaceWithLink(group[0].Path, file.Path, p.options.Action); err != nil {
+					p.linkFailures = append(p.linkFailures, fmt.Sprintf("%s: %v", file.Path, err))
+				} else if p.options.Action == HardlinkAction {
+					fmt.Printf("Hardlinked: %s -> %s\n", file.Path, group[0].Path)
+				} else {
+					fmt.Printf("Reflinked: %s -> %s\n", file.Path, group[0].Path)
+				}
 			}
 		}
-
-		// Update the group in case files were moved/deleted
-		duplicateGroups[i] = group
 	}
-	return options.BatchMode
-}
 
-// Sort a group of duplicate files according to selection mode
-func sortDuplicateGroup(group *[]DuplicateFileInfo, mode DuplicateSelectionMode) {
-	switch mode {
-	case OldestAsOriginal:
-		// Sort by creation time ascending (oldest first)
-		sort.Slice(*group, func(i, j int) bool {
-			return (*group)[i].CreatedTime.Before((*group)[j].CreatedTime)
-		})
-
-	case NewestAsOriginal:
-		// Sort by creation time descending (newest first)
-		sort.Slice(*group, func(i, j int) bool {
-			return (*group)[i].CreatedTime.After((*group)[j].CreatedTime)
-		})
+	return group
+}
 
-	case FirstAlphaAsOriginal:
-		// Sort alphabetically ascending
-		sort.Slice(*group, func(i, j int) bool {
-			return (*group)[i].Path < (*group)[j].Path
-		})
+// close finishes the journal and lists files that could not be linked. It
+// returns whether batch mode was enabled during processing.
+func (p *groupProcessor) close() bool {
+	if p.journal != nil {
+		p.journal.Close()
+	}
+	if len(p.linkFailures) > 0 {
+		fmt.Printf("\n%d files could not be linked and were left unchanged:\n", len(p.linkFailures))
+		for _, failure := range p.linkFailures {
+			fmt.Printf("  %s\n", failure)
+		}
+	}
+	return p.options.BatchMode
+}
 
-	case LastAlphaAsOriginal:
-		// Sort alphabetically descending
-		sort.Slice(*group, func(i, j int) bool {
-			return (*group)[i].Path > (*group)[j].Path
-		})
+// Sort a group of duplicate files so the preferred original comes first.
+// The selection policy decides when one is set, otherwise the selection mode;
+// remaining ties keep path order.
+func sortDuplicateGroup(group *[]DuplicateFileInfo, options DuplicateOptions) {
+	policy := options.Policy
+	if len(policy) == 0 {
+		policy = policyForMode(options.SelectionMode)
 	}
+	sort.Slice(*group, func(i, j int) bool {
+		return (*group)[i].Path < (*group)[j].Path
+	})
+	sort.SliceStable(*group, func(i, j int) bool {
+		return policy.less((*group)[i], (*group)[j])
+	})
+
+	// Reference files always come first so one of them becomes the original
+	sort.SliceStable(*group, func(i, j int) bool {
+		return (*group)[i].IsReference && !(*group)[j].IsReference
+	})
 }
 
 // OutputResults writes duplicate information to console and file
@@ -501,6 +535,23 @@ func OutputResults(result *DuplicateResult, options DuplicateOptions, duplicateG
 
 	// Output to file if specified
 	if options.OutputFileSpecified {
+		format := ReportFormatFor(options.OutputPath, options.ReportFormat)
+		if format == ReportJSON || format == ReportCSV {
+			report := BuildReport(result, options, duplicateGroups)
+			var err error
+			if format == ReportJSON {
+				err = writeJSONReport(options.OutputPath, report)
+			} else {
+				err = writeCSVReport(options.OutputPath, report)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing %s report: %v\n", format, err)
+				return
+			}
+			fmt.Printf("Duplicate %s report saved to: %s\n", strings.ToUpper(format), options.OutputPath)
+			return
+		}
+
 		file, err := os.Create(options.OutputPath)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error creating output file: %v\n", err)
@@ -514,7 +565,12 @@ func OutputResults(result *DuplicateResult, options DuplicateOptions, duplicateG
 		// Write header
 		fmt.Fprintf(writer, "# Duplicate files report\n")
 		fmt.Fprintf(writer, "# Date: %s\n", time.Now().Format(time.RFC1123))
-		fmt.Fprintf(writer, "# Root path: %s\n", options.OutputPath)
+		fmt.Fprintf(writer, "# Root path: %s\n", strings.Join(result.Roots, "; "))
+		if options.Similar {
+			fmt.Fprintf(writer, "# Similar images: %s, max distance %d\n", options.SimilarAlgorithm, options.MaxDistance)
+		} else {
+			fmt.Fprintf(writer, "# Hash algorithm: %s\n", options.HashAlgorithm.normalize())
+		}
 		fmt.Fprintf(writer, "# Total files: %d\n", result.TotalFiles)
 		fmt.Fprintf(writer, "# Duplicate groups: %d\n", result.DuplicateGroups)
 		fmt.Fprintf(writer, "# Duplicate files: %d\n", result.DuplicateFiles)
@@ -522,8 +578,19 @@ func OutputResults(result *DuplicateResult, options DuplicateOptions, duplicateG
 
 		// Write each group
 		for i, group := range duplicateGroups {
-			fmt.Fprintf(writer, "# Group %d (%d files, %.2f MB each)\n",
-				i+1, len(group), float64(group[0].Size)/(1024*1024))
+			if group[0].IsDir {
+				fmt.Fprintf(writer, "# Group %d (%d folders, %d files, %.2f MB each)\n",
+					i+1, len(group), group[0].FileCount, float64(group[0].Size)/(1024*1024))
+			} else {
+				fmt.Fprintf(writer, "# Group %d (%d files, %.2f MB each)\n",
+					i+1, len(group), float64(group[0].Size)/(1024*1024))
+			}
+			if group[0].FullHash != "" {
+				fmt.Fprintf(writer, "# Hash: %s\n", FormatHash(group[0].Algorithm, group[0].FullHash))
+			}
+			if scores := similarityScores(group); scores != "" {
+				fmt.Fprintf(writer, "# Similarity: %s\n", scores)
+			}
 
 			for _, file := range group {
 				originalMark := " "
@@ -539,14 +606,40 @@ func OutputResults(result *DuplicateResult, options DuplicateOptions, duplicateG
 	}
 }
 
-// ParseArguments parses command line arguments for duplicate processing
-func ParseArguments(args []string) DuplicateOptions {
+// ParseArguments parses command line arguments for duplicate processing.
+// A selection rule or policy file that cannot be read is an error, since the
+// policy decides which copy is kept.
+func ParseArguments(args []string) (DuplicateOptions, error) {
 	options := DefaultOptions()
 
 	// Process arguments in any order
 	for i := 0; i < len(args); i++ {
 		arg := strings.ToLower(args[i])
 
+		// Filter options use the same names as the check command and accept
+		// both "--name value" and "--name=value"
+		if strings.HasPrefix(arg, "-") {
+			name := strings.TrimLeft(arg, "-")
+			value := ""
+			hasValue := false
+			if eq := strings.Index(name, "="); eq >= 0 {
+				value = args[i][strings.Index(args[i], "=")+1:]
+				name = name[:eq]
+				hasValue = true
+			}
+			nextValue := func() string {
+				if !hasValue && i+1 < len(args) {
+					i++
+					return args[i]
+				}
+				return value
+			}
+			if !parseFilterOption(&options.Filter, name, nextValue) {
+				fmt.Fprintf(os.Stderr, "Warning: unknown option '%s'\n", args[i])
+			}
+			continue
+		}
+
 		// Check for output file specification
 		if arg == "list" && i+1 < len(args) {
 			options.OutputPath = args[i+1]
@@ -585,10 +678,173 @@ func ParseArguments(args []string) DuplicateOptions {
 			if options.SelectionMode != NewestAsOriginal {
 				options.BatchMode = true
 			}
+		case "hardlink", "link", "hl":
+			options.Action = HardlinkAction
+		case "reflink", "clone":
+			options.Action = ReflinkAction
+		case "add", "and":
+			if i+1 < len(args) {
+				options.ExtraRoots = append(options.ExtraRoots, args[i+1])
+				i++ // Skip the next argument as it's the additional root
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: '%s' option specified without a path\n", arg)
+			}
+		case "ref", "reference":
+			if i+1 < len(args) {
+				options.ReferenceRoots = append(options.ReferenceRoots, args[i+1])
+				i++ // Skip the next argument as it's the reference root
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: '%s' option specified without a path\n", arg)
+			}
+		case "format":
+			if i+1 < len(args) {
+				options.ReportFormat = strings.ToLower(args[i+1])
+				i++ // Skip the next argument as it's the format
+			}
+		case "json", "csv":
+			options.ReportFormat = arg
+		case "verify", "ver":
+			options.Verify = true
+		case "hash", "algo":
+			if i+1 < len(args) {
+				if algorithm, ok := ParseHashAlgorithm(args[i+1]); ok {
+					options.HashAlgorithm = algorithm
+				} else {
+					fmt.Fprintf(os.Stderr, "Warning: unknown hash algorithm '%s', using %s\n", args[i+1], options.HashAlgorithm)
+				}
+				i++ // Skip the next argument as it's the algorithm name
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: '%s' option specified without an algorithm\n", arg)
+			}
+		case "md5", "sha256", "xxh3", "blake3":
+			options.HashAlgorithm, _ = ParseHashAlgorithm(arg)
+		case "quarantine", "trash":
+			if i+1 < len(args) {
+				options.Action = DeleteAction
+				options.Quarantine = args[i+1]
+				i++ // Skip the next argument as it's the quarantine folder
+				if options.SelectionMode != NewestAsOriginal {
+					options.BatchMode = true
+				}
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: '%s' option specified without a folder\n", arg)
+			}
+		case "journal":
+			if i+1 < len(args) {
+				options.JournalPath = args[i+1]
+				i++ // Skip the next argument as it's the journal file
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: '%s' option specified without a file\n", arg)
+			}
+		case "keep", "rule":
+			if i+1 < len(args) {
+				rule, err := ParseSelectionRule(args[i+1])
+				if err != nil {
+					return options, err
+				}
+				options.Policy = append(options.Policy, rule)
+				i++ // Skip the next argument as it's the rule
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: '%s' option specified without a rule\n", arg)
+			}
+		case "prefer":
+			if i+1 < len(args) {
+				options.Policy = append(options.Policy, SelectionRule{Kind: "prefer", Value: args[i+1]})
+				i++ // Skip the next argument as it's the preferred folder
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: '%s' option specified without a folder\n", arg)
+			}
+		case "policy":
+			if i+1 < len(args) {
+				policy, err := LoadSelectionPolicy(args[i+1])
+				if err != nil {
+					return options, err
+				}
+				options.Policy = append(options.Policy, policy...)
+				i++ // Skip the next argument as it's the policy file
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: '%s' option specified without a file\n", arg)
+			}
+		case "review", "interactive":
+			options.Review = true
+		case "mem", "memory":
+			if i+1 < len(args) {
+				if mb, err := strconv.Atoi(args[i+1]); err == nil && mb > 0 {
+					options.MemoryLimitMB = mb
+				} else {
+					fmt.Fprintf(os.Stderr, "Warning: invalid memory limit '%s', using %d MB\n", args[i+1], DEFAULT_MEMORY_LIMIT_MB)
+				}
+				i++ // Skip the limit
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: '%s' option specified without a size in MB\n", arg)
+			}
+		case "spill":
+			if i+1 < len(args) {
+				options.SpillDir = args[i+1]
+				i++ // Skip the folder
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: '%s' option specified without a folder\n", arg)
+			}
+		case "update":
+			options.Update = true
+		case "watch":
+			options.Watch = true
+		case "folders", "dirs":
+			options.Folders = true
+		case "similar", "sim":
+			options.Similar = true
+		case "allow-similar":
+			options.AllowSimilar = true
+		case "ahash", "dhash", "phash":
+			options.Similar = true
+			options.SimilarAlgorithm, _ = ParsePerceptualAlgorithm(arg)
+		case "distance", "dist":
+			if i+1 < len(args) {
+				if distance, err := strconv.Atoi(args[i+1]); err == nil && distance >= 0 && distance <= 64 {
+					options.MaxDistance = distance
+				} else {
+					fmt.Fprintf(os.Stderr, "Warning: invalid distance '%s', using %d\n", args[i+1], options.MaxDistance)
+				}
+				i++ // Skip the next argument as it's the distance
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: '%s' option specified without a value\n", arg)
+			}
 		}
 	}
 
-	return options
+	return options, nil
+}
+
+// parseFilterOption applies a single filter option to the filter. next
+// returns the option value. It reports false for unknown options.
+func parseFilterOption(filter *FileFilter, name string, next func() string) bool {
+	switch name {
+	case "include":
+		filter.IncludePatterns = append(filter.IncludePatterns, next())
+	case "exclude":
+		filter.ExcludePatterns = append(filter.ExcludePatterns, next())
+	case "include-ext":
+		filter.IncludeExt = ParseExtSet(next())
+	case "exclude-ext":
+		filter.ExcludeExt = ParseExtSet(next())
+	case "min-mb", "max-mb":
+		value := next()
+		mb, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: invalid size '%s' for --%s\n", value, name)
+			return true
+		}
+		if name == "min-mb" {
+			filter.MinSize = MBToBytes(mb)
+		} else {
+			filter.MaxSize = MBToBytes(mb)
+		}
+	case "skip-hidden", "no-hidden":
+		filter.SkipHidden = true
+	default:
+		return false
+	}
+	return true
 }
 
 // LoadFileList loads a list of files to check from a file
@@ -640,6 +896,8 @@ func ProcessDuplicateGroupsFromList(duplicateGroups map[string][]DuplicateFileIn
 				// Update size and modtime from actual file
 				file.Size = stat.Size()
 				file.ModTime = stat.ModTime()
+				file.IsReference = isUnderAnyRoot(file.Path, options.ReferenceRoots) ||
+					(file.IsDir && containsAnyRoot(file.Path, options.ReferenceRoots))
 				validFiles = append(validFiles, file)
 			} else {
 				fmt.Printf("Warning: File not found: %s, skipping\n", file.Path)
@@ -663,6 +921,34 @@ func ProcessDuplicateGroupsFromList(duplicateGroups map[string][]DuplicateFileIn
 	fmt.Printf("Found %d duplicate groups from list (%d of %d files are valid)\n",
 		len(groupsSlice), totalFiles-skippedFiles, totalFiles)
 
+	// Folder trees are only re-checked at the top before acting, so their
+	// content has to be confirmed first
+	if (options.Action == DeleteAction || options.Action == MoveAction) && !options.Verify {
+		for _, group := range groupsSlice {
+			if group[0].IsDir {
+				return fmt.Errorf("list contains duplicate folders; deleting or moving them requires verify")
+			}
+		}
+	}
+
+	// Similar images are not identical; only a reviewed plan or an explicit
+	// opt-in may delete or move them
+	if options.Action != NoAction && !options.KeepMarked && !options.AllowSimilar {
+		for _, group := range groupsSlice {
+			if group[0].Algorithm.isPerceptual() {
+				return fmt.Errorf("list contains similar images (%s), which are not identical; review them first or add allow-similar", group[0].Algorithm)
+			}
+		}
+	}
+
+	// Lists may be stale or hand-edited, so confirm content when requested
+	if options.Verify && options.Action != NoAction {
+		groupsSlice = VerifyDuplicateGroups(groupsSlice, options)
+		if len(groupsSlice) == 0 {
+			return fmt.Errorf("no duplicate groups passed verification")
+		}
+	}
+
 	// Process the groups
 	ProcessDuplicateGroups(groupsSlice, options)
 
@@ -1,8 +1,11 @@
 package fileduplicates
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -25,183 +28,343 @@ func GetHashCachePath() string {
 
 // Initialize the hash cache
 func NewHashCache() *HashCache {
-	cache := &HashCache{
-		Entries: make(map[string]CacheEntry),
-	}
-
-	// Try to load cached hashes
-	cache.Load()
-
+	cache, _ := LoadHashCache()
 	return cache
 }
 
-// Get a hash from cache or return empty string if not found
-func (c *HashCache) Get(path string, size int64, hashType FileHashType) string {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-
-	if entry, ok := c.Entries[path]; ok && entry.Size == size {
-		// Return the requested hash type
-		if hashType == QuickHash {
-			return entry.QuickHash
-		} else if hashType == FullHash {
-			return entry.FullHash
-		}
+// keyFor returns the cache key of a file: its volume and file ID when known,
+// otherwise a hash of its path.
+func keyFor(volume, fileID uint64, path string) cacheKey {
+	if fileID != 0 {
+		return cacheKey{volume: volume, fileID: fileID}
 	}
+	h := fnv.New64a()
+	h.Write([]byte(path))
+	return cacheKey{fileID: h.Sum64()}
+}
+
+// get returns the latest entry for key, from unsaved entries or the log.
+// The caller must hold the mutex.
+func (c *HashCache) get(key cacheKey) (CacheEntry, bool) {
+	if entry, ok := c.pending[key]; ok {
+		return entry, true
+	}
+	ref, ok := c.index[key]
+	if !ok || c.file == nil {
+		return CacheEntry{}, false
+	}
+	buf := make([]byte, ref.length)
+	if _, err := c.file.ReadAt(buf, ref.offset); err != nil {
+		return CacheEntry{}, false
+	}
+	var entry CacheEntry
+	if err := json.Unmarshal(buf, &entry); err != nil {
+		return CacheEntry{}, false
+	}
+	return entry, true
+}
 
-	return ""
+// matches reports whether a cached entry still describes the file: same size,
+// modification time and algorithm, and the same path for entries that are not
+// keyed by file identity.
+func (entry CacheEntry) matches(file DuplicateFileInfo) bool {
+	if entry.Size != file.Size || !entry.ModTime.Equal(file.ModTime) {
+		return false
+	}
+	if entry.Algorithm.normalize() != file.Algorithm.normalize() {
+		return false
+	}
+	if file.FileID == 0 && entry.Path != file.Path {
+		return false
+	}
+	return true
 }
 
 // LookupHash returns a cached hash for the file without ever computing it.
 // The cache entry is only considered valid when both Size and ModTime still
 // match the file, so a changed file that kept the same size will miss the cache.
+// Entries hashed with a different algorithm than the file requests also miss.
 func (c *HashCache) LookupHash(file DuplicateFileInfo, hashType FileHashType) (string, bool) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	entry, ok := c.Entries[file.Path]
-	if !ok {
+	key := keyFor(file.Volume, file.FileID, file.Path)
+	entry, ok := c.get(key)
+	if !ok || !entry.matches(file) {
 		return "", false
 	}
-	// Validate file identity: size + modification time must match.
-	if entry.Size != file.Size || !entry.ModTime.Equal(file.ModTime) {
-		return "", false
+
+	hash := entry.QuickHash
+	if hashType == FullHash {
+		hash = entry.FullHash
 	}
-	if hashType == QuickHash && entry.QuickHash != "" {
-		return entry.QuickHash, true
+	if hash == "" {
+		return "", false
 	}
-	if hashType == FullHash && entry.FullHash != "" {
-		return entry.FullHash, true
+
+	// Refresh LastSeen at most once a day so hits do not grow the log
+	if time.Since(entry.LastSeen) > 24*time.Hour {
+		entry.Path = file.Path
+		entry.LastSeen = time.Now()
+		c.pending[key] = entry
 	}
-	return "", false
+	return hash, true
 }
 
 // StoreHash records a freshly computed hash for the file. If an existing entry
-// describes a different version of the file (size or modtime changed) it is
-// replaced so stale hashes never linger. LastSeen and ModTime are always
-// refreshed.
+// describes a different version of the file (size or modtime changed) or was
+// hashed with a different algorithm it is replaced so stale hashes never
+// linger. LastSeen and ModTime are always refreshed.
 func (c *HashCache) StoreHash(file DuplicateFileInfo, hashType FileHashType) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	entry, ok := c.Entries[file.Path]
-	if !ok || entry.Size != file.Size || !entry.ModTime.Equal(file.ModTime) {
+	key := keyFor(file.Volume, file.FileID, file.Path)
+	entry, ok := c.get(key)
+	if !ok || !entry.matches(file) {
 		entry = CacheEntry{
-			Path:    file.Path,
-			Size:    file.Size,
-			ModTime: file.ModTime,
+			Volume:    file.Volume,
+			FileID:    file.FileID,
+			Size:      file.Size,
+			ModTime:   file.ModTime,
+			Algorithm: file.Algorithm.normalize(),
 		}
 	}
+	entry.Path = file.Path
 	if hashType == QuickHash {
 		entry.QuickHash = file.QuickHash
 	} else {
 		entry.FullHash = file.FullHash
 	}
 	entry.LastSeen = time.Now()
-	c.Entries[file.Path] = entry
-}
-
-// Store a hash in the cache
-func (c *HashCache) Store(file DuplicateFileInfo) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+	c.pending[key] = entry
 
-	c.Entries[file.Path] = CacheEntry{
-		Path:      file.Path,
-		Size:      file.Size,
-		ModTime:   file.ModTime,
-		QuickHash: file.QuickHash,
-		FullHash:  file.FullHash,
-		LastSeen:  time.Now(),
+	if len(c.pending) >= HASH_CACHE_FLUSH_EVERY {
+		if err := c.flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to write hash cache: %v\n", err)
+		}
 	}
 }
 
-// Save the cache to disk
+// Save appends all unsaved entries to the cache log and compacts the log when
+// it holds many superseded records or entries not seen for MaxAge.
 func (c *HashCache) Save() error {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-
-	// Clean up any entries older than 30 days and remove entries for files that no longer exist
-	threshold := time.Now().AddDate(0, 0, -30)
-	filtered := make(map[string]CacheEntry)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	entriesCount := len(c.Entries)
-	processedCount := 0
-	removedCount := 0
+	if err := c.flush(); err != nil {
+		return err
+	}
 
-	fmt.Printf("Optimizing hash cache (%d entries)...\n", entriesCount)
+	threshold := time.Now().Add(-c.MaxAge)
+	manyStale := c.records > 2*len(c.index)+HASH_CACHE_FLUSH_EVERY
+	expired := c.MaxAge > 0 && !c.oldestSeen.IsZero() && c.oldestSeen.Before(threshold)
+	if c.file == nil || (!manyStale && !expired) {
+		return nil
+	}
+	return c.compact(threshold)
+}
 
-	for path, entry := range c.Entries {
-		processedCount++
+// Close releases the cache files. Unsaved entries are discarded.
+func (c *HashCache) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-		// Show periodic progress for large caches
-		if processedCount%1000 == 0 || processedCount == entriesCount {
-			fmt.Printf("\r  Cache cleanup progress: %d/%d entries (removed: %d)...",
-				processedCount, entriesCount, removedCount)
-		}
+	if c.file != nil {
+		c.file.Close()
+		c.file = nil
+	}
+	if c.lock != nil {
+		c.lock.Close()
+		c.lock = nil
+	}
+}
 
-		// Keep entry if it's recent and the file is unchanged (same size + modtime).
-		if entry.LastSeen.After(threshold) {
-			if info, err := os.Stat(path); err == nil &&
-				info.Size() == entry.Size && info.ModTime().Equal(entry.ModTime) {
-				filtered[path] = entry
-				continue
+// openLog opens the cache log and rebuilds the in-memory index from it.
+// Only keys and record positions are kept in memory.
+func (c *HashCache) openLog() error {
+	file, err := os.OpenFile(c.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open hash cache: %w", err)
+	}
+	if c.file != nil {
+		c.file.Close()
+	}
+	c.file = file
+	c.index = make(map[cacheKey]cacheRef)
+	c.records = 0
+	c.oldestSeen = time.Time{}
+
+	reader := bufio.NewReaderSize(io.NewSectionReader(file, 0, 1<<62), 1024*1024)
+	offset := int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var entry CacheEntry
+			if json.Unmarshal(line, &entry) == nil {
+				key := keyFor(entry.Volume, entry.FileID, entry.Path)
+				c.index[key] = cacheRef{offset: offset, length: int32(len(line) - 1)}
+				c.records++
+				if c.oldestSeen.IsZero() || entry.LastSeen.Before(c.oldestSeen) {
+					c.oldestSeen = entry.LastSeen
+				}
 			}
 		}
+		offset += int64(len(line))
+		if err != nil {
+			break // EOF; a torn last line without newline is ignored
+		}
+	}
+	return nil
+}
 
-		removedCount++
+// flush appends unsaved entries to the log under the cross-process lock.
+// The caller must hold the mutex.
+func (c *HashCache) flush() error {
+	if len(c.pending) == 0 || c.lock == nil {
+		return nil
+	}
+	if err := lockFile(c.lock, true); err != nil {
+		return fmt.Errorf("failed to lock hash cache: %w", err)
 	}
+	defer unlockFile(c.lock)
 
-	fmt.Printf("\r  Cache cleanup complete: kept %d entries, removed %d stale entries.\n",
-		len(filtered), removedCount)
+	// Another process may have compacted (replaced) the log meanwhile
+	if err := c.reopenIfReplaced(); err != nil {
+		return err
+	}
 
-	// Marshal to JSON
-	data, err := json.MarshalIndent(filtered, "", "  ")
+	stat, err := c.file.Stat()
 	if err != nil {
-		return fmt.Errorf("error marshaling hash cache: %w", err)
+		return fmt.Errorf("failed to stat hash cache: %w", err)
+	}
+	offset := stat.Size()
+
+	var buf []byte
+	refs := make(map[cacheKey]cacheRef, len(c.pending))
+	for key, entry := range c.pending {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			continue
+		}
+		refs[key] = cacheRef{offset: offset + int64(len(buf)), length: int32(len(line))}
+		if c.oldestSeen.IsZero() || entry.LastSeen.Before(c.oldestSeen) {
+			c.oldestSeen = entry.LastSeen
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	if _, err := c.file.Write(buf); err != nil {
+		return fmt.Errorf("failed to append to hash cache: %w", err)
 	}
+	for key, ref := range refs {
+		c.index[key] = ref
+	}
+	c.records += len(refs)
+	c.pending = make(map[cacheKey]CacheEntry)
+	return nil
+}
 
-	// Write to disk atomically: write to a temp file then rename, so an
-	// interrupted process never leaves a corrupted cache behind.
-	cachePath := GetHashCachePath()
-	tmpPath := cachePath + ".tmp"
-	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
-		return fmt.Errorf("error writing hash cache temp file: %w", err)
+// reopenIfReplaced reloads the index when the log on disk is no longer the
+// file this cache has open. The caller must hold the lock.
+func (c *HashCache) reopenIfReplaced() error {
+	onDisk, err := os.Stat(c.path)
+	if err != nil {
+		return c.openLog()
 	}
-	if err := os.Rename(tmpPath, cachePath); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("error finalizing hash cache: %w", err)
+	current, err := c.file.Stat()
+	if err != nil || !os.SameFile(onDisk, current) {
+		return c.openLog()
 	}
 	return nil
 }
 
-// Load cache from disk
-func (c *HashCache) Load() error {
-	cachePath := GetHashCachePath()
+// compact rewrites the log with one record per live key, dropping entries
+// last seen before threshold. It is skipped when another process holds the
+// lock. The caller must hold the mutex.
+func (c *HashCache) compact(threshold time.Time) error {
+	if err := lockFile(c.lock, false); err != nil {
+		return nil // Another process is using the cache; try next time
+	}
+	defer unlockFile(c.lock)
 
-	// If file doesn't exist, just return with empty cache
-	if _, err := os.Stat(cachePath); os.IsNotExist(err) {
-		return nil
+	if err := c.reopenIfReplaced(); err != nil {
+		return err
 	}
 
-	fmt.Println("Loading hash cache...")
+	fmt.Printf("Optimizing hash cache (%d records, %d entries)...\n", c.records, len(c.index))
 
-	// Read cache file
-	data, err := os.ReadFile(cachePath)
+	tmpPath := c.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
 	if err != nil {
-		return fmt.Errorf("error reading hash cache: %w", err)
+		return fmt.Errorf("error writing hash cache temp file: %w", err)
+	}
+	writer := bufio.NewWriterSize(tmp, 1024*1024)
+	kept, removed := 0, 0
+	for key := range c.index {
+		entry, ok := c.get(key)
+		if !ok || (c.MaxAge > 0 && entry.LastSeen.Before(threshold)) {
+			removed++
+			continue
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			removed++
+			continue
+		}
+		writer.Write(line)
+		writer.WriteByte('\n')
+		kept++
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("error writing hash cache temp file: %w", err)
+	}
+	tmp.Close()
+
+	// Replace atomically so an interrupted process never leaves a corrupted
+	// cache behind. Windows refuses while the old log is open.
+	c.file.Close()
+	c.file = nil
+	renameErr := os.Rename(tmpPath, c.path)
+	if renameErr != nil {
+		os.Remove(tmpPath)
+	}
+	if err := c.openLog(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("error finalizing hash cache: %w", renameErr)
 	}
 
-	// Parse JSON
+	fmt.Printf("  Cache cleanup complete: kept %d entries, removed %d stale entries.\n", kept, removed)
+	return nil
+}
+
+// importLegacyCache moves entries of the old whole-file JSON cache into the
+// log. Entries whose file changed or vanished are dropped.
+func (c *HashCache) importLegacyCache(legacyPath string) {
+	data, err := os.ReadFile(legacyPath)
+	if err != nil {
+		return
+	}
 	var entries map[string]CacheEntry
 	if err := json.Unmarshal(data, &entries); err != nil {
-		return fmt.Errorf("error parsing hash cache: %w", err)
+		return
 	}
 
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
-	c.Entries = entries
-	fmt.Printf("Cache loaded with %d entries.\n", len(entries))
-	return nil
+	fmt.Printf("Converting hash cache (%d entries)...\n", len(entries))
+	for path, entry := range entries {
+		info, err := os.Stat(path)
+		if err != nil || info.Size() != entry.Size || !info.ModTime().Equal(entry.ModTime) {
+			continue
+		}
+		entry.Path = path
+		entry.Volume, entry.FileID, _ = fileIdentity(path, info)
+		c.pending[keyFor(entry.Volume, entry.FileID, path)] = entry
+	}
+	if err := c.flush(); err == nil {
+		os.Rename(legacyPath, legacyPath+".bak")
+	}
 }
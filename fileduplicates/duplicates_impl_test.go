@@ -0,0 +1,202 @@
+package fileduplicates
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestFile creates a file with the given content and returns its info
+// with the identity used as cache key.
+func writeTestFile(t *testing.T, dir, name, content string) DuplicateFileInfo {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := GetFileInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Algorithm = HashSHA256
+	file.resolveIdentity()
+	return file
+}
+
+// countLines returns the number of records in the cache log
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		n++
+	}
+	return n
+}
+
+func TestHashCacheReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, HASH_CACHE_FILE)
+	a := writeTestFile(t, dir, "a.txt", "alpha")
+	b := writeTestFile(t, dir, "b.txt", "beta")
+
+	cache, err := openHashCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.FullHash, b.FullHash = "aaaa", "bbbb"
+	cache.StoreHash(a, FullHash)
+	cache.StoreHash(b, FullHash)
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+
+	cache, err = openHashCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	for _, file := range []DuplicateFileInfo{a, b} {
+		hash, ok := cache.LookupHash(file, FullHash)
+		if !ok || hash != file.FullHash {
+			t.Errorf("LookupHash(%s) = %q, %v; want %q", file.Path, hash, ok, file.FullHash)
+		}
+	}
+
+	// A file changed since hashing must miss
+	changed := a
+	changed.ModTime = a.ModTime.Add(time.Second)
+	if _, ok := cache.LookupHash(changed, FullHash); ok {
+		t.Error("LookupHash hit for a file with a different modification time")
+	}
+
+	// Another algorithm must miss
+	other := a
+	other.Algorithm = HashMD5
+	if _, ok := cache.LookupHash(other, FullHash); ok {
+		t.Error("LookupHash hit for a different algorithm")
+	}
+}
+
+func TestHashCacheReopenAfterCompaction(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, HASH_CACHE_FILE)
+	a := writeTestFile(t, dir, "a.txt", "alpha")
+	b := writeTestFile(t, dir, "b.txt", "beta")
+
+	cache, err := openHashCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Supersede a's record a few times so the log holds stale records
+	for _, hash := range []string{"a1", "a2", "a3"} {
+		a.FullHash = hash
+		cache.StoreHash(a, FullHash)
+		cache.mutex.Lock()
+		err := cache.flush()
+		cache.mutex.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.FullHash = "bbbb"
+	cache.StoreHash(b, FullHash)
+
+	// An entry not seen for longer than MaxAge is dropped on compaction
+	gone := CacheEntry{Path: filepath.Join(dir, "gone.txt"), Size: 1, ModTime: time.Now(),
+		Algorithm: HashSHA256, FullHash: "cccc", LastSeen: time.Now().Add(-2 * cache.MaxAge)}
+	goneKey := keyFor(0, 0, gone.Path)
+	cache.pending[goneKey] = gone
+
+	// Save compacts because of the expired entry
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.index[goneKey]; ok {
+		t.Error("expired entry survived compaction")
+	}
+	if got := countLines(t, path); got != 2 {
+		t.Errorf("log has %d records after compaction, want 2", got)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("compaction left its temp file behind")
+	}
+
+	// The cache keeps working after the log was replaced
+	if hash, ok := cache.LookupHash(a, FullHash); !ok || hash != "a3" {
+		t.Errorf("LookupHash(a) after compaction = %q, %v; want a3", hash, ok)
+	}
+	cache.Close()
+
+	cache, err = openHashCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if cache.records != 2 || len(cache.index) != 2 {
+		t.Errorf("reopened cache has %d records, %d entries; want 2, 2", cache.records, len(cache.index))
+	}
+	if hash, ok := cache.LookupHash(a, FullHash); !ok || hash != "a3" {
+		t.Errorf("LookupHash(a) after reopen = %q, %v; want a3", hash, ok)
+	}
+	if hash, ok := cache.LookupHash(b, FullHash); !ok || hash != "bbbb" {
+		t.Errorf("LookupHash(b) after reopen = %q, %v; want bbbb", hash, ok)
+	}
+
+	// Appends after compaction land in the new log
+	c := writeTestFile(t, dir, "c.txt", "gamma")
+	c.FullHash = "dddd"
+	cache.StoreHash(c, FullHash)
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if got := countLines(t, path); got != 3 {
+		t.Errorf("log has %d records after append, want 3", got)
+	}
+}
+
+func TestHashCacheIgnoresTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, HASH_CACHE_FILE)
+	a := writeTestFile(t, dir, "a.txt", "alpha")
+
+	cache, err := openHashCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.FullHash = "aaaa"
+	cache.StoreHash(a, FullHash)
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+
+	// A process killed mid-append leaves a line without newline
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"Path":"torn","Size":`)
+	f.Close()
+
+	cache, err = openHashCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if len(cache.index) != 1 {
+		t.Errorf("reopened cache has %d entries, want 1", len(cache.index))
+	}
+	if hash, ok := cache.LookupHash(a, FullHash); !ok || hash != "aaaa" {
+		t.Errorf("LookupHash(a) = %q, %v; want aaaa", hash, ok)
+	}
+}
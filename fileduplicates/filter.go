@@ -0,0 +1,142 @@
+package fileduplicates
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileFilter selects which files take part in a duplicate search. Extension
+// sets and size bounds follow the same rules as the check command's
+// --include-ext/--exclude-ext/--min-mb/--max-mb options.
+type FileFilter struct {
+	IncludePatterns []string        // Glob patterns a file must match (any of them)
+	ExcludePatterns []string        // Glob patterns excluding files and whole directories
+	IncludeExt      map[string]bool // Only these extensions (lowercase, with dot)
+	ExcludeExt      map[string]bool // Never these extensions
+	MinSize         int64           // Minimum size in bytes (0 = MIN_DUPLICATE_FILE_SIZE)
+	MaxSize         int64           // Maximum size in bytes (0 = no limit)
+	SkipHidden      bool            // Skip hidden and system files and directories
+}
+
+// ParseExtSet parses a comma-separated extension list ("jpg,.PNG") into a set
+// of lowercase extensions with a leading dot. An empty string yields nil.
+func ParseExtSet(s string) map[string]bool {
+	if s == "" {
+		return nil
+	}
+	m := make(map[string]bool)
+	for _, p := range strings.Split(s, ",") {
+		e := strings.TrimSpace(strings.ToLower(p))
+		if e == "" {
+			continue
+		}
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		m[e] = true
+	}
+	return m
+}
+
+// MBToBytes converts a size in megabytes to bytes
+func MBToBytes(mb float64) int64 {
+	return int64(mb * 1024.0 * 1024.0)
+}
+
+// active reports whether the filter excludes anything beyond the defaults
+func (f FileFilter) active() bool {
+	return len(f.IncludePatterns) > 0 || len(f.ExcludePatterns) > 0 || len(f.IncludeExt) > 0 ||
+		len(f.ExcludeExt) > 0 || f.MinSize > 0 || f.MaxSize > 0 || f.SkipHidden
+}
+
+// SkipDir reports whether a directory and everything below it is excluded.
+// relPath is relative to the scanned root.
+func (f FileFilter) SkipDir(relPath string, info os.FileInfo) bool {
+	if relPath == "." || relPath == "" {
+		return false
+	}
+	if f.SkipHidden && isHiddenOrSystem(info) {
+		return true
+	}
+	return matchAny(f.ExcludePatterns, relPath, info.Name())
+}
+
+// Accept reports whether a regular file passes the filter
+func (f FileFilter) Accept(relPath string, info os.FileInfo) bool {
+	size := info.Size()
+	minSize := f.MinSize
+	if minSize < MIN_DUPLICATE_FILE_SIZE {
+		minSize = MIN_DUPLICATE_FILE_SIZE
+	}
+	if size < minSize {
+		return false
+	}
+	if f.MaxSize > 0 && size > f.MaxSize {
+		return false
+	}
+	if f.IncludeExt != nil || f.ExcludeExt != nil {
+		ext := strings.ToLower(filepath.Ext(info.Name()))
+		if f.IncludeExt != nil && !f.IncludeExt[ext] {
+			return false
+		}
+		if f.ExcludeExt != nil && f.ExcludeExt[ext] {
+			return false
+		}
+	}
+	if f.SkipHidden && isHiddenOrSystem(info) {
+		return false
+	}
+	if matchAny(f.ExcludePatterns, relPath, info.Name()) {
+		return false
+	}
+	if len(f.IncludePatterns) > 0 && !matchAny(f.IncludePatterns, relPath, info.Name()) {
+		return false
+	}
+	return true
+}
+
+// matchAny matches patterns without a slash against the base name and
+// patterns with a slash against the slash-separated relative path. Matching is
+// case-insensitive because Windows file names are.
+func matchAny(patterns []string, relPath, name string) bool {
+	rel := strings.ToLower(filepath.ToSlash(relPath))
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		p := strings.ToLower(filepath.ToSlash(pattern))
+		target := name
+		if strings.Contains(p, "/") {
+			target = rel
+		}
+		if ok, _ := filepath.Match(p, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// walkFiltered walks root and calls visit for every regular file accepted by
+// the filter. Unreadable entries are skipped; excluded directories are not
+// descended into.
+func walkFiltered(root string, filter FileFilter, visit func(path string, info os.FileInfo)) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Skip files with errors
+		}
+		rel, rerr := filepath.Rel(root, path)
+		if rerr != nil {
+			rel = info.Name()
+		}
+		if info.IsDir() {
+			if filter.SkipDir(rel, info) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !filter.Accept(rel, info) {
+			return nil
+		}
+		visit(path, info)
+		return nil
+	})
+}
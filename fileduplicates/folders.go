@@ -0,0 +1,372 @@
+package fileduplicates
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// dirNode is one directory of the scanned trees in folder mode
+type dirNode struct {
+	info       DuplicateFileInfo // Path, total size, file count and dir mtime
+	parent     *dirNode
+	subdirs    []*dirNode
+	files      []DuplicateFileInfo
+	links      []string // "name -> target" of symbolic links
+	incomplete bool     // Some entry could not be read; never reported
+	shape      string   // Digest of names and sizes only
+	digest     string   // Digest of names and content hashes
+}
+
+// findDuplicateFolders reports whole directory trees with identical content.
+// Every directory gets a Merkle-style digest of its children's names and
+// content hashes; directories sharing a digest form a group, and only the
+// topmost such directories are reported. To avoid hashing everything, trees
+// are first compared by a digest of names and sizes, and only files inside
+// trees whose shape occurs more than once are hashed.
+func findDuplicateFolders(result *DuplicateResult, options DuplicateOptions, cache *HashCache, startTime time.Time) (*DuplicateResult, error) {
+	if options.Action == HardlinkAction || options.Action == ReflinkAction {
+		fmt.Println("Warning: folders cannot be linked, duplicate folders are only reported")
+		options.Action = NoAction
+	}
+	if (options.Action == DeleteAction || options.Action == MoveAction) && !options.Verify && !options.Review {
+		// Before acting only the folder's own mtime is re-checked, so a file
+		// changed deeper in the tree would go unnoticed
+		return nil, fmt.Errorf("deleting or moving duplicate folders requires verify")
+	}
+	if options.Filter.active() {
+		// A tree is only identical if all of it is compared; deleting a tree
+		// with unseen files would lose data
+		fmt.Println("Warning: file filters are ignored when searching duplicate folders")
+	}
+
+	fmt.Println("Scanning folders...")
+	nodes := make(map[string]*dirNode)
+	var tops []*dirNode
+	filesScanned := 0
+	for _, root := range result.Roots {
+		top, scanned := scanFolderTree(root, nodes, options)
+		if top != nil {
+			tops = append(tops, top)
+		}
+		filesScanned += scanned
+	}
+	// Roots nested in a later root are part of that tree now
+	var topLevel []*dirNode
+	for _, top := range tops {
+		if top.parent == nil {
+			topLevel = append(topLevel, top)
+		}
+	}
+	tops = topLevel
+
+	// Stage 1: digest of names and sizes
+	shapes := make(map[string]int)
+	for _, top := range tops {
+		computeDigest(top, true, options.HashAlgorithm, shapes)
+	}
+
+	var candidates []DuplicateFileInfo
+	for _, top := range tops {
+		collectCandidateFiles(top, shapes, &candidates)
+	}
+	if options.Verbose {
+		fmt.Printf("Found %d files in folders with matching structure. Calculating hashes...\n", len(candidates))
+	}
+
+	// Stage 2: digest of names and content
+	hashes := make(map[string]string)
+	for _, file := range hashFiles(candidates, FullHash, "Full hash", options, cache, NewHashWorker(GetOptimalWorkerCount())) {
+		hashes[file.Path] = file.FullHash
+	}
+	for _, node := range nodes {
+		for i := range node.files {
+			node.files[i].FullHash = hashes[node.files[i].Path]
+		}
+	}
+	digests := make(map[string]int)
+	for _, top := range tops {
+		if shapes[top.shape] > 1 {
+			computeDigest(top, false, options.HashAlgorithm, digests)
+		} else {
+			for _, sub := range candidateSubtrees(top, shapes) {
+				computeDigest(sub, false, options.HashAlgorithm, digests)
+			}
+		}
+	}
+
+	// Group by digest, keeping only directories whose parent is not itself
+	// a duplicate of something (those are covered by their ancestor)
+	byDigest := make(map[string][]*dirNode)
+	for _, node := range nodes {
+		if node.digest == "" || node.incomplete || node.info.FileCount == 0 || digests[node.digest] < 2 {
+			continue
+		}
+		byDigest[node.digest] = append(byDigest[node.digest], node)
+	}
+	var duplicateGroups [][]DuplicateFileInfo
+	for digest, members := range byDigest {
+		covered := true
+		for _, node := range members {
+			if node.parent == nil || node.parent.digest == "" || digests[node.parent.digest] < 2 {
+				covered = false
+				break
+			}
+		}
+		if covered {
+			continue
+		}
+		group := make([]DuplicateFileInfo, 0, len(members))
+		for _, node := range members {
+			info := node.info
+			info.FullHash = digest
+			info.Algorithm = options.HashAlgorithm
+			// A folder holding a reference root is protected like the root
+			info.IsReference = isUnderAnyRoot(info.Path, options.ReferenceRoots) ||
+				containsAnyRoot(info.Path, options.ReferenceRoots)
+			group = append(group, info)
+		}
+		sort.Slice(group, func(i, j int) bool { return group[i].Path < group[j].Path })
+		duplicateGroups = append(duplicateGroups, group)
+	}
+	sort.Slice(duplicateGroups, func(i, j int) bool {
+		return duplicateGroups[i][0].Path < duplicateGroups[j][0].Path
+	})
+
+	for i, group := range duplicateGroups {
+		result.Groups[fmt.Sprintf("%s#%d", FormatHash(group[0].Algorithm, group[0].FullHash), i+1)] = group
+	}
+	return finishDuplicateSearch(result, options, duplicateGroups, cache, startTime, filesScanned)
+}
+
+// scanFolderTree walks root and adds a node for every directory. Directories
+// already known from an enclosing root are attached instead of rescanned.
+func scanFolderTree(root string, nodes map[string]*dirNode, options DuplicateOptions) (*dirNode, int) {
+	var top *dirNode
+	scanned := 0
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		parent := nodes[filepath.Dir(path)]
+		if path == root {
+			parent = nil
+		}
+		if err != nil {
+			// Unreadable entry: its directory cannot be compared reliably
+			if parent != nil {
+				parent.incomplete = true
+			}
+			if node, ok := nodes[path]; ok {
+				node.incomplete = true
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			if existing, ok := nodes[path]; ok {
+				// Already scanned as (part of) another root. A root nested
+				// in this one is hung into this tree.
+				if parent != nil && existing.parent == nil {
+					existing.parent = parent
+					parent.subdirs = append(parent.subdirs, existing)
+				}
+				return filepath.SkipDir
+			}
+			node := &dirNode{
+				info: DuplicateFileInfo{
+					Path:        path,
+					ModTime:     info.ModTime(),
+					CreatedTime: info.ModTime(),
+					LastAccess:  info.ModTime(),
+					IsDir:       true,
+				},
+				parent: parent,
+			}
+			nodes[path] = node
+			if parent != nil {
+				parent.subdirs = append(parent.subdirs, node)
+			} else {
+				top = node
+			}
+			return nil
+		}
+
+		if parent == nil {
+			return nil // root is a file
+		}
+		scanned++
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, _ := os.Readlink(path)
+			parent.links = append(parent.links, info.Name()+" -> "+target)
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			parent.incomplete = true // Devices, pipes etc. cannot be compared
+			return nil
+		}
+		parent.files = append(parent.files, DuplicateFileInfo{
+			Path:      path,
+			Size:      info.Size(),
+			ModTime:   info.ModTime(),
+			Algorithm: options.HashAlgorithm,
+		})
+		if options.Verbose && scanned%1000 == 0 {
+			fmt.Printf("\rScanning: %d files", scanned)
+		}
+		return nil
+	})
+	if options.Verbose && scanned >= 1000 {
+		fmt.Println()
+	}
+	return top, scanned
+}
+
+// computeDigest fills in the shape (byShape) or content digest of node and
+// its subdirectories bottom-up, counts each digest in counts, and propagates
+// sizes, file counts and incompleteness to the parents.
+func computeDigest(node *dirNode, byShape bool, algorithm HashAlgorithm, counts map[string]int) string {
+	type entry struct {
+		name  string
+		value string
+	}
+	var entries []entry
+
+	node.info.Size = 0
+	node.info.FileCount = 0
+	for _, sub := range node.subdirs {
+		value := computeDigest(sub, byShape, algorithm, counts)
+		entries = append(entries, entry{"d:" + filepath.Base(sub.info.Path), value})
+		node.info.Size += sub.info.Size
+		node.info.FileCount += sub.info.FileCount
+		if sub.incomplete {
+			node.incomplete = true
+		}
+	}
+	for _, file := range node.files {
+		value := strconv.FormatInt(file.Size, 10)
+		if !byShape {
+			value = file.FullHash
+			if value == "" {
+				node.incomplete = true // Could not be hashed
+			}
+		}
+		entries = append(entries, entry{"f:" + filepath.Base(file.Path), value})
+		node.info.Size += file.Size
+		node.info.FileCount++
+	}
+	for _, link := range node.links {
+		entries = append(entries, entry{"l:" + link, ""})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		buf.WriteString(e.name)
+		buf.WriteByte(0)
+		buf.WriteString(e.value)
+		buf.WriteByte('\n')
+	}
+	hasher := algorithm.newHasher()
+	hasher.Write(buf.Bytes())
+	digest := hex.EncodeToString(hasher.Sum(nil))
+
+	if byShape {
+		node.shape = digest
+	} else {
+		node.digest = digest
+	}
+	if !node.incomplete && node.info.FileCount > 0 {
+		counts[digest]++
+	}
+	return digest
+}
+
+// candidateSubtrees returns the topmost directories below node whose shape
+// occurs more than once
+func candidateSubtrees(node *dirNode, shapes map[string]int) []*dirNode {
+	var result []*dirNode
+	for _, sub := range node.subdirs {
+		if shapes[sub.shape] > 1 {
+			result = append(result, sub)
+		} else {
+			result = append(result, candidateSubtrees(sub, shapes)...)
+		}
+	}
+	return result
+}
+
+// collectCandidateFiles gathers the files of all candidate subtrees, which
+// are the only ones that need content hashes
+func collectCandidateFiles(node *dirNode, shapes map[string]int, files *[]DuplicateFileInfo) {
+	if shapes[node.shape] > 1 {
+		var walk func(n *dirNode)
+		walk = func(n *dirNode) {
+			for _, file := range n.files {
+				file.resolveIdentity()
+				*files = append(*files, file)
+			}
+			for _, sub := range n.subdirs {
+				walk(sub)
+			}
+		}
+		walk(node)
+		return
+	}
+	for _, sub := range node.subdirs {
+		collectCandidateFiles(sub, shapes, files)
+	}
+}
+
+// dirsIdentical compares two directory trees entry by entry and byte for byte
+func dirsIdentical(pathA, pathB string) (bool, error) {
+	listA, err := treeEntries(pathA)
+	if err != nil {
+		return false, err
+	}
+	listB, err := treeEntries(pathB)
+	if err != nil {
+		return false, err
+	}
+	if len(listA) != len(listB) {
+		return false, nil
+	}
+	for rel, modeA := range listA {
+		modeB, ok := listB[rel]
+		if !ok || modeA != modeB {
+			return false, nil
+		}
+		if modeA.IsRegular() {
+			same, err := FilesIdentical(filepath.Join(pathA, rel), filepath.Join(pathB, rel))
+			if err != nil || !same {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// treeEntries lists the relative paths and types of everything below root
+func treeEntries(root string) (map[string]os.FileMode, error) {
+	entries := make(map[string]os.FileMode)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		entries[rel] = info.Mode().Type()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s for verification: %w", root, err)
+	}
+	return entries, nil
+}
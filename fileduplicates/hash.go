@@ -0,0 +1,117 @@
+package fileduplicates
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+)
+
+// HashAlgorithm names the content hash used for duplicate detection
+type HashAlgorithm string
+
+const (
+	HashMD5    HashAlgorithm = "md5"    // Legacy default, compatible with old caches and lists
+	HashSHA256 HashAlgorithm = "sha256" // Cryptographic, matches common audit tooling
+	HashXXH3   HashAlgorithm = "xxh3"   // Fastest, non-cryptographic 64-bit
+	HashBLAKE3 HashAlgorithm = "blake3" // Fast and cryptographic
+)
+
+// SupportedHashAlgorithms lists every algorithm accepted by ParseHashAlgorithm
+var SupportedHashAlgorithms = []HashAlgorithm{HashMD5, HashSHA256, HashXXH3, HashBLAKE3}
+
+// ParseHashAlgorithm converts a user supplied name (e.g. "xxhash3", "SHA-256")
+// into a HashAlgorithm.
+func ParseHashAlgorithm(name string) (HashAlgorithm, bool) {
+	n := strings.ToLower(strings.TrimSpace(name))
+	n = strings.ReplaceAll(n, "-", "")
+	switch n {
+	case "md5":
+		return HashMD5, true
+	case "sha256":
+		return HashSHA256, true
+	case "xxh3", "xxhash3", "xxhash":
+		return HashXXH3, true
+	case "blake3", "b3":
+		return HashBLAKE3, true
+	}
+	return "", false
+}
+
+// normalize maps the empty algorithm (entries written before algorithms were
+// recorded) to MD5, which was the only algorithm in use at that time.
+func (a HashAlgorithm) normalize() HashAlgorithm {
+	if a == "" {
+		return HashMD5
+	}
+	return a
+}
+
+// newHasher returns a fresh hash.Hash for the algorithm
+func (a HashAlgorithm) newHasher() hash.Hash {
+	switch a.normalize() {
+	case HashSHA256:
+		return sha256.New()
+	case HashXXH3:
+		return xxh3.New()
+	case HashBLAKE3:
+		return blake3.New()
+	default:
+		return md5.New()
+	}
+}
+
+// NewHasher returns a fresh hash.Hash for the algorithm, for callers that
+// read the data themselves
+func NewHasher(algorithm HashAlgorithm) hash.Hash {
+	return algorithm.newHasher()
+}
+
+// FormatHash returns a hash qualified with its algorithm, e.g. "sha256:ab12..."
+func FormatHash(algorithm HashAlgorithm, hexHash string) string {
+	return string(algorithm.normalize()) + ":" + hexHash
+}
+
+// ParseHash splits a possibly qualified hash ("xxh3:0123...", "dhash:...") into
+// algorithm and hex digest. Unqualified digests are identified by length; a
+// bare 64-character digest is assumed to be SHA-256 (BLAKE3 lists must use the
+// qualified form).
+func ParseHash(s string) (HashAlgorithm, string, error) {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, ":"); i > 0 {
+		algorithm, ok := ParseHashAlgorithm(s[:i])
+		if !ok {
+			algorithm, ok = ParsePerceptualAlgorithm(s[:i])
+		}
+		if !ok {
+			return "", "", fmt.Errorf("unknown hash algorithm: %s", s[:i])
+		}
+		return algorithm, strings.ToLower(s[i+1:]), nil
+	}
+
+	hexHash := strings.ToLower(s)
+	switch len(hexHash) {
+	case 16:
+		return HashXXH3, hexHash, nil
+	case 32:
+		return HashMD5, hexHash, nil
+	case 64:
+		return HashSHA256, hexHash, nil
+	}
+	return "", "", fmt.Errorf("cannot detect hash algorithm for %q", s)
+}
+
+// isDigest reports whether s is a hex digest and not a placeholder such as
+// the group keys of lists without hashes
+func isDigest(s string) bool {
+	if s == "" {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package fileduplicates
+
+import (
+	"os"
+	"strings"
+)
+
+// isHiddenOrSystem reports whether the entry is a dot file or dot directory
+func isHiddenOrSystem(info os.FileInfo) bool {
+	return strings.HasPrefix(info.Name(), ".")
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package fileduplicates
+
+import (
+	"os"
+	"syscall"
+)
+
+// isHiddenOrSystem reports whether the entry has the hidden or system attribute
+func isHiddenOrSystem(info os.FileInfo) bool {
+	if data, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return data.FileAttributes&(syscall.FILE_ATTRIBUTE_HIDDEN|syscall.FILE_ATTRIBUTE_SYSTEM) != 0
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+
+package fileduplicates
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// fileIdentity returns the device and inode number of the file, which stay the
+// same when the file is renamed or moved within the filesystem.
+func fileIdentity(path string, info os.FileInfo) (uint64, uint64, bool) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev), uint64(st.Ino), true
+	}
+	return 0, 0, false
+}
+
+// lockFile takes an exclusive lock on f. With wait=false it fails instead of
+// blocking when another process holds the lock.
+func lockFile(f *os.File, wait bool) error {
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	return syscall.Flock(int(f.Fd()), how)
+}
+
+// unlockFile releases a lock taken by lockFile
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// isCrossDevice reports whether a rename failed because source and target
+// are on different filesystems
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
@@ -0,0 +1,57 @@
+//go:build windows
+
+package fileduplicates
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileIdentity returns the volume serial number and file index of path, which
+// stay the same when the file is renamed or moved within the volume.
+func fileIdentity(path string, info os.FileInfo) (uint64, uint64, bool) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, false
+	}
+	// Zero access is enough to query the file index and works on files
+	// opened exclusively by other processes.
+	handle, err := windows.CreateFile(pathPtr, 0,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return 0, 0, false
+	}
+	defer windows.CloseHandle(handle)
+
+	var data windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(handle, &data); err != nil {
+		return 0, 0, false
+	}
+	return uint64(data.VolumeSerialNumber), uint64(data.FileIndexHigh)<<32 | uint64(data.FileIndexLow), true
+}
+
+// lockFile takes an exclusive lock on f. With wait=false it fails instead of
+// blocking when another process holds the lock.
+func lockFile(f *os.File, wait bool) error {
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK)
+	if !wait {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	ol := new(windows.Overlapped)
+	return windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, ol)
+}
+
+// unlockFile releases a lock taken by lockFile
+func unlockFile(f *os.File) error {
+	ol := new(windows.Overlapped)
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+}
+
+// isCrossDevice reports whether a rename failed because source and target
+// are on different volumes
+func isCrossDevice(err error) bool {
+	return errors.Is(err, windows.ERROR_NOT_SAME_DEVICE)
+}
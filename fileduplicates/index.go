@@ -0,0 +1,492 @@
+package fileduplicates
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DuplicateIndex is the persistent file list of one root used by "cd update"
+// and "cd watch". Every directory is stored with its modification time, so an
+// update only reads directories whose entries changed and only hashes files
+// that are new or changed. Hashes are only computed for files whose size
+// occurs more than once, as in a normal search.
+type DuplicateIndex struct {
+	Root      string
+	Algorithm HashAlgorithm
+	Filter    string // Fingerprint of the filter the index was built with
+	Updated   time.Time
+	Dirs      map[string]*IndexDir
+
+	path    string
+	filter  FileFilter
+	changed map[string]bool // Files added or changed since the last report
+}
+
+// IndexDir is one directory of the index
+type IndexDir struct {
+	ModTime time.Time             // Zero when it must be read again next time
+	Subdirs []string              `json:",omitempty"` // Names of indexed subdirectories
+	Files   map[string]*IndexFile `json:",omitempty"` // Accepted files by name
+}
+
+// IndexFile is one file of the index. Hashes are kept until size or
+// modification time change.
+type IndexFile struct {
+	Size      int64
+	ModTime   time.Time
+	QuickHash string `json:",omitempty"`
+	FullHash  string `json:",omitempty"`
+}
+
+// indexStats counts what an update did
+type indexStats struct {
+	dirsRead, dirsUnchanged, filesChanged, filesRemoved int
+}
+
+// GetIndexPath returns where the index of root is stored: next to the hash
+// cache, named by a hash of root, algorithm and filter
+func GetIndexPath(root string, options DuplicateOptions) string {
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(root) + "|" + string(options.HashAlgorithm) + "|" + filterFingerprint(options.Filter)))
+	return filepath.Join(filepath.Dir(GetHashCachePath()), DUPLICATE_INDEX_DIR, fmt.Sprintf("%016x.json", h.Sum64()))
+}
+
+// filterFingerprint describes a filter; indexes built with different filters
+// hold different files and are kept apart
+func filterFingerprint(filter FileFilter) string {
+	return fmt.Sprintf("%v", filter) // fmt prints maps sorted by key
+}
+
+// LoadDuplicateIndex loads the index of root. A missing index yields an empty
+// one, which the first update fills with a full scan.
+func LoadDuplicateIndex(root string, options DuplicateOptions) (*DuplicateIndex, error) {
+	index := &DuplicateIndex{
+		Root:      root,
+		Algorithm: options.HashAlgorithm,
+		Filter:    filterFingerprint(options.Filter),
+		Dirs:      make(map[string]*IndexDir),
+		path:      GetIndexPath(root, options),
+		filter:    options.Filter,
+		changed:   make(map[string]bool),
+	}
+
+	data, err := os.ReadFile(index.path)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return index, fmt.Errorf("failed to read index: %w", err)
+	}
+	var stored DuplicateIndex
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return index, fmt.Errorf("index %s is damaged, rebuilding: %w", index.path, err)
+	}
+	if stored.Root == root && stored.Algorithm == index.Algorithm && stored.Filter == index.Filter && stored.Dirs != nil {
+		index.Dirs = stored.Dirs
+		index.Updated = stored.Updated
+	}
+	return index, nil
+}
+
+// Save writes the index atomically
+func (x *DuplicateIndex) Save() error {
+	x.Updated = time.Now()
+	data, err := json.Marshal(x)
+	if err != nil {
+		return fmt.Errorf("failed to encode index: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(x.path), 0755); err != nil {
+		return fmt.Errorf("failed to create index directory: %w", err)
+	}
+	tmp := x.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if err := os.Rename(tmp, x.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace index: %w", err)
+	}
+	return nil
+}
+
+// refresh brings dir and everything below it up to date. A directory whose
+// modification time is unchanged keeps its entries and is not read again,
+// unless force is set (a watcher reported a change inside it).
+func (x *DuplicateIndex) refresh(dir string, force bool, scanStart time.Time, stats *indexStats) {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		x.removeDir(dir, stats)
+		return
+	}
+	node := x.Dirs[dir]
+	if node != nil && !force && !node.ModTime.IsZero() && node.ModTime.Equal(info.ModTime()) {
+		stats.dirsUnchanged++
+		for _, name := range node.Subdirs {
+			x.refresh(filepath.Join(dir, name), false, scanStart, stats)
+		}
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot read %s: %v\n", dir, err)
+		return // Keep what the index knew about it
+	}
+	stats.dirsRead++
+
+	fresh := &IndexDir{ModTime: info.ModTime(), Files: make(map[string]*IndexFile)}
+	// A directory changed during this very second could change again without
+	// a new timestamp (FAT has a 2 second resolution); read it again next time
+	if !info.ModTime().Before(scanStart.Add(-2 * time.Second)) {
+		fresh.ModTime = time.Time{}
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		fi, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		rel := x.rel(path)
+		if fi.IsDir() {
+			if !x.filter.SkipDir(rel, fi) {
+				fresh.Subdirs = append(fresh.Subdirs, entry.Name())
+			}
+			continue
+		}
+		if !fi.Mode().IsRegular() || !x.filter.Accept(rel, fi) {
+			continue
+		}
+		if node != nil {
+			if old := node.Files[entry.Name()]; old != nil && old.Size == fi.Size() && old.ModTime.Equal(fi.ModTime()) {
+				fresh.Files[entry.Name()] = old
+				continue
+			}
+		}
+		fresh.Files[entry.Name()] = &IndexFile{Size: fi.Size(), ModTime: fi.ModTime()}
+		x.changed[path] = true
+		stats.filesChanged++
+	}
+
+	if node != nil {
+		for name := range node.Files {
+			if fresh.Files[name] == nil {
+				stats.filesRemoved++
+			}
+		}
+		for _, name := range node.Subdirs {
+			if !containsString(fresh.Subdirs, name) {
+				x.removeDir(filepath.Join(dir, name), stats)
+			}
+		}
+	}
+	x.Dirs[dir] = fresh
+
+	for _, name := range fresh.Subdirs {
+		x.refresh(filepath.Join(dir, name), false, scanStart, stats)
+	}
+}
+
+// removeDir drops a directory that no longer exists, with everything below it
+func (x *DuplicateIndex) removeDir(dir string, stats *indexStats) {
+	node := x.Dirs[dir]
+	if node == nil {
+		return
+	}
+	for _, name := range node.Subdirs {
+		x.removeDir(filepath.Join(dir, name), stats)
+	}
+	stats.filesRemoved += len(node.Files)
+	delete(x.Dirs, dir)
+	if parent := x.Dirs[filepath.Dir(dir)]; parent != nil && dir != x.Root {
+		name := filepath.Base(dir)
+		for i, sub := range parent.Subdirs {
+			if sub == name {
+				parent.Subdirs = append(parent.Subdirs[:i], parent.Subdirs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// rel returns path relative to the root, for the filter
+func (x *DuplicateIndex) rel(path string) string {
+	rel, err := filepath.Rel(x.Root, path)
+	if err != nil {
+		return filepath.Base(path)
+	}
+	return rel
+}
+
+// fileCount returns the number of indexed files
+func (x *DuplicateIndex) fileCount() int {
+	count := 0
+	for _, node := range x.Dirs {
+		count += len(node.Files)
+	}
+	return count
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// indexedFile ties an index entry to its path and index
+type indexedFile struct {
+	path  string
+	entry *IndexFile
+	index *DuplicateIndex
+}
+
+// info returns the entry in the form the rest of the package works with
+func (f indexedFile) info(options DuplicateOptions) DuplicateFileInfo {
+	return DuplicateFileInfo{
+		Path:        f.path,
+		Size:        f.entry.Size,
+		ModTime:     f.entry.ModTime,
+		CreatedTime: f.entry.ModTime,
+		LastAccess:  f.entry.ModTime,
+		QuickHash:   f.entry.QuickHash,
+		FullHash:    f.entry.FullHash,
+		Algorithm:   options.HashAlgorithm,
+		IsReference: isUnderAnyRoot(f.path, options.ReferenceRoots),
+	}
+}
+
+// indexGroups hashes what the indexes are missing and returns the duplicate
+// groups over all of them. Only directory timestamps are checked by an
+// update, so a file rewritten in place would keep its old hash; every file
+// that could be part of a group is therefore checked with a stat first.
+func indexGroups(indexes []*DuplicateIndex, options DuplicateOptions, cache *HashCache) [][]DuplicateFileInfo {
+	// Overlapping roots must not list the same file twice
+	files := make(map[string]indexedFile)
+	for _, x := range indexes {
+		for dir, node := range x.Dirs {
+			for name, entry := range node.Files {
+				path := filepath.Join(dir, name)
+				if _, ok := files[path]; !ok {
+					files[path] = indexedFile{path, entry, x}
+				}
+			}
+		}
+	}
+
+	checked := make(map[string]bool)
+	var bySize map[int64][]indexedFile
+	for {
+		bySize = make(map[int64][]indexedFile)
+		for _, f := range files {
+			bySize[f.entry.Size] = append(bySize[f.entry.Size], f)
+		}
+		restat := false
+		for _, group := range bySize {
+			if len(group) < 2 {
+				continue
+			}
+			for _, f := range group {
+				if checked[f.path] {
+					continue
+				}
+				checked[f.path] = true
+				info, err := os.Stat(f.path)
+				if err != nil {
+					delete(files, f.path)
+					delete(f.index.Dirs[filepath.Dir(f.path)].Files, filepath.Base(f.path))
+					restat = true
+				} else if info.Size() != f.entry.Size || !info.ModTime().Equal(f.entry.ModTime) {
+					*f.entry = IndexFile{Size: info.Size(), ModTime: info.ModTime()}
+					f.index.changed[f.path] = true
+					restat = true
+				}
+			}
+		}
+		if !restat {
+			break
+		}
+	}
+
+	// Quick hashes for all files sharing a size
+	var needQuick []DuplicateFileInfo
+	for _, group := range bySize {
+		if len(group) < 2 {
+			continue
+		}
+		for _, f := range group {
+			if f.entry.QuickHash == "" {
+				info := f.info(options)
+				info.resolveIdentity()
+				needQuick = append(needQuick, info)
+			}
+		}
+	}
+	if len(needQuick) > 0 {
+		if options.Verbose {
+			fmt.Printf("Calculating quick hashes for %d new or changed files...\n", len(needQuick))
+		}
+		for _, info := range hashFiles(needQuick, QuickHash, "Quick hash", options, cache, NewHashWorker(GetOptimalWorkerCount())) {
+			files[info.Path].entry.QuickHash = info.QuickHash
+		}
+	}
+
+	// Full hashes for files sharing size and quick hash
+	byQuick := make(map[string][]indexedFile)
+	for size, group := range bySize {
+		if len(group) < 2 {
+			continue
+		}
+		for _, f := range group {
+			if f.entry.QuickHash != "" {
+				key := fmt.Sprintf("%d:%s", size, f.entry.QuickHash)
+				byQuick[key] = append(byQuick[key], f)
+			}
+		}
+	}
+	var needFull []DuplicateFileInfo
+	for _, group := range byQuick {
+		if len(group) < 2 {
+			continue
+		}
+		for _, f := range group {
+			if f.entry.FullHash == "" {
+				info := f.info(options)
+				info.resolveIdentity()
+				needFull = append(needFull, info)
+			}
+		}
+	}
+	if len(needFull) > 0 {
+		if options.Verbose {
+			fmt.Printf("Calculating full hashes for %d files...\n", len(needFull))
+		}
+		for _, info := range hashFiles(needFull, FullHash, "Full hash", options, cache, NewHashWorker(GetOptimalWorkerCount())) {
+			files[info.Path].entry.FullHash = info.FullHash
+		}
+	}
+
+	byFull := make(map[string][]DuplicateFileInfo)
+	for key, group := range byQuick {
+		if len(group) < 2 {
+			continue
+		}
+		for _, f := range group {
+			if f.entry.FullHash != "" {
+				full := key + ":" + f.entry.FullHash
+				byFull[full] = append(byFull[full], f.info(options))
+			}
+		}
+	}
+	var duplicateGroups [][]DuplicateFileInfo
+	for _, group := range byFull {
+		if len(group) > 1 {
+			sort.Slice(group, func(i, j int) bool { return group[i].Path < group[j].Path })
+			duplicateGroups = append(duplicateGroups, group)
+		}
+	}
+	sort.Slice(duplicateGroups, func(i, j int) bool { return duplicateGroups[i][0].Path < duplicateGroups[j][0].Path })
+	return duplicateGroups
+}
+
+// reportNewDuplicates prints the groups containing a file added or changed
+// since the indexes were last reported, with those files marked "+", and
+// forgets the changes. It returns the number of such groups.
+func reportNewDuplicates(indexes []*DuplicateIndex, groups [][]DuplicateFileInfo) int {
+	fresh := 0
+	for _, group := range groups {
+		isNew := false
+		for _, file := range group {
+			if isChanged(indexes, file.Path) {
+				isNew = true
+				break
+			}
+		}
+		if !isNew {
+			continue
+		}
+		fresh++
+		fmt.Printf("\nNew duplicate (%d files, %.2f MB each):\n", len(group), float64(group[0].Size)/(1024*1024))
+		for _, file := range group {
+			mark := " "
+			if isChanged(indexes, file.Path) {
+				mark = "+"
+			}
+			fmt.Printf("  %s %s\n", mark, file.Path)
+		}
+	}
+	for _, x := range indexes {
+		x.changed = make(map[string]bool)
+	}
+	return fresh
+}
+
+func isChanged(indexes []*DuplicateIndex, path string) bool {
+	for _, x := range indexes {
+		if x.changed[path] {
+			return true
+		}
+	}
+	return false
+}
+
+// updateIndexes loads and refreshes the index of every root
+func updateIndexes(roots []string, options DuplicateOptions) []*DuplicateIndex {
+	var indexes []*DuplicateIndex
+	for _, root := range roots {
+		x, err := LoadDuplicateIndex(root, options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		if len(x.Dirs) == 0 {
+			fmt.Printf("Building index of %s...\n", root)
+		} else {
+			fmt.Printf("Updating index of %s (last update %s)...\n", root, x.Updated.Format("2006-01-02 15:04:05"))
+		}
+		var stats indexStats
+		x.refresh(root, false, time.Now(), &stats)
+		if options.Verbose {
+			fmt.Printf("  %d folders read, %d unchanged, %d files new or changed, %d removed\n",
+				stats.dirsRead, stats.dirsUnchanged, stats.filesChanged, stats.filesRemoved)
+		}
+		indexes = append(indexes, x)
+	}
+	return indexes
+}
+
+// saveIndexes writes all indexes, warning about failures
+func saveIndexes(indexes []*DuplicateIndex) {
+	for _, x := range indexes {
+		if err := x.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+}
+
+// findDuplicatesIndexed answers "cd update": refresh the indexes, report the
+// duplicates that appeared since the previous update and process all groups
+// like a normal search
+func findDuplicatesIndexed(result *DuplicateResult, options DuplicateOptions, cache *HashCache, startTime time.Time) (*DuplicateResult, error) {
+	indexes := updateIndexes(result.Roots, options)
+	duplicateGroups := indexGroups(indexes, options, cache)
+
+	filesScanned := 0
+	for _, x := range indexes {
+		filesScanned += x.fileCount()
+	}
+	fresh := reportNewDuplicates(indexes, duplicateGroups)
+	saveIndexes(indexes)
+	fmt.Printf("\nNew duplicate groups since last update: %d\n", fresh)
+
+	for i, group := range duplicateGroups {
+		result.Groups[fmt.Sprintf("%s#%d", FormatHash(group[0].Algorithm, group[0].FullHash), i+1)] = group
+	}
+	return finishDuplicateSearch(result, options, duplicateGroups, cache, startTime, filesScanned)
+}
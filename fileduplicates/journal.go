@@ -0,0 +1,328 @@
+package fileduplicates
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// JournalEntry records one file moved away by a duplicate action, so the
+// move can be undone with "cd undo <journal>"
+type JournalEntry struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"` // "move" or "quarantine"
+	Original string    `json:"original"`
+	New      string    `json:"new"`
+	Hash     string    `json:"hash,omitempty"` // Qualified content hash, e.g. "md5:..."
+	Size     int64     `json:"size"`
+	IsDir    bool      `json:"folder,omitempty"`
+}
+
+// Journal appends entries as JSON lines. Each entry is written as soon as
+// the move succeeded, so an interrupted run can still be undone.
+type Journal struct {
+	path    string
+	file    *os.File
+	entries int
+}
+
+// OpenJournal opens (or continues) the journal at path
+func OpenJournal(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating journal directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal: %w", err)
+	}
+	return &Journal{path: path, file: file}, nil
+}
+
+// Record writes one entry. The content hash is taken from the duplicate
+// group, or computed when the list did not carry a digest.
+func (j *Journal) Record(action string, file DuplicateFileInfo, newPath string) {
+	entry := JournalEntry{
+		Time:     time.Now(),
+		Action:   action,
+		Original: file.Path,
+		New:      newPath,
+		Size:     file.Size,
+		IsDir:    file.IsDir,
+	}
+	// Folder trees are restored by path only
+	if !file.IsDir {
+		if isDigest(file.FullHash) && !file.Algorithm.isPerceptual() {
+			entry.Hash = FormatHash(file.Algorithm, file.FullHash)
+		} else if hash, err := calculateFullHash(newPath, HashMD5); err == nil {
+			entry.Hash = FormatHash(HashMD5, hash)
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write journal entry for %s: %v\n", file.Path, err)
+		return
+	}
+	j.entries++
+}
+
+// Close closes the journal and tells how to undo the run. An empty journal
+// is removed.
+func (j *Journal) Close() {
+	j.file.Close()
+	if j.entries == 0 {
+		os.Remove(j.path)
+		return
+	}
+	fmt.Printf("Undo journal (%d entries): %s\n", j.entries, j.path)
+	fmt.Printf("Restore with: filedo cd undo %s\n", j.path)
+}
+
+// quarantinePath returns where a deleted duplicate is kept in quarantine:
+// below dir, mirroring its original path so names never collide
+func quarantinePath(dir, path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	vol := filepath.VolumeName(path)
+	rest := strings.TrimPrefix(path, vol)
+	vol = strings.NewReplacer(":", "", `\`, "_", "/", "_").Replace(vol)
+	return filepath.Join(dir, vol, rest)
+}
+
+// prepareJournal opens the journal for actions that move files away and, in
+// quarantine mode, creates the dated quarantine folder. It returns nil when
+// the action needs no journal.
+func prepareJournal(options *DuplicateOptions) *Journal {
+	stamp := time.Now().Format("2006-01-02_150405")
+	path := options.JournalPath
+
+	switch {
+	case options.Action == DeleteAction && options.Quarantine != "":
+		options.Quarantine = filepath.Join(options.Quarantine, "quarantine_"+stamp)
+		if path == "" {
+			path = filepath.Join(options.Quarantine, "journal.jsonl")
+		}
+	case options.Action == MoveAction && options.TargetDir != "":
+		if path == "" {
+			path = filepath.Join(options.TargetDir, "filedo_journal_"+stamp+".jsonl")
+		}
+	default:
+		return nil
+	}
+
+	journal, err := OpenJournal(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v, moves will not be journaled\n", err)
+		return nil
+	}
+	return journal
+}
+
+// UndoJournal moves the files recorded in a journal back to their original
+// paths, newest first. A file is only restored when its content hash still
+// matches the journal and nothing occupies its original path; everything
+// else is reported as a conflict.
+func UndoJournal(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening journal: %w", err)
+	}
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			fmt.Printf("Warning: invalid journal line skipped: %s\n", line)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	file.Close()
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading journal: %w", err)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no entries found in journal %s", path)
+	}
+
+	fmt.Printf("Undoing %d moves from %s\n", len(entries), path)
+	restored, conflicts, already := 0, 0, 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if alreadyRestored(entry) {
+			already++
+			continue
+		}
+		if reason := undoEntry(entry); reason != "" {
+			fmt.Printf("Conflict: %s (%s)\n", entry.Original, reason)
+			conflicts++
+			continue
+		}
+		fmt.Printf("Restored: %s -> %s\n", entry.New, entry.Original)
+		restored++
+	}
+
+	fmt.Printf("\nUndo complete: %d restored, %d conflicts, %d already restored\n", restored, conflicts, already)
+	if conflicts > 0 {
+		return fmt.Errorf("%d files could not be restored", conflicts)
+	}
+	return nil
+}
+
+// alreadyRestored reports whether an earlier undo put the file back: the
+// moved copy is gone and the original path holds the journaled content
+func alreadyRestored(entry JournalEntry) bool {
+	if _, err := os.Lstat(entry.New); err == nil {
+		return false
+	}
+	info, err := os.Stat(entry.Original)
+	if err != nil {
+		return false
+	}
+	if entry.IsDir {
+		return info.IsDir()
+	}
+	if info.Size() != entry.Size {
+		return false
+	}
+	if entry.Hash == "" {
+		return true
+	}
+	algorithm, expected, err := ParseHash(entry.Hash)
+	if err != nil {
+		return false
+	}
+	actual, err := calculateFullHash(entry.Original, algorithm)
+	return err == nil && actual == expected
+}
+
+// undoEntry restores one entry and returns a reason when it cannot
+func undoEntry(entry JournalEntry) string {
+	info, err := os.Stat(entry.New)
+	if err != nil {
+		return fmt.Sprintf("moved copy %s is missing", entry.New)
+	}
+	if _, err := os.Lstat(entry.Original); err == nil {
+		return "original path is occupied"
+	}
+	if !entry.IsDir {
+		if info.Size() != entry.Size {
+			return fmt.Sprintf("size of %s changed from %d to %d bytes", entry.New, entry.Size, info.Size())
+		}
+		if entry.Hash != "" {
+			algorithm, expected, err := ParseHash(entry.Hash)
+			if err != nil {
+				return err.Error()
+			}
+			actual, err := calculateFullHash(entry.New, algorithm)
+			if err != nil {
+				return err.Error()
+			}
+			if actual != expected {
+				return fmt.Sprintf("content of %s changed (hash mismatch)", entry.New)
+			}
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(entry.Original), 0755); err != nil {
+		return err.Error()
+	}
+	if err := moveFile(entry.New, entry.Original); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// moveFile renames src to dst. Between volumes, where a rename is impossible,
+// src is copied, flushed to disk and only then removed; a failed copy is
+// cleaned up and src is left in place. A dst that already existed is never
+// touched.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+	if created, err := copyTree(src, dst); err != nil {
+		if created {
+			os.RemoveAll(dst)
+		}
+		return fmt.Errorf("copy to %s failed: %w", dst, err)
+	}
+	return os.RemoveAll(src)
+}
+
+// copyTree copies a file, or a directory with everything below it, keeping
+// permissions and modification times. Existing targets are never overwritten.
+// created tells whether dst was created, so a failed copy knows what it may
+// clean up.
+func copyTree(src, dst string) (created bool, err error) {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case info.IsDir():
+		if err := os.Mkdir(dst, info.Mode().Perm()); err != nil {
+			return false, err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return true, err
+		}
+		for _, entry := range entries {
+			if _, err := copyTree(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+				return true, err
+			}
+		}
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return false, err
+		}
+		if err := os.Symlink(target, dst); err != nil {
+			return false, err
+		}
+		return true, nil
+	default:
+		if created, err := copyFileSynced(src, dst, info.Mode().Perm()); err != nil {
+			return created, err
+		}
+	}
+	return true, os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// copyFileSynced copies one regular file and syncs it before returning
+func copyFileSynced(src, dst string, perm os.FileMode) (created bool, err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return true, err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return true, err
+	}
+	return true, out.Close()
+}
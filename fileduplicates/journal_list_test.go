@@ -0,0 +1,50 @@
+package fileduplicates_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filedo/fileduplicates"
+	"filedo/helpers"
+)
+
+// Lists written before groups carried a "# Hash:" line are moved from and
+// undone like any other
+func TestJournalUndoOfListWithoutHashes(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("duplicate", 100)
+	a := filepath.Join(dir, "src", "a.txt")
+	b := filepath.Join(dir, "src", "b.txt")
+	os.MkdirAll(filepath.Dir(a), 0755)
+	for _, path := range []string{a, b} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := filepath.Join(dir, "old.lst")
+	data := "# Group 1 (2 files, 0.00 MB each)\n  " + a + "\n  " + b + "\n"
+	if err := os.WriteFile(list, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	journalPath := filepath.Join(dir, "journal.jsonl")
+	target := filepath.Join(dir, "moved")
+	if err := helpers.CheckDuplicatesFromFile([]string{"from", "list", list, "move", target, "journal", journalPath}); err != nil {
+		t.Fatalf("CheckDuplicatesFromFile: %v", err)
+	}
+	moved, _ := os.ReadDir(target)
+	if len(moved) != 1 {
+		t.Fatalf("%d files moved, want 1", len(moved))
+	}
+
+	if err := fileduplicates.UndoJournal(journalPath); err != nil {
+		t.Fatalf("UndoJournal: %v", err)
+	}
+	for _, path := range []string{a, b} {
+		if data, err := os.ReadFile(path); err != nil || string(data) != content {
+			t.Errorf("%s not restored: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package fileduplicates
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// moveAndRecord moves a test file into dir/moved and journals the move
+func moveAndRecord(t *testing.T, journal *Journal, action, path, dir string) string {
+	t.Helper()
+	file, err := GetFileInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPath := filepath.Join(dir, "moved", filepath.Base(path))
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveFile(path, newPath); err != nil {
+		t.Fatal(err)
+	}
+	journal.Record(action, file, newPath)
+	return newPath
+}
+
+func TestJournalRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	journalPath := filepath.Join(dir, "journal.jsonl")
+	a := filepath.Join(dir, "src", "a.txt")
+	b := filepath.Join(dir, "src", "sub", "b.txt")
+	for path, content := range map[string]string{a: "alpha", b: "beta"} {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	journal, err := OpenJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	movedA := moveAndRecord(t, journal, "move", a, dir)
+	movedB := moveAndRecord(t, journal, "quarantine", b, dir)
+	journal.Close()
+
+	// The original folder is gone; undo recreates it
+	os.RemoveAll(filepath.Join(dir, "src"))
+
+	if err := UndoJournal(journalPath); err != nil {
+		t.Fatalf("UndoJournal: %v", err)
+	}
+	for path, content := range map[string]string{a: "alpha", b: "beta"} {
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v; want %q", path, data, err, content)
+		}
+	}
+	for _, moved := range []string{movedA, movedB} {
+		if _, err := os.Stat(moved); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after undo", moved)
+		}
+	}
+
+	// A second undo finds everything restored and reports no conflicts
+	if err := UndoJournal(journalPath); err != nil {
+		t.Errorf("repeated UndoJournal: %v", err)
+	}
+}
+
+func TestJournalUndoConflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(original, moved string)
+		reason  string
+		restore bool
+	}{
+		{
+			name:    "untouched",
+			change:  func(original, moved string) {},
+			restore: true,
+		},
+		{
+			name: "original path occupied",
+			change: func(original, moved string) {
+				os.WriteFile(original, []byte("new"), 0644)
+			},
+			reason: "occupied",
+		},
+		{
+			name: "moved copy changed",
+			change: func(original, moved string) {
+				os.WriteFile(moved, []byte("gamma"), 0644) // Same size, other content
+			},
+			reason: "hash mismatch",
+		},
+		{
+			name: "moved copy resized",
+			change: func(original, moved string) {
+				os.WriteFile(moved, []byte("longer content"), 0644)
+			},
+			reason: "size",
+		},
+		{
+			name: "moved copy missing",
+			change: func(original, moved string) {
+				os.Remove(moved)
+			},
+			reason: "missing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			original := filepath.Join(dir, "a.txt")
+			if err := os.WriteFile(original, []byte("alpha"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			journal, err := OpenJournal(filepath.Join(dir, "journal.jsonl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			moved := moveAndRecord(t, journal, "move", original, dir)
+			journal.Close()
+
+			tt.change(original, moved)
+			reason := undoEntry(JournalEntry{
+				Original: original,
+				New:      moved,
+				Size:     5,
+				Hash:     FormatHash(HashMD5, "2c1743a391305fbf367df8e4f069f9f9"), // md5("alpha")
+			})
+			if tt.restore {
+				if reason != "" {
+					t.Fatalf("undoEntry: %s", reason)
+				}
+				if data, _ := os.ReadFile(original); string(data) != "alpha" {
+					t.Errorf("restored content = %q, want alpha", data)
+				}
+				return
+			}
+			if !strings.Contains(reason, tt.reason) {
+				t.Errorf("undoEntry reason = %q, want it to mention %q", reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestCopyTreeKeepsContentAndTimes(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	os.WriteFile(filepath.Join(src, "a.txt"), []byte("alpha"), 0644)
+	os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("beta"), 0600)
+	info, _ := os.Stat(filepath.Join(src, "sub", "b.txt"))
+
+	dst := filepath.Join(dir, "dst")
+	if _, err := copyTree(src, dst); err != nil {
+		t.Fatalf("copyTree: %v", err)
+	}
+	if same, err := dirsIdentical(src, dst); err != nil || !same {
+		t.Errorf("dirsIdentical = %v, %v; want true", same, err)
+	}
+	copied, err := os.Stat(filepath.Join(dst, "sub", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !copied.ModTime().Equal(info.ModTime()) || copied.Mode() != info.Mode() {
+		t.Errorf("copy has mtime %v mode %v, want %v %v", copied.ModTime(), copied.Mode(), info.ModTime(), info.Mode())
+	}
+
+	// An existing target is never overwritten, nor reported as created
+	if created, err := copyTree(filepath.Join(src, "a.txt"), filepath.Join(dst, "a.txt")); err == nil || created {
+		t.Errorf("copyTree onto an existing file = %v, %v; want an error and nothing created", created, err)
+	}
+	if created, err := copyTree(src, dst); err == nil || created {
+		t.Errorf("copyTree onto an existing folder = %v, %v; want an error and nothing created", created, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "a.txt")); err != nil || string(data) != "alpha" {
+		t.Errorf("existing target changed: %q, %v", data, err)
+	}
+}
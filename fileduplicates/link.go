@@ -0,0 +1,57 @@
+package fileduplicates
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// errReflinkUnsupported is returned where copy-on-write clones are unavailable
+var errReflinkUnsupported = errors.New("reflinks are not supported on this operating system")
+
+// replaceWithLink atomically replaces duplicatePath with a hard link or a
+// copy-on-write clone of originalPath. The link is first created under a
+// temporary name next to the duplicate and then renamed over it, so the
+// duplicate path is valid at every moment and is left untouched on failure
+// (for example when both files are on different volumes).
+func replaceWithLink(originalPath, duplicatePath string, action DuplicateAction) error {
+	origInfo, err := os.Stat(originalPath)
+	if err != nil {
+		return fmt.Errorf("cannot stat original: %w", err)
+	}
+	dupInfo, err := os.Stat(duplicatePath)
+	if err != nil {
+		return fmt.Errorf("cannot stat duplicate: %w", err)
+	}
+	if action == HardlinkAction && os.SameFile(origInfo, dupInfo) {
+		return nil // Already the same file
+	}
+
+	tmpPath := filepath.Join(filepath.Dir(duplicatePath),
+		"."+filepath.Base(duplicatePath)+".filedo-link.tmp")
+	os.Remove(tmpPath)
+
+	switch action {
+	case HardlinkAction:
+		if err := os.Link(originalPath, tmpPath); err != nil {
+			return fmt.Errorf("hard link failed: %w", err)
+		}
+	case ReflinkAction:
+		if err := cloneFile(originalPath, tmpPath); err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("reflink failed: %w", err)
+		}
+		// A clone is a separate file, so keep the duplicate's own metadata
+		os.Chmod(tmpPath, dupInfo.Mode().Perm())
+		os.Chtimes(tmpPath, dupInfo.ModTime(), dupInfo.ModTime())
+	default:
+		return fmt.Errorf("unsupported link action: %d", action)
+	}
+
+	if err := os.Rename(tmpPath, duplicatePath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("cannot replace duplicate: %w", err)
+	}
+	return nil
+}
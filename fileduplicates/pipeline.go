@@ -0,0 +1,405 @@
+package fileduplicates
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sizedFile is the record kept for every scanned file until its size bucket
+// is complete
+type sizedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// sizedFileOverhead approximates the memory of a record besides its path
+const sizedFileOverhead = 64
+
+// sizeBuckets groups scanned files by size within a memory budget. Files are
+// spread over SPILL_PARTITIONS partitions by size; when the records held in
+// memory exceed the budget, all partitions are appended to files in a spill
+// folder. Every size lives in exactly one partition, so partitions can be
+// bucketed one after the other.
+type sizeBuckets struct {
+	budget   int64 // Bytes of records held in memory before spilling
+	used     int64
+	parts    [SPILL_PARTITIONS][]sizedFile
+	spillDir string // Where the spill folder is created
+	dir      string // Spill folder, empty until the first spill
+	spilled  int64  // Records written to disk
+}
+
+func newSizeBuckets(options DuplicateOptions) *sizeBuckets {
+	limit := options.MemoryLimitMB
+	if limit <= 0 {
+		limit = DEFAULT_MEMORY_LIMIT_MB
+	}
+	spillDir := options.SpillDir
+	if spillDir == "" {
+		spillDir = os.TempDir()
+	}
+	// Half of the budget is left to the hashing stages and the results
+	return &sizeBuckets{budget: int64(limit) * 1024 * 1024 / 2, spillDir: spillDir}
+}
+
+// partitionOf spreads sizes evenly over the partitions
+func partitionOf(size int64) int {
+	return int((uint64(size) * 0x9E3779B97F4A7C15) >> 56 % SPILL_PARTITIONS)
+}
+
+// add records a file, spilling to disk when the budget is used up
+func (b *sizeBuckets) add(f sizedFile) error {
+	p := partitionOf(f.size)
+	b.parts[p] = append(b.parts[p], f)
+	b.used += int64(len(f.path)) + sizedFileOverhead
+	if b.used > b.budget {
+		return b.spill()
+	}
+	return nil
+}
+
+// spill appends every partition held in memory to its file
+func (b *sizeBuckets) spill() error {
+	if b.dir == "" {
+		dir, err := os.MkdirTemp(b.spillDir, "filedo_spill_")
+		if err != nil {
+			return fmt.Errorf("failed to create spill folder: %w", err)
+		}
+		b.dir = dir
+		fmt.Printf("\nMemory limit reached, spilling file lists to %s\n", dir)
+	}
+	for p := range b.parts {
+		if len(b.parts[p]) == 0 {
+			continue
+		}
+		if err := b.writePartition(p); err != nil {
+			return err
+		}
+		b.spilled += int64(len(b.parts[p]))
+		b.parts[p] = nil
+	}
+	b.used = 0
+	return nil
+}
+
+func (b *sizeBuckets) partitionPath(p int) string {
+	return filepath.Join(b.dir, fmt.Sprintf("part_%03d.bin", p))
+}
+
+// writePartition appends records as size, mtime, path length and path
+func (b *sizeBuckets) writePartition(p int) error {
+	file, err := os.OpenFile(b.partitionPath(p), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open spill file: %w", err)
+	}
+	writer := bufio.NewWriter(file)
+	var header [20]byte
+	for _, f := range b.parts[p] {
+		binary.LittleEndian.PutUint64(header[0:], uint64(f.size))
+		binary.LittleEndian.PutUint64(header[8:], uint64(f.modTime.UnixNano()))
+		binary.LittleEndian.PutUint32(header[16:], uint32(len(f.path)))
+		writer.Write(header[:])
+		writer.WriteString(f.path)
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+	return file.Close()
+}
+
+// readPartition loads the spilled records of a partition
+func (b *sizeBuckets) readPartition(p int) ([]sizedFile, error) {
+	file, err := os.Open(b.partitionPath(p))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spill file: %w", err)
+	}
+	defer file.Close()
+
+	var files []sizedFile
+	reader := bufio.NewReader(file)
+	var header [20]byte
+	for {
+		if _, err := io.ReadFull(reader, header[:]); err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read spill file: %w", err)
+		}
+		path := make([]byte, binary.LittleEndian.Uint32(header[16:]))
+		if _, err := io.ReadFull(reader, path); err != nil {
+			return nil, fmt.Errorf("failed to read spill file: %w", err)
+		}
+		files = append(files, sizedFile{
+			path:    string(path),
+			size:    int64(binary.LittleEndian.Uint64(header[0:])),
+			modTime: time.Unix(0, int64(binary.LittleEndian.Uint64(header[8:]))),
+		})
+	}
+}
+
+// forEachBucket calls emit for every size shared by more than one file,
+// one partition at a time. Memory is released partition by partition.
+func (b *sizeBuckets) forEachBucket(emit func(files []sizedFile)) error {
+	for p := range b.parts {
+		files := b.parts[p]
+		b.parts[p] = nil
+		if b.dir != "" {
+			spilled, err := b.readPartition(p)
+			if err != nil {
+				return err
+			}
+			files = append(spilled, files...)
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].size < files[j].size })
+		for start := 0; start < len(files); {
+			end := start + 1
+			for end < len(files) && files[end].size == files[start].size {
+				end++
+			}
+			if end-start > 1 {
+				emit(files[start:end])
+			}
+			start = end
+		}
+	}
+	return nil
+}
+
+// cleanup removes the spill folder
+func (b *sizeBuckets) cleanup() {
+	if b.dir != "" {
+		os.RemoveAll(b.dir)
+	}
+}
+
+// pipelineStats counts the work of the hashing stages for progress output
+type pipelineStats struct {
+	quick, full, groups int64
+}
+
+// streamDuplicateGroups finds duplicates among the files in buckets in three
+// concurrent stages: size buckets are quick-hashed by one set of goroutines,
+// files sharing a quick hash are fully hashed by another, and every group of
+// identical files is passed to emit as soon as its bucket is done. emit is
+// never called concurrently.
+func streamDuplicateGroups(buckets *sizeBuckets, options DuplicateOptions, cache *HashCache, emit func(group []DuplicateFileInfo)) error {
+	workerCount := GetOptimalWorkerCount()
+	sizeCh := make(chan []DuplicateFileInfo, workerCount)
+	quickCh := make(chan []DuplicateFileInfo, workerCount)
+	var stats pipelineStats
+	var emitMutex sync.Mutex
+
+	var quickWG sync.WaitGroup
+	for i := 0; i < workerCount; i++ {
+		quickWG.Add(1)
+		go func() {
+			defer quickWG.Done()
+			for bucket := range sizeCh {
+				for _, sub := range splitByHash(bucket, QuickHash, options, cache, &stats.quick) {
+					quickCh <- sub
+				}
+			}
+		}()
+	}
+	go func() {
+		quickWG.Wait()
+		close(quickCh)
+	}()
+
+	var fullWG sync.WaitGroup
+	for i := 0; i < workerCount; i++ {
+		fullWG.Add(1)
+		go func() {
+			defer fullWG.Done()
+			for bucket := range quickCh {
+				for _, group := range splitByHash(bucket, FullHash, options, cache, &stats.full) {
+					atomic.AddInt64(&stats.groups, 1)
+					emitMutex.Lock()
+					emit(group)
+					emitMutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	if options.Verbose {
+		go func() {
+			ticker := time.NewTicker(500 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					fmt.Printf("Hashing: %d quick, %d full hashes, %d groups found\r",
+						atomic.LoadInt64(&stats.quick), atomic.LoadInt64(&stats.full), atomic.LoadInt64(&stats.groups))
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	err := buckets.forEachBucket(func(files []sizedFile) {
+		bucket := make([]DuplicateFileInfo, len(files))
+		for i, f := range files {
+			bucket[i] = DuplicateFileInfo{
+				Path:        f.path,
+				Size:        f.size,
+				ModTime:     f.modTime,
+				CreatedTime: f.modTime,
+				LastAccess:  f.modTime,
+				Algorithm:   options.HashAlgorithm,
+				IsReference: isUnderAnyRoot(f.path, options.ReferenceRoots),
+			}
+			bucket[i].resolveIdentity()
+		}
+		sizeCh <- bucket
+	})
+	close(sizeCh)
+	fullWG.Wait()
+	close(done)
+
+	if options.Verbose {
+		fmt.Printf("Hashing: %d quick, %d full hashes, %d groups found - Complete\n",
+			stats.quick, stats.full, stats.groups)
+	}
+	return err
+}
+
+// splitByHash hashes the files of a bucket (from the cache where possible)
+// and returns the subsets sharing a hash
+func splitByHash(files []DuplicateFileInfo, mode FileHashType, options DuplicateOptions, cache *HashCache, counter *int64) [][]DuplicateFileInfo {
+	byHash := make(map[string][]DuplicateFileInfo)
+	var order []string
+	for _, file := range files {
+		hash, found := cache.LookupHash(file, mode)
+		if !found {
+			var err error
+			if mode == QuickHash {
+				hash, err = calculateQuickHash(file.Path, file.Algorithm)
+			} else {
+				hash, err = calculateFullHash(file.Path, file.Algorithm)
+			}
+			if err != nil {
+				if options.Verbose {
+					fmt.Fprintf(os.Stderr, "\nWarning: %v\n", err)
+				}
+				continue
+			}
+		}
+		if mode == QuickHash {
+			file.QuickHash = hash
+		} else {
+			file.FullHash = hash
+		}
+		if !found {
+			cache.StoreHash(file, mode)
+		}
+		atomic.AddInt64(counter, 1)
+		if _, ok := byHash[hash]; !ok {
+			order = append(order, hash)
+		}
+		byHash[hash] = append(byHash[hash], file)
+	}
+
+	var groups [][]DuplicateFileInfo
+	for _, hash := range order {
+		if len(byHash[hash]) > 1 {
+			groups = append(groups, byHash[hash])
+		}
+	}
+	return groups
+}
+
+// disjointRoots drops roots lying inside another root; walking the outer
+// root covers them, and no file may be listed twice or it would be reported
+// as a duplicate of itself
+func disjointRoots(roots []string) []string {
+	var result []string
+	for i, root := range roots {
+		nested := false
+		for j, other := range roots {
+			if i != j && isUnderAnyRoot(root, []string{other}) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			result = append(result, root)
+		}
+	}
+	return result
+}
+
+// processDuplicateStream runs the pipeline over the bucketed files and
+// handles every group as soon as it is complete: it is verified and the action
+// is applied right away, so groups are not collected. They are only kept when
+// something needs all of them: a written report, or a review or delete
+// confirmations, which ask on the terminal after the search.
+func processDuplicateStream(result *DuplicateResult, options DuplicateOptions, buckets *sizeBuckets,
+	cache *HashCache, startTime time.Time, filesScanned int) (*DuplicateResult, error) {
+	var duplicateGroups [][]DuplicateFileInfo
+	keep := func(group []DuplicateFileInfo) {
+		duplicateGroups = append(duplicateGroups, group)
+		result.Groups[fmt.Sprintf("%s#%d", FormatHash(group[0].Algorithm, group[0].FullHash), len(duplicateGroups))] = group
+	}
+
+	if options.Review || (options.Action == DeleteAction && !options.BatchMode) {
+		if err := streamDuplicateGroups(buckets, options, cache, keep); err != nil {
+			return nil, err
+		}
+		return finishDuplicateSearch(result, options, duplicateGroups, cache, startTime, filesScanned)
+	}
+
+	report := options.Verbose && options.OutputFileSpecified
+	verify := options.Verify && options.Action != NoAction
+	var stats verifyStats
+	processor := newGroupProcessor(options)
+	err := streamDuplicateGroups(buckets, options, cache, func(group []DuplicateFileInfo) {
+		parts := [][]DuplicateFileInfo{group}
+		if verify {
+			parts = verifyGroup(group, options, &stats)
+		}
+		for _, part := range parts {
+			part = processor.process(part)
+			result.DuplicateGroups++
+			addGroupStats(result, part)
+			if report {
+				keep(part)
+			}
+		}
+	})
+	processor.close()
+	if verify {
+		stats.print()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cache.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to save hash cache: %v\n", err)
+	}
+	result.TotalFiles = filesScanned
+	result.ProcessingTime = time.Since(startTime)
+	if result.DuplicateGroups == 0 {
+		fmt.Println("No duplicate files found.")
+		return result, nil
+	}
+	if options.Verbose {
+		OutputResults(result, options, duplicateGroups)
+	}
+	return result, nil
+}
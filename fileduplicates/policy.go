@@ -0,0 +1,169 @@
+package fileduplicates
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SelectionRule is one criterion for choosing the original of a group.
+// Rules are written as "shortest", "prefer:D:\Master", "avoid:copy" etc.
+type SelectionRule struct {
+	Kind  string // prefer, prefer-name, avoid, shortest, longest, shallowest, deepest, oldest, newest, alpha, alpha-desc
+	Value string // Path prefix or name pattern
+}
+
+// SelectionPolicy is an ordered list of rules; each rule only decides
+// between files the previous rules consider equal
+type SelectionPolicy []SelectionRule
+
+// selectionRuleKinds lists the rule kinds and whether they take a value
+var selectionRuleKinds = map[string]bool{
+	"prefer": true, "prefer-name": true, "avoid": true,
+	"shortest": false, "longest": false, "shallowest": false, "deepest": false,
+	"oldest": false, "newest": false, "alpha": false, "alpha-desc": false,
+}
+
+// ParseSelectionRule parses one rule, e.g. "prefer:D:\Photos" or "shortest"
+func ParseSelectionRule(s string) (SelectionRule, error) {
+	s = strings.TrimSpace(s)
+	kind, value := s, ""
+	if i := strings.Index(s, ":"); i > 0 {
+		kind, value = s[:i], strings.TrimSpace(s[i+1:])
+	}
+	kind = strings.ToLower(kind)
+	needsValue, ok := selectionRuleKinds[kind]
+	if !ok {
+		return SelectionRule{}, fmt.Errorf("unknown selection rule %q", s)
+	}
+	if needsValue && value == "" {
+		return SelectionRule{}, fmt.Errorf("selection rule %q needs a value, e.g. %s:<value>", kind, kind)
+	}
+	if !needsValue && value != "" {
+		return SelectionRule{}, fmt.Errorf("selection rule %q takes no value", kind)
+	}
+	return SelectionRule{Kind: kind, Value: value}, nil
+}
+
+// LoadSelectionPolicy reads a policy file with one rule per line. Empty
+// lines and lines starting with # are ignored.
+func LoadSelectionPolicy(path string) (SelectionPolicy, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open policy file: %w", err)
+	}
+	defer file.Close()
+
+	var policy SelectionPolicy
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := ParseSelectionRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		policy = append(policy, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading policy file: %w", err)
+	}
+	return policy, nil
+}
+
+// String formats the policy for messages, e.g. "prefer:D:\Master > shortest"
+func (p SelectionPolicy) String() string {
+	var parts []string
+	for _, rule := range p {
+		if rule.Value != "" {
+			parts = append(parts, rule.Kind+":"+rule.Value)
+		} else {
+			parts = append(parts, rule.Kind)
+		}
+	}
+	return strings.Join(parts, " > ")
+}
+
+// less reports whether a should be preferred over b as the original
+func (p SelectionPolicy) less(a, b DuplicateFileInfo) bool {
+	for _, rule := range p {
+		if c := rule.compare(a, b); c != 0 {
+			return c < 0
+		}
+	}
+	return false
+}
+
+// compare returns a negative number when a is the better original
+func (r SelectionRule) compare(a, b DuplicateFileInfo) int {
+	switch r.Kind {
+	case "prefer":
+		return boolRank(!isUnderAnyRoot(a.Path, []string{r.Value})) - boolRank(!isUnderAnyRoot(b.Path, []string{r.Value}))
+	case "prefer-name":
+		return boolRank(!nameMatches(r.Value, a.Path)) - boolRank(!nameMatches(r.Value, b.Path))
+	case "avoid":
+		return boolRank(nameMatches(r.Value, a.Path)) - boolRank(nameMatches(r.Value, b.Path))
+	case "shortest":
+		return len(a.Path) - len(b.Path)
+	case "longest":
+		return len(b.Path) - len(a.Path)
+	case "shallowest":
+		return pathDepth(a.Path) - pathDepth(b.Path)
+	case "deepest":
+		return pathDepth(b.Path) - pathDepth(a.Path)
+	case "oldest":
+		return a.CreatedTime.Compare(b.CreatedTime)
+	case "newest":
+		return b.CreatedTime.Compare(a.CreatedTime)
+	case "alpha":
+		return strings.Compare(a.Path, b.Path)
+	case "alpha-desc":
+		return strings.Compare(b.Path, a.Path)
+	}
+	return 0
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// nameMatches matches the file name against a glob (when it contains * ? or
+// [) or else a substring, ignoring case
+func nameMatches(pattern, path string) bool {
+	name := strings.ToLower(filepath.Base(path))
+	pattern = strings.ToLower(pattern)
+	if strings.ContainsAny(pattern, "*?[") {
+		ok, _ := filepath.Match(pattern, name)
+		return ok
+	}
+	return strings.Contains(name, pattern)
+}
+
+// pathDepth counts the directory levels of a path
+func pathDepth(path string) int {
+	return strings.Count(filepath.ToSlash(filepath.Clean(path)), "/")
+}
+
+// policyForMode expresses a legacy selection mode as a policy
+func policyForMode(mode DuplicateSelectionMode) SelectionPolicy {
+	switch mode {
+	case OldestAsOriginal:
+		return SelectionPolicy{{Kind: "oldest"}}
+	case NewestAsOriginal:
+		return SelectionPolicy{{Kind: "newest"}}
+	case FirstAlphaAsOriginal:
+		return SelectionPolicy{{Kind: "alpha"}}
+	case LastAlphaAsOriginal:
+		return SelectionPolicy{{Kind: "alpha-desc"}}
+	}
+	return nil
+}
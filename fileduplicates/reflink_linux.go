@@ -0,0 +1,27 @@
+//go:build linux
+
+package fileduplicates
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates dst as a copy-on-write clone of src using the FICLONE
+// ioctl (btrfs, xfs with reflink=1 and other filesystems supporting it).
+func cloneFile(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	return unix.IoctlFileClone(int(dstFile.Fd()), int(srcFile.Fd()))
+}
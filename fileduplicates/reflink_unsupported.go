@@ -0,0 +1,7 @@
+//go:build !linux
+
+package fileduplicates
+
+func cloneFile(src, dst string) error {
+	return errReflinkUnsupported
+}
@@ -0,0 +1,199 @@
+package fileduplicates
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report formats for the duplicate list
+const (
+	ReportText = "lst"  // "# Group N" / "* path" text format
+	ReportJSON = "json" // DuplicateReport as JSON
+	ReportCSV  = "csv"  // One row per file
+)
+
+// DuplicateReport is the structured form of a duplicate search, written as
+// JSON and accepted back by "cd from list".
+type DuplicateReport struct {
+	Generated       time.Time     `json:"generated"`
+	Roots           []string      `json:"roots"`
+	ReferenceRoots  []string      `json:"reference_roots,omitempty"`
+	Algorithm       HashAlgorithm `json:"algorithm"`
+	MaxDistance     int           `json:"max_distance,omitempty"` // Similar mode only
+	DurationSeconds float64       `json:"duration_seconds"`
+	TotalFiles      int           `json:"total_files"`
+	DuplicateGroups int           `json:"duplicate_groups"`
+	DuplicateFiles  int           `json:"duplicate_files"`
+	DuplicateSize   int64         `json:"duplicate_size"`
+	Groups          []ReportGroup `json:"groups"`
+}
+
+// ReportGroup is one set of identical files
+type ReportGroup struct {
+	Index int          `json:"index"`
+	Hash  string       `json:"hash"` // Qualified with the algorithm, e.g. "sha256:..."
+	Size  int64        `json:"size"`
+	Files []ReportFile `json:"files"`
+}
+
+// ReportFile is one member of a ReportGroup
+type ReportFile struct {
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mtime"`
+	IsOriginal  bool      `json:"original"`
+	IsReference bool      `json:"reference,omitempty"`
+	Similarity  float64   `json:"similarity,omitempty"` // Percent similar to the group original (similar mode)
+	IsDir       bool      `json:"folder,omitempty"`     // Whole directory tree (folder mode)
+	FileCount   int       `json:"files,omitempty"`      // Files in the tree (folder mode)
+}
+
+// ReportFormatFor picks the report format from an explicit choice or, when
+// none was given, from the output file extension.
+func ReportFormatFor(path, format string) string {
+	switch strings.ToLower(format) {
+	case ReportJSON, ReportCSV, ReportText:
+		return strings.ToLower(format)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ReportJSON
+	case ".csv":
+		return ReportCSV
+	}
+	return ReportText
+}
+
+// BuildReport converts a search result into its structured form
+func BuildReport(result *DuplicateResult, options DuplicateOptions, duplicateGroups [][]DuplicateFileInfo) DuplicateReport {
+	report := DuplicateReport{
+		Generated:       time.Now(),
+		Roots:           result.Roots,
+		ReferenceRoots:  options.ReferenceRoots,
+		Algorithm:       options.HashAlgorithm.normalize(),
+		DurationSeconds: result.ProcessingTime.Seconds(),
+		TotalFiles:      result.TotalFiles,
+		DuplicateGroups: result.DuplicateGroups,
+		DuplicateFiles:  result.DuplicateFiles,
+		DuplicateSize:   result.DuplicateSize,
+	}
+	if options.Similar {
+		report.Algorithm = options.SimilarAlgorithm
+		report.MaxDistance = options.MaxDistance
+	}
+	for i, group := range duplicateGroups {
+		if len(group) == 0 {
+			continue
+		}
+		rg := ReportGroup{Index: i + 1, Size: group[0].Size}
+		if group[0].FullHash != "" {
+			rg.Hash = FormatHash(group[0].Algorithm, group[0].FullHash)
+		}
+		for _, file := range group {
+			rf := ReportFile{
+				Path:        file.Path,
+				Size:        file.Size,
+				ModTime:     file.ModTime,
+				IsOriginal:  file.IsOriginal,
+				IsReference: file.IsReference,
+				IsDir:       file.IsDir,
+				FileCount:   file.FileCount,
+			}
+			rf.Similarity, _ = SimilarityPercent(group[0], file)
+			rg.Files = append(rg.Files, rf)
+		}
+		report.Groups = append(report.Groups, rg)
+	}
+	return report
+}
+
+// writeJSONReport writes the report as indented JSON
+func writeJSONReport(path string, report DuplicateReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling duplicate report: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// writeCSVReport writes one row per file. Run metadata is only available in
+// the JSON and text formats.
+func writeCSVReport(path string, report DuplicateReport) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"group", "hash", "size", "path", "mtime", "original", "reference", "similarity"})
+	for _, group := range report.Groups {
+		for _, f := range group.Files {
+			similarity := ""
+			if f.Similarity > 0 {
+				similarity = strconv.FormatFloat(f.Similarity, 'f', 1, 64)
+			}
+			writer.Write([]string{
+				strconv.Itoa(group.Index),
+				group.Hash,
+				strconv.FormatInt(f.Size, 10),
+				f.Path,
+				f.ModTime.Format(time.RFC3339),
+				strconv.FormatBool(f.IsOriginal),
+				strconv.FormatBool(f.IsReference),
+				similarity,
+			})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// LoadJSONReport reads a JSON duplicate report back into groups keyed by
+// their position in the report. Groups that share a hash (after a verify
+// split, or digests of different algorithms) stay separate.
+func LoadJSONReport(path string) (map[string][]DuplicateFileInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read duplicate report: %w", err)
+	}
+	var report DuplicateReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse duplicate report: %w", err)
+	}
+
+	groups := make(map[string][]DuplicateFileInfo)
+	for i, group := range report.Groups {
+		key := fmt.Sprintf("group_%d", i+1)
+		algorithm := report.Algorithm
+		hexHash := ""
+		if group.Hash != "" {
+			if a, h, err := ParseHash(group.Hash); err == nil {
+				algorithm, hexHash = a, h
+			}
+		}
+		for _, f := range group.Files {
+			groups[key] = append(groups[key], DuplicateFileInfo{
+				Path:        f.Path,
+				Size:        f.Size,
+				FullHash:    hexHash,
+				ModTime:     f.ModTime,
+				CreatedTime: f.ModTime, // Not recorded; as for scanned files
+				IsOriginal:  f.IsOriginal,
+				Algorithm:   algorithm,
+				IsDir:       f.IsDir,
+				FileCount:   f.FileCount,
+			})
+		}
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no duplicate groups found in report")
+	}
+	return groups, nil
+}
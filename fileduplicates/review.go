@@ -0,0 +1,225 @@
+package fileduplicates
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PLAN_MARKER is the header line that makes "cd from list" keep exactly the
+// files marked with "*" instead of choosing originals by selection mode
+const PLAN_MARKER = "# Plan: keep marked"
+
+// reviewStep is one entry of the undo history: the groups decided by a
+// single answer
+type reviewStep struct {
+	from, to int
+}
+
+// ReviewDuplicateGroups steps through the groups on the terminal and lets
+// the user choose which files to keep. Each group starts with the original
+// chosen by the selection mode marked; files under reference roots are always
+// kept. It returns the decided groups with IsOriginal set on kept files;
+// skipped groups are left out.
+func ReviewDuplicateGroups(duplicateGroups [][]DuplicateFileInfo, options DuplicateOptions, in io.Reader) [][]DuplicateFileInfo {
+	// Default marks, as ProcessDuplicateGroups would choose them
+	for i := range duplicateGroups {
+		group := duplicateGroups[i]
+		sortDuplicateGroup(&group, options)
+		for j := range group {
+			group[j].IsOriginal = j == 0 || group[j].IsReference
+		}
+	}
+
+	decisions := make([][]bool, len(duplicateGroups)) // nil = undecided or skipped
+	var history []reviewStep
+	reader := bufio.NewReader(in)
+
+	fmt.Printf("\nReviewing %d duplicate groups. Type ? for help.\n", len(duplicateGroups))
+	for i := 0; i < len(duplicateGroups); {
+		group := duplicateGroups[i]
+		printReviewGroup(i, len(duplicateGroups), group)
+		fmt.Printf("Keep [Enter=marked, 1,3=keep these, s=skip, a=accept rest, u=undo, q=quit]: ")
+
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Println()
+			break // End of input: stop like "q"
+		}
+		answer := strings.ToLower(strings.TrimSpace(line))
+
+		switch answer {
+		case "":
+			decisions[i] = defaultKeep(group)
+			history = append(history, reviewStep{i, i + 1})
+			i++
+		case "s", "skip":
+			decisions[i] = nil
+			history = append(history, reviewStep{i, i + 1})
+			i++
+		case "a", "all":
+			for j := i; j < len(duplicateGroups); j++ {
+				decisions[j] = defaultKeep(duplicateGroups[j])
+			}
+			history = append(history, reviewStep{i, len(duplicateGroups)})
+			fmt.Printf("Accepted the marked originals for %d remaining groups\n", len(duplicateGroups)-i)
+			i = len(duplicateGroups)
+		case "u", "undo":
+			if len(history) == 0 {
+				fmt.Println("Nothing to undo")
+				continue
+			}
+			last := history[len(history)-1]
+			history = history[:len(history)-1]
+			for j := last.from; j < last.to; j++ {
+				decisions[j] = nil
+			}
+			i = last.from
+		case "q", "quit":
+			i = len(duplicateGroups)
+			fmt.Println("Review stopped, undecided groups are left out of the plan")
+		case "?", "h", "help":
+			printReviewHelp()
+		default:
+			keep, err := parseKeepSelection(answer, group)
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				continue
+			}
+			decisions[i] = keep
+			history = append(history, reviewStep{i, i + 1})
+			i++
+		}
+	}
+
+	var decided [][]DuplicateFileInfo
+	for i, keep := range decisions {
+		if keep == nil {
+			continue
+		}
+		group := duplicateGroups[i]
+		removes := 0
+		for j := range group {
+			group[j].IsOriginal = keep[j]
+			if !keep[j] {
+				removes++
+			}
+		}
+		if removes > 0 {
+			decided = append(decided, group)
+		}
+	}
+	return decided
+}
+
+// defaultKeep returns the marks the group was shown with
+func defaultKeep(group []DuplicateFileInfo) []bool {
+	keep := make([]bool, len(group))
+	for j, file := range group {
+		keep[j] = file.IsOriginal
+	}
+	return keep
+}
+
+// parseKeepSelection parses "2" or "1,3" (1-based) into keep marks. Files
+// under reference roots are always kept.
+func parseKeepSelection(answer string, group []DuplicateFileInfo) ([]bool, error) {
+	keep := make([]bool, len(group))
+	for _, field := range strings.FieldsFunc(answer, func(r rune) bool { return r == ',' || r == ' ' }) {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 || n > len(group) {
+			return nil, fmt.Errorf("unknown answer %q, type ? for help", answer)
+		}
+		keep[n-1] = true
+	}
+	for j, file := range group {
+		if file.IsReference {
+			keep[j] = true
+		}
+	}
+	return keep, nil
+}
+
+// printReviewGroup shows one group with size, mtime and path of each member
+func printReviewGroup(index, total int, group []DuplicateFileInfo) {
+	fmt.Printf("\nGroup %d/%d (%d files, %.2f MB each)\n", index+1, total, len(group), float64(group[0].Size)/(1024*1024))
+	for j, file := range group {
+		mark := "    "
+		if file.IsOriginal {
+			mark = "keep"
+		}
+		extra := ""
+		if file.IsReference {
+			extra = " [reference]"
+		}
+		if file.IsDir {
+			extra += fmt.Sprintf(" [folder, %d files]", file.FileCount)
+		}
+		if percent, ok := SimilarityPercent(group[0], file); ok {
+			extra += fmt.Sprintf(" [%.1f%% similar]", percent)
+		}
+		fmt.Printf("  %2d %s %10.2f MB  %s  %s%s\n", j+1, mark, float64(file.Size)/(1024*1024),
+			file.ModTime.Format("2006-01-02 15:04"), file.Path, extra)
+	}
+}
+
+func printReviewHelp() {
+	fmt.Println(`  Enter   keep the files marked "keep", the others go into the plan as duplicates
+  2       keep file 2 only (1,3 keeps files 1 and 3)
+  s       skip this group (nothing in it is touched)
+  a       accept the marked files for this and all remaining groups
+  u       undo the previous answer
+  q       stop reviewing; answered groups are still written to the plan`)
+}
+
+// planPathFor returns where the reviewed plan is written: the list path,
+// with a .lst extension since the plan is always a text list
+func planPathFor(options DuplicateOptions) string {
+	path := options.OutputPath
+	if path == "" {
+		path = "duplicates.lst"
+	}
+	if ext := filepath.Ext(path); !strings.EqualFold(ext, ".lst") {
+		path = strings.TrimSuffix(path, ext) + ".lst"
+	}
+	return path
+}
+
+// WriteReviewPlan writes the reviewed groups as a duplicate list that
+// "cd from list" executes keeping exactly the marked files
+func WriteReviewPlan(path string, result *DuplicateResult, decided [][]DuplicateFileInfo) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating plan file: %w", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	fmt.Fprintf(writer, "# Duplicate files plan (reviewed)\n")
+	fmt.Fprintf(writer, "# Date: %s\n", time.Now().Format(time.RFC1123))
+	fmt.Fprintf(writer, "# Root path: %s\n", strings.Join(result.Roots, "; "))
+	fmt.Fprintf(writer, "%s\n", PLAN_MARKER)
+	fmt.Fprintf(writer, "# Reviewed groups: %d of %d\n\n", len(decided), result.DuplicateGroups)
+
+	for i, group := range decided {
+		fmt.Fprintf(writer, "# Group %d (%d files, %.2f MB each)\n",
+			i+1, len(group), float64(group[0].Size)/(1024*1024))
+		if group[0].FullHash != "" {
+			fmt.Fprintf(writer, "# Hash: %s\n", FormatHash(group[0].Algorithm, group[0].FullHash))
+		}
+		for _, f := range group {
+			mark := " "
+			if f.IsOriginal {
+				mark = "*"
+			}
+			fmt.Fprintf(writer, "%s %s\n", mark, f.Path)
+		}
+		fmt.Fprintf(writer, "\n")
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,71 @@
+package fileduplicates
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// collectRoots returns the main root followed by the extra and reference
+// roots, cleaned and without repeats.
+func collectRoots(rootPath string, options DuplicateOptions) []string {
+	var roots []string
+	seen := make(map[string]bool)
+
+	all := append([]string{rootPath}, options.ExtraRoots...)
+	all = append(all, options.ReferenceRoots...)
+	for _, root := range all {
+		if root == "" {
+			continue
+		}
+		clean := filepath.Clean(root)
+		key := rootKey(clean)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		roots = append(roots, clean)
+	}
+	return roots
+}
+
+// isUnderAnyRoot reports whether path equals or lies below one of the roots
+func isUnderAnyRoot(path string, roots []string) bool {
+	if len(roots) == 0 {
+		return false
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	p := rootKey(path)
+	for _, root := range roots {
+		if abs, err := filepath.Abs(root); err == nil {
+			root = abs
+		}
+		r := strings.TrimRight(rootKey(root), "/")
+		if p == r || strings.HasPrefix(p, r+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAnyRoot reports whether one of the roots lies below path, e.g. a
+// folder that holds a reference root
+func containsAnyRoot(path string, roots []string) bool {
+	for _, root := range roots {
+		if isUnderAnyRoot(root, []string{path}) {
+			return true
+		}
+	}
+	return false
+}
+
+// rootKey normalizes a path for comparison; Windows paths are case-insensitive
+func rootKey(path string) string {
+	p := filepath.ToSlash(filepath.Clean(path))
+	if runtime.GOOS == "windows" {
+		p = strings.ToLower(p)
+	}
+	return p
+}
@@ -0,0 +1,336 @@
+package fileduplicates
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"  // Register GIF decoder
+	_ "image/jpeg" // Register JPEG decoder
+	_ "image/png"  // Register PNG decoder
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Perceptual hashes used by "cd similar". They are 64-bit fingerprints of the
+// picture rather than of the bytes, so re-encoded or resized copies of an
+// image get hashes within a small Hamming distance of each other.
+const (
+	PerceptualAHash HashAlgorithm = "ahash" // Average hash: fastest, least robust
+	PerceptualDHash HashAlgorithm = "dhash" // Difference hash: fast, robust to brightness changes
+	PerceptualPHash HashAlgorithm = "phash" // DCT hash: slowest, most robust to re-encoding
+)
+
+// DEFAULT_SIMILAR_DISTANCE is the default maximum Hamming distance (out of 64
+// bits) between two images considered similar
+const DEFAULT_SIMILAR_DISTANCE = 10
+
+// similarImageExt lists the image types decoded by the standard library
+var similarImageExt = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+// isPerceptual reports whether the algorithm is a perceptual image hash
+func (a HashAlgorithm) isPerceptual() bool {
+	return a == PerceptualAHash || a == PerceptualDHash || a == PerceptualPHash
+}
+
+// ParsePerceptualAlgorithm converts a user supplied name into a perceptual
+// hash algorithm.
+func ParsePerceptualAlgorithm(name string) (HashAlgorithm, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "ahash", "average":
+		return PerceptualAHash, true
+	case "dhash", "difference":
+		return PerceptualDHash, true
+	case "phash", "dct":
+		return PerceptualPHash, true
+	}
+	return "", false
+}
+
+// isSimilarCandidate reports whether the file is an image "cd similar" can decode
+func isSimilarCandidate(path string) bool {
+	return similarImageExt[strings.ToLower(filepath.Ext(path))]
+}
+
+// calculatePerceptualHash decodes the image and returns its 64-bit perceptual
+// hash as 16 hex digits.
+func calculatePerceptualHash(filePath string, algorithm HashAlgorithm) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open image: %w", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image %s: %w", filePath, err)
+	}
+
+	var hash uint64
+	switch algorithm {
+	case PerceptualAHash:
+		hash = averageHash(img)
+	case PerceptualDHash:
+		hash = differenceHash(img)
+	default:
+		hash = dctHash(img)
+	}
+	return fmt.Sprintf("%016x", hash), nil
+}
+
+// grayThumbnail scales the image down to w x h luminance values by averaging
+// all source pixels that fall into each cell.
+func grayThumbnail(img image.Image, w, h int) []float64 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	sums := make([]float64, w*h)
+	counts := make([]int, w*h)
+	if width == 0 || height == 0 {
+		return sums
+	}
+
+	for y := 0; y < height; y++ {
+		row := (y * h / height) * w
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			cell := row + x*w/width
+			sums[cell] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			counts[cell]++
+		}
+	}
+	for i := range sums {
+		if counts[i] > 0 {
+			sums[i] /= float64(counts[i])
+		}
+	}
+	return sums
+}
+
+// averageHash sets one bit per cell of an 8x8 thumbnail that is brighter than
+// the mean.
+func averageHash(img image.Image) uint64 {
+	pixels := grayThumbnail(img, 8, 8)
+	mean := 0.0
+	for _, p := range pixels {
+		mean += p
+	}
+	mean /= float64(len(pixels))
+
+	var hash uint64
+	for i, p := range pixels {
+		if p > mean {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// differenceHash sets one bit per horizontal neighbour pair of a 9x8
+// thumbnail where the left pixel is brighter.
+func differenceHash(img image.Image) uint64 {
+	pixels := grayThumbnail(img, 9, 8)
+	var hash uint64
+	bit := 0
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if pixels[y*9+x] > pixels[y*9+x+1] {
+				hash |= 1 << uint(bit)
+			}
+			bit++
+		}
+	}
+	return hash
+}
+
+// dctHash takes the lowest 8x8 frequencies of the DCT of a 32x32 thumbnail and
+// sets one bit per coefficient above their median (the DC term is left out of
+// the median since it only reflects overall brightness).
+func dctHash(img image.Image) uint64 {
+	const size = 32
+	pixels := grayThumbnail(img, size, size)
+
+	var cosines [8][size]float64
+	for u := 0; u < 8; u++ {
+		for x := 0; x < size; x++ {
+			cosines[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * size))
+		}
+	}
+
+	coefficients := make([]float64, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					sum += pixels[y*size+x] * cosines[u][x] * cosines[v][y]
+				}
+			}
+			coefficients[v*8+u] = sum
+		}
+	}
+
+	sorted := append([]float64(nil), coefficients[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for i, c := range coefficients {
+		if c > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// perceptualDistance returns the Hamming distance between the perceptual
+// hashes of two files, or false when either has none.
+func perceptualDistance(a, b DuplicateFileInfo) (int, bool) {
+	if !a.Algorithm.isPerceptual() || a.Algorithm != b.Algorithm {
+		return 0, false
+	}
+	hashA, errA := strconv.ParseUint(a.FullHash, 16, 64)
+	hashB, errB := strconv.ParseUint(b.FullHash, 16, 64)
+	if errA != nil || errB != nil {
+		return 0, false
+	}
+	return bits.OnesCount64(hashA ^ hashB), true
+}
+
+// SimilarityPercent returns how similar file is to original (100 = same
+// perceptual hash), or false when the files were not compared perceptually.
+func SimilarityPercent(original, file DuplicateFileInfo) (float64, bool) {
+	distance, ok := perceptualDistance(original, file)
+	if !ok {
+		return 0, false
+	}
+	return 100 * float64(64-distance) / 64, true
+}
+
+// similarityScores lists the similarity of each group member to the first
+// one (e.g. "100.0%, 93.8%"), or "" for groups of identical files.
+func similarityScores(group []DuplicateFileInfo) string {
+	var scores []string
+	for _, file := range group {
+		percent, ok := SimilarityPercent(group[0], file)
+		if !ok {
+			return ""
+		}
+		scores = append(scores, fmt.Sprintf("%.1f%%", percent))
+	}
+	return strings.Join(scores, ", ")
+}
+
+// bkNode is a node of a BK-tree over Hamming distance, which finds all hashes
+// within a distance without comparing every pair.
+type bkNode struct {
+	hash     uint64
+	files    []int // Indexes of files sharing this hash
+	children map[int]*bkNode
+}
+
+func (n *bkNode) add(hash uint64, index int) {
+	for {
+		distance := bits.OnesCount64(n.hash ^ hash)
+		if distance == 0 {
+			n.files = append(n.files, index)
+			return
+		}
+		child, ok := n.children[distance]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[int]*bkNode)
+			}
+			n.children[distance] = &bkNode{hash: hash, files: []int{index}}
+			return
+		}
+		n = child
+	}
+}
+
+func (n *bkNode) search(hash uint64, maxDistance int, found func(index int)) {
+	distance := bits.OnesCount64(n.hash ^ hash)
+	if distance <= maxDistance {
+		for _, index := range n.files {
+			found(index)
+		}
+	}
+	for d, child := range n.children {
+		if d >= distance-maxDistance && d <= distance+maxDistance {
+			child.search(hash, maxDistance, found)
+		}
+	}
+}
+
+// groupSimilar clusters files whose perceptual hashes lie within maxDistance
+// of a group's first member. Files are visited in path order so the grouping
+// is the same on every run.
+func groupSimilar(files []DuplicateFileInfo, maxDistance int) [][]DuplicateFileInfo {
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	hashes := make([]uint64, len(files))
+	var root *bkNode
+	for i, file := range files {
+		hash, err := strconv.ParseUint(file.FullHash, 16, 64)
+		if err != nil {
+			continue
+		}
+		hashes[i] = hash
+		if root == nil {
+			root = &bkNode{hash: hash, files: []int{i}}
+		} else {
+			root.add(hash, i)
+		}
+	}
+	if root == nil {
+		return nil
+	}
+
+	grouped := make([]bool, len(files))
+	var groups [][]DuplicateFileInfo
+	for i := range files {
+		if grouped[i] || files[i].FullHash == "" {
+			continue
+		}
+		var members []int
+		root.search(hashes[i], maxDistance, func(index int) {
+			if !grouped[index] {
+				members = append(members, index)
+			}
+		})
+		if len(members) < 2 {
+			continue
+		}
+		sort.Ints(members)
+		group := make([]DuplicateFileInfo, 0, len(members))
+		for _, index := range members {
+			grouped[index] = true
+			group = append(group, files[index])
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// findSimilarImages computes perceptual hashes for all scanned images and
+// groups similar ones.
+func findSimilarImages(filesBySize map[int64][]DuplicateFileInfo, options DuplicateOptions, cache *HashCache) [][]DuplicateFileInfo {
+	var images []DuplicateFileInfo
+	for _, files := range filesBySize {
+		for _, file := range files {
+			file.Algorithm = options.SimilarAlgorithm
+			file.resolveIdentity()
+			images = append(images, file)
+		}
+	}
+
+	if options.Verbose {
+		fmt.Printf("Found %d images. Calculating %s perceptual hashes...\n",
+			len(images), options.SimilarAlgorithm)
+	}
+
+	hashed := hashFiles(images, FullHash, "Image hash", options, cache, NewHashWorker(GetOptimalWorkerCount()))
+	return groupSimilar(hashed, options.MaxDistance)
+}
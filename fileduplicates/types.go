@@ -2,6 +2,7 @@
 package fileduplicates
 
 import (
+	"os"
 	"runtime"
 	"sync"
 	"time"
@@ -12,7 +13,20 @@ const (
 	MIN_DUPLICATE_FILE_SIZE = 16                // Minimum file size to consider (in bytes)
 	QUICK_HASH_SIZE         = 4096              // Size for quick hash sample (4KB)
 	MAX_WORKERS             = 24                // Maximum concurrent hash workers
-	HASH_CACHE_FILE         = "hash_cache.json" // Filename for hash cache
+	HASH_CACHE_FILE         = "hash_cache.log"  // Filename for hash cache (JSON lines, append-only)
+	HASH_CACHE_LEGACY_FILE  = "hash_cache.json" // Filename of the old whole-file cache, converted once
+	HASH_CACHE_FLUSH_EVERY  = 1000              // Unsaved entries appended to the log in one batch
+	HASH_CACHE_MAX_AGE_DAYS = 30                // Entries not seen for this long are dropped on compaction
+	DUPLICATE_INDEX_DIR     = "duplicate_index" // Folder next to the hash cache holding one index per root
+	DEFAULT_MEMORY_LIMIT_MB = 1024              // Memory budget of a search; larger file lists spill to disk
+	SPILL_PARTITIONS        = 256               // Size partitions of the spill files
+)
+
+// Timing of "cd watch"
+const (
+	WATCH_SETTLE_DELAY  = 2 * time.Second  // Quiet time before collected changes are processed
+	WATCH_MAX_DELAY     = 30 * time.Second // Collected changes are processed at the latest after this
+	WATCH_POLL_INTERVAL = 5 * time.Second  // Where no change notification exists, roots are polled
 )
 
 // FileHashType indicates the type of hash
@@ -37,37 +51,73 @@ const (
 type DuplicateAction int
 
 const (
-	NoAction     DuplicateAction = iota // Just report duplicates
-	MoveAction                          // Move duplicates to target directory
-	DeleteAction                        // Delete duplicates
+	NoAction       DuplicateAction = iota // Just report duplicates
+	MoveAction                            // Move duplicates to target directory
+	DeleteAction                          // Delete duplicates
+	HardlinkAction                        // Replace duplicates with hard links to the original
+	ReflinkAction                         // Replace duplicates with copy-on-write clones of the original
 )
 
 // DuplicateFileInfo stores information about a file for duplicate detection
 type DuplicateFileInfo struct {
 	Path        string
 	Size        int64
-	QuickHash   string    // Hash of first few KB
-	FullHash    string    // Complete file hash
-	LastAccess  time.Time // When the file was last accessed
-	CreatedTime time.Time // When the file was created
-	ModTime     time.Time // When the file was last modified
-	IsOriginal  bool      // Whether this file is considered the original
+	QuickHash   string        // Hash of first few KB
+	FullHash    string        // Complete file hash
+	LastAccess  time.Time     // When the file was last accessed
+	CreatedTime time.Time     // When the file was created
+	ModTime     time.Time     // When the file was last modified
+	IsOriginal  bool          // Whether this file is considered the original
+	Algorithm   HashAlgorithm // Algorithm used for QuickHash and FullHash
+	IsReference bool          // Whether the file lies under a reference root (never modified)
+	Volume      uint64        // Volume serial / device number (0 if unknown)
+	FileID      uint64        // File index / inode number (0 if unknown)
+	IsDir       bool          // Whether this is a whole directory tree (folder mode)
+	FileCount   int           // Files in the tree (folder mode)
 }
 
-// HashCache stores file hashes for reuse between runs
+// HashCache stores file hashes for reuse between runs.
+// Entries are appended to a log file as JSON lines and only an index of
+// record positions is kept in memory, so the cache scales to millions of
+// files. Entries are keyed by volume and file ID, so renamed or moved files
+// keep their hashes. Several processes may share the cache; appends and
+// compaction are serialized with a lock file.
 type HashCache struct {
-	Entries map[string]CacheEntry
-	mutex   sync.RWMutex
+	MaxAge     time.Duration // Entries not seen for this long are dropped on compaction
+	path       string
+	file       *os.File // Open log, nil for a memory-only cache
+	lock       *os.File
+	index      map[cacheKey]cacheRef
+	pending    map[cacheKey]CacheEntry // Entries not yet appended to the log
+	records    int                     // Records in the log, including superseded ones
+	oldestSeen time.Time
+	mutex      sync.Mutex
+}
+
+// cacheKey identifies a file in the hash cache
+type cacheKey struct {
+	volume uint64
+	fileID uint64
+}
+
+// cacheRef is the position of a record in the cache log
+type cacheRef struct {
+	offset int64
+	length int32
 }
 
 // CacheEntry represents a single cached hash entry.
 // A cached hash is only valid when both Size and ModTime still match the file
 // on disk, so a file that changed but kept the same size cannot reuse a stale
-// hash (which could otherwise cause false duplicate matches).
+// hash (which could otherwise cause false duplicate matches). Hashes produced
+// by a different Algorithm never match either.
 type CacheEntry struct {
 	Path      string
+	Volume    uint64 `json:",omitempty"`
+	FileID    uint64 `json:",omitempty"`
 	Size      int64
 	ModTime   time.Time
+	Algorithm HashAlgorithm `json:",omitempty"`
 	QuickHash string
 	FullHash  string
 	LastSeen  time.Time
@@ -95,12 +145,32 @@ type hashResult struct {
 type DuplicateOptions struct {
 	OutputPath          string                 // Path to output file
 	OutputFileSpecified bool                   // Whether output file was specified
+	ReportFormat        string                 // lst, json or csv (empty = by OutputPath extension)
 	Verbose             bool                   // Whether to print verbose output
 	SelectionMode       DuplicateSelectionMode // How to select original files
 	Action              DuplicateAction        // What to do with duplicates
 	TargetDir           string                 // Where to move duplicates
 	IsDevice            bool                   // Whether root path is a device
 	BatchMode           bool                   // Whether to skip confirmation prompts
+	HashAlgorithm       HashAlgorithm          // Content hash used to compare files
+	Verify              bool                   // Byte-compare duplicates with the original before acting
+	ExtraRoots          []string               // Additional roots scanned together with the main root
+	ReferenceRoots      []string               // Roots whose files are always originals and never modified
+	Filter              FileFilter             // Which files take part in the search
+	Similar             bool                   // Group visually similar images instead of identical files
+	SimilarAlgorithm    HashAlgorithm          // Perceptual hash used in similar mode
+	MaxDistance         int                    // Maximum Hamming distance between similar images
+	AllowSimilar        bool                   // Allow del/move of similar images without review
+	Folders             bool                   // Report identical directory trees instead of files
+	Review              bool                   // Decide group by group on the terminal and write a plan
+	KeepMarked          bool                   // Keep the files marked as original (reviewed plans)
+	Quarantine          string                 // Deletes move files into a dated folder below this one
+	JournalPath         string                 // Undo journal for moves (default: in the target folder)
+	Policy              SelectionPolicy        // Ordered rules choosing originals; overrides SelectionMode
+	Update              bool                   // Refresh the per-root index instead of scanning everything
+	Watch               bool                   // Keep the index current and report new duplicates until Ctrl+C
+	MemoryLimitMB       int                    // Memory budget in MB (0 = DEFAULT_MEMORY_LIMIT_MB)
+	SpillDir            string                 // Where file lists are spilled (default: system temp folder)
 }
 
 // Default options for duplicate processing
@@ -114,6 +184,10 @@ func DefaultOptions() DuplicateOptions {
 		TargetDir:           "",
 		IsDevice:            false,
 		BatchMode:           false,
+		HashAlgorithm:       HashMD5,
+		Verify:              false,
+		SimilarAlgorithm:    PerceptualDHash,
+		MaxDistance:         DEFAULT_SIMILAR_DISTANCE,
 	}
 }
 
@@ -0,0 +1,184 @@
+package fileduplicates
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// VERIFY_BUFFER_SIZE is the chunk size used for byte-for-byte comparison
+const VERIFY_BUFFER_SIZE = 256 * 1024
+
+// VerifyDuplicateGroups confirms every group byte-for-byte before a
+// destructive action. Each group is sorted by the selection mode and split
+// into sets of files whose content is really identical; the first set always
+// starts with the chosen original. Files that match no other member of their
+// group are reported and dropped, so they are never acted on.
+func VerifyDuplicateGroups(duplicateGroups [][]DuplicateFileInfo, options DuplicateOptions) [][]DuplicateFileInfo {
+	var verified [][]DuplicateFileInfo
+	var stats verifyStats
+
+	fmt.Printf("Verifying %d duplicate groups byte-for-byte...\n", len(duplicateGroups))
+
+	for _, group := range duplicateGroups {
+		verified = append(verified, verifyGroup(group, options, &stats)...)
+	}
+
+	stats.print()
+	return verified
+}
+
+// verifyStats counts the outcome of verification over several groups
+type verifyStats struct {
+	confirmed, split, rejected int
+}
+
+// print writes the verification summary
+func (s verifyStats) print() {
+	fmt.Printf("Verification complete: %d groups confirmed, %d groups split, %d files rejected\n",
+		s.confirmed, s.split, s.rejected)
+}
+
+// verifyGroup confirms one group byte-for-byte, see VerifyDuplicateGroups
+func verifyGroup(group []DuplicateFileInfo, options DuplicateOptions, stats *verifyStats) [][]DuplicateFileInfo {
+	sortDuplicateGroup(&group, options)
+
+	parts, rejected := splitByContent(group)
+	if len(parts) > 1 || len(rejected) > 0 {
+		stats.split++
+	}
+	for _, file := range rejected {
+		fmt.Printf("Verify: %s does not match any other file in its group, skipped\n", file.Path)
+	}
+	stats.rejected += len(rejected)
+	stats.confirmed += len(parts)
+	return parts
+}
+
+// splitByContent partitions a group into runs of byte-identical files. Each
+// remaining file is compared with the head of the first partition it matches,
+// so partitions keep the group order. Single files are returned as rejected.
+func splitByContent(group []DuplicateFileInfo) ([][]DuplicateFileInfo, []DuplicateFileInfo) {
+	var parts [][]DuplicateFileInfo
+	var rejected []DuplicateFileInfo
+
+	for _, file := range group {
+		placed := false
+		for i := range parts {
+			same, err := contentIdentical(parts[i][0], file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Verify error: %v\n", err)
+				break
+			}
+			if same {
+				parts[i] = append(parts[i], file)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			parts = append(parts, []DuplicateFileInfo{file})
+		}
+	}
+
+	var confirmed [][]DuplicateFileInfo
+	for _, part := range parts {
+		if len(part) > 1 {
+			confirmed = append(confirmed, part)
+		} else {
+			rejected = append(rejected, part...)
+		}
+	}
+	return confirmed, rejected
+}
+
+// contentIdentical compares two group members: files byte for byte, folders
+// entry by entry.
+func contentIdentical(a, b DuplicateFileInfo) (bool, error) {
+	if a.IsDir || b.IsDir {
+		if !a.IsDir || !b.IsDir {
+			return false, nil
+		}
+		return dirsIdentical(a.Path, b.Path)
+	}
+	return FilesIdentical(a.Path, b.Path)
+}
+
+// FilesIdentical streams both files and reports whether their contents are
+// equal. It stops at the first differing chunk.
+func FilesIdentical(pathA, pathB string) (bool, error) {
+	fileA, err := os.Open(pathA)
+	if err != nil {
+		return false, fmt.Errorf("failed to open %s for verification: %w", pathA, err)
+	}
+	defer fileA.Close()
+
+	fileB, err := os.Open(pathB)
+	if err != nil {
+		return false, fmt.Errorf("failed to open %s for verification: %w", pathB, err)
+	}
+	defer fileB.Close()
+
+	infoA, err := fileA.Stat()
+	if err != nil {
+		return false, err
+	}
+	infoB, err := fileB.Stat()
+	if err != nil {
+		return false, err
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+
+	bufA := make([]byte, VERIFY_BUFFER_SIZE)
+	bufB := make([]byte, VERIFY_BUFFER_SIZE)
+	for {
+		nA, errA := io.ReadFull(fileA, bufA)
+		nB, errB := io.ReadFull(fileB, bufB)
+		if nA != nB || !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		endA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		endB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		if errA != nil && !endA {
+			return false, fmt.Errorf("failed to read %s for verification: %w", pathA, errA)
+		}
+		if errB != nil && !endB {
+			return false, fmt.Errorf("failed to read %s for verification: %w", pathB, errB)
+		}
+		if endA || endB {
+			return endA == endB, nil
+		}
+	}
+}
+
+// checkUnchanged re-stats a file right before an action and returns a reason
+// when it no longer matches what was recorded during the scan.
+func checkUnchanged(file DuplicateFileInfo) string {
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "no longer exists"
+		}
+		return fmt.Sprintf("cannot stat: %v", err)
+	}
+	if file.IsDir {
+		// A folder's own mtime changes when entries are added or removed
+		if !info.IsDir() {
+			return "is no longer a folder"
+		}
+		if !file.ModTime.IsZero() && !info.ModTime().Equal(file.ModTime) {
+			return "modified since scanning"
+		}
+		return ""
+	}
+	if info.Size() != file.Size {
+		return fmt.Sprintf("size changed from %d to %d bytes", file.Size, info.Size())
+	}
+	if !file.ModTime.IsZero() && !info.ModTime().Equal(file.ModTime) {
+		return "modified since scanning"
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+package fileduplicates
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// changeWatcher reports directories in which something changed
+type changeWatcher interface {
+	Add(dir string) error   // Watch one more directory (no-op for recursive watchers)
+	Changes() <-chan string // Directories with changes; closed when the watcher fails
+	Close() error
+}
+
+// watchDuplicates answers "cd watch": it brings the indexes up to date once
+// and then keeps them current from change notifications, reporting every
+// duplicate that appears until Ctrl+C is pressed. Nothing is moved or
+// deleted while watching.
+func watchDuplicates(result *DuplicateResult, options DuplicateOptions, cache *HashCache) (*DuplicateResult, error) {
+	if options.Action != NoAction || options.Review {
+		fmt.Println("Warning: watch mode only reports duplicates, the action is ignored")
+	}
+
+	indexes := updateIndexes(result.Roots, options)
+	groups := indexGroups(indexes, options, cache)
+	reportNewDuplicates(indexes, groups)
+	saveIndexes(indexes)
+	if err := cache.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to save hash cache: %v\n", err)
+	}
+	fmt.Printf("\n%d duplicate groups in %d indexed files\n", len(groups), indexedFiles(indexes))
+
+	watcher, err := newChangeWatcher(result.Roots)
+	if err != nil {
+		return result, fmt.Errorf("cannot watch for changes: %w", err)
+	}
+	defer watcher.Close()
+	addWatches(watcher, indexes)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	defer signal.Stop(stop)
+	fmt.Println("Watching for changes, press Ctrl+C to stop...")
+
+	// Changes arrive in bursts (a copy touches a file many times), so they are
+	// collected until things have been quiet for a moment. A steady stream
+	// (a download, a sync client) is still processed every WATCH_MAX_DELAY.
+	pending := make(map[string]bool)
+	var settle <-chan time.Time
+	var firstChange time.Time
+	for {
+		select {
+		case dir, ok := <-watcher.Changes():
+			if !ok {
+				return result, fmt.Errorf("change notification stopped")
+			}
+			if len(pending) == 0 {
+				firstChange = time.Now()
+			}
+			pending[dir] = true
+			delay := WATCH_SETTLE_DELAY
+			if left := WATCH_MAX_DELAY - time.Since(firstChange); left < delay {
+				delay = left
+			}
+			settle = time.After(delay)
+		case <-settle:
+			settle = nil
+			scanStart := time.Now()
+			for dir := range pending {
+				for _, x := range indexes {
+					// Folders the index leaves out (filtered) are of no interest
+					if _, ok := x.Dirs[dir]; ok || dir == x.Root {
+						var stats indexStats
+						x.refresh(dir, true, scanStart, &stats)
+					}
+				}
+			}
+			pending = make(map[string]bool)
+
+			groups = indexGroups(indexes, options, cache)
+			if fresh := reportNewDuplicates(indexes, groups); fresh > 0 {
+				fmt.Printf("\n[%s] %d new duplicate groups, %d in total\n", time.Now().Format("15:04:05"), fresh, len(groups))
+			}
+			saveIndexes(indexes)
+			if err := cache.Save(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: Failed to save hash cache: %v\n", err)
+			}
+			addWatches(watcher, indexes)
+		case <-stop:
+			fmt.Println("\nStopped watching.")
+			result.TotalFiles = indexedFiles(indexes)
+			result.DuplicateGroups = len(groups)
+			return result, nil
+		}
+	}
+}
+
+// addWatches watches every indexed directory; watchers remember which ones
+// they already watch
+func addWatches(watcher changeWatcher, indexes []*DuplicateIndex) {
+	warned := false
+	for _, x := range indexes {
+		for dir := range x.Dirs {
+			if err := watcher.Add(dir); err != nil && !warned {
+				fmt.Fprintf(os.Stderr, "Warning: cannot watch %s: %v (changes there are missed)\n", dir, err)
+				warned = true
+			}
+		}
+	}
+}
+
+// indexedFiles returns the number of files in all indexes
+func indexedFiles(indexes []*DuplicateIndex) int {
+	count := 0
+	for _, x := range indexes {
+		count += x.fileCount()
+	}
+	return count
+}
@@ -0,0 +1,102 @@
+//go:build linux
+
+package fileduplicates
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyWatcher watches single directories with inotify
+type inotifyWatcher struct {
+	file    *os.File
+	roots   []string
+	changes chan string
+	mutex   sync.Mutex
+	dirs    map[int]string // Watch descriptor -> directory
+	watched map[string]bool
+}
+
+const inotifyMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+	unix.IN_CLOSE_WRITE | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_ONLYDIR
+
+func newChangeWatcher(roots []string) (changeWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		// Non-blocking, so that Close interrupts the pending Read
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		roots:   roots,
+		changes: make(chan string, 256),
+		dirs:    make(map[int]string),
+		watched: make(map[string]bool),
+	}
+	go w.read()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Add(dir string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.watched[dir] {
+		return nil
+	}
+	wd, err := unix.InotifyAddWatch(int(w.file.Fd()), dir, inotifyMask)
+	if err != nil {
+		return err
+	}
+	w.dirs[wd] = dir
+	w.watched[dir] = true
+	return nil
+}
+
+func (w *inotifyWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
+
+// read turns inotify events into changed directories. When the event queue
+// overflows, the roots are reported so the whole trees are checked.
+func (w *inotifyWatcher) read() {
+	defer close(w.changes)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			w.mutex.Lock()
+			dir := w.dirs[int(event.Wd)]
+			if event.Mask&unix.IN_IGNORED != 0 {
+				// The directory is gone; a new one with its name needs a new watch
+				delete(w.dirs, int(event.Wd))
+				delete(w.watched, dir)
+			}
+			w.mutex.Unlock()
+
+			switch {
+			case event.Mask&unix.IN_Q_OVERFLOW != 0:
+				for _, root := range w.roots {
+					w.changes <- root
+				}
+			case dir == "":
+			case event.Mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0:
+				w.changes <- filepath.Dir(dir)
+			case event.Mask&unix.IN_IGNORED == 0:
+				w.changes <- dir
+			}
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+		}
+	}
+}
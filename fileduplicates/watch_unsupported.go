@@ -0,0 +1,50 @@
+//go:build !linux && !windows
+
+package fileduplicates
+
+import "time"
+
+// pollWatcher reports the roots at a fixed interval. Updating a root only
+// reads directories whose modification time changed, so polling stays cheap.
+type pollWatcher struct {
+	changes chan string
+	done    chan struct{}
+}
+
+func newChangeWatcher(roots []string) (changeWatcher, error) {
+	w := &pollWatcher{changes: make(chan string), done: make(chan struct{})}
+	go func() {
+		defer close(w.changes)
+		ticker := time.NewTicker(WATCH_POLL_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, root := range roots {
+					select {
+					case w.changes <- root:
+					case <-w.done:
+						return
+					}
+				}
+			case <-w.done:
+				return
+			}
+		}
+	}()
+	return w, nil
+}
+
+// Add does nothing, the roots are polled as a whole
+func (w *pollWatcher) Add(dir string) error {
+	return nil
+}
+
+func (w *pollWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *pollWatcher) Close() error {
+	close(w.done)
+	return nil
+}
@@ -0,0 +1,93 @@
+//go:build windows
+
+package fileduplicates
+
+import (
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// directoryWatcher watches whole trees with ReadDirectoryChangesW, one
+// handle per root
+type directoryWatcher struct {
+	handles []windows.Handle
+	changes chan string
+	wg      sync.WaitGroup
+}
+
+const directoryChangeMask = windows.FILE_NOTIFY_CHANGE_FILE_NAME | windows.FILE_NOTIFY_CHANGE_DIR_NAME |
+	windows.FILE_NOTIFY_CHANGE_SIZE | windows.FILE_NOTIFY_CHANGE_LAST_WRITE
+
+func newChangeWatcher(roots []string) (changeWatcher, error) {
+	w := &directoryWatcher{changes: make(chan string, 256)}
+	for _, root := range roots {
+		rootPtr, err := windows.UTF16PtrFromString(root)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		handle, err := windows.CreateFile(rootPtr, windows.FILE_LIST_DIRECTORY,
+			windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+			nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w.handles = append(w.handles, handle)
+		w.wg.Add(1)
+		go w.read(root, handle)
+	}
+	go func() {
+		w.wg.Wait()
+		close(w.changes)
+	}()
+	return w, nil
+}
+
+// Add does nothing, every root is watched recursively
+func (w *directoryWatcher) Add(dir string) error {
+	return nil
+}
+
+func (w *directoryWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *directoryWatcher) Close() error {
+	for _, handle := range w.handles {
+		windows.CancelIoEx(handle, nil)
+		windows.CloseHandle(handle)
+	}
+	w.handles = nil
+	return nil
+}
+
+// read turns change records into changed directories. When the buffer
+// overflows, the root is reported so the whole tree is checked.
+func (w *directoryWatcher) read(root string, handle windows.Handle) {
+	defer w.wg.Done()
+	buf := make([]byte, 64*1024)
+	for {
+		var n uint32
+		err := windows.ReadDirectoryChanges(handle, &buf[0], uint32(len(buf)), true, directoryChangeMask, &n, nil, 0)
+		if err != nil {
+			return
+		}
+		if n == 0 {
+			w.changes <- root
+			continue
+		}
+		for offset := uint32(0); ; {
+			info := (*windows.FileNotifyInformation)(unsafe.Pointer(&buf[offset]))
+			name := windows.UTF16ToString(unsafe.Slice(&info.FileName, info.FileNameLength/2))
+			w.changes <- filepath.Dir(filepath.Join(root, name))
+			if info.NextEntryOffset == 0 {
+				break
+			}
+			offset += info.NextEntryOffset
+		}
+	}
+}
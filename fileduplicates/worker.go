@@ -1,11 +1,12 @@
 package fileduplicates
 
 import (
-	"crypto/md5"
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -62,14 +63,21 @@ func (hw *HashWorker) worker() {
 		result.file = job.file
 
 		if job.mode == QuickHash {
-			hash, err := calculateQuickHash(job.file.Path)
+			hash, err := calculateQuickHash(job.file.Path, job.file.Algorithm)
 			if err != nil {
 				result.err = err
 			} else {
 				result.file.QuickHash = hash
 			}
+		} else if job.file.Algorithm.isPerceptual() {
+			hash, err := calculatePerceptualHash(job.file.Path, job.file.Algorithm)
+			if err != nil {
+				result.err = err
+			} else {
+				result.file.FullHash = hash
+			}
 		} else {
-			hash, err := calculateFullHash(job.file.Path)
+			hash, err := calculateFullHash(job.file.Path, job.file.Algorithm)
 			if err != nil {
 				result.err = err
 			} else {
@@ -84,15 +92,81 @@ func (hw *HashWorker) worker() {
 	}
 }
 
+// hashFiles returns the files with their hash of the given type filled in,
+// taken from the cache or computed by the worker pool. The pool is used up.
+// Files that cannot be hashed are left out.
+func hashFiles(files []DuplicateFileInfo, mode FileHashType, label string, options DuplicateOptions, cache *HashCache, worker *HashWorker) []DuplicateFileInfo {
+	var hashed []DuplicateFileInfo
+	resultsMutex := sync.Mutex{}
+	processedCount := int64(0)
+	totalCount := int64(len(files))
+	hashStartTime := time.Now()
+
+	report := func() {
+		processed := atomic.AddInt64(&processedCount, 1)
+		if options.Verbose && processed%50 == 0 {
+			elapsed := time.Since(hashStartTime)
+			percent := float64(processed) / float64(totalCount) * 100
+			timePerFile := elapsed.Seconds() / float64(processed)
+			rs := timePerFile * float64(totalCount-processed)
+			fmt.Printf("%s progress: %d/%d (%.1f%%, ETA: %s)\r",
+				label, processed, totalCount, percent, formatETA(time.Duration(rs)*time.Second))
+		}
+	}
+
+	// Consumer goroutine: started BEFORE submitting so workers never block on a
+	// full results channel
+	consumerDone := make(chan struct{})
+	go func() {
+		defer close(consumerDone)
+		for result := range worker.results {
+			if result.err == nil {
+				cache.StoreHash(result.file, mode)
+				resultsMutex.Lock()
+				hashed = append(hashed, result.file)
+				resultsMutex.Unlock()
+			} else if options.Verbose {
+				fmt.Fprintf(os.Stderr, "\nWarning: %v\n", result.err)
+			}
+			report()
+		}
+	}()
+
+	for _, file := range files {
+		fileCopy := file
+		if hash, found := cache.LookupHash(fileCopy, mode); found {
+			if mode == QuickHash {
+				fileCopy.QuickHash = hash
+			} else {
+				fileCopy.FullHash = hash
+			}
+			resultsMutex.Lock()
+			hashed = append(hashed, fileCopy)
+			resultsMutex.Unlock()
+			report()
+		} else {
+			worker.AddJob(fileCopy, mode)
+		}
+	}
+
+	worker.Wait()
+	<-consumerDone
+
+	if options.Verbose {
+		fmt.Printf("%s progress: %d/%d (100.0%%) - Complete\n", label, totalCount, totalCount)
+	}
+	return hashed
+}
+
 // Calculate a quick hash of just the first few KB of a file
-func calculateQuickHash(filePath string) (string, error) {
+func calculateQuickHash(filePath string, algorithm HashAlgorithm) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to open file for quick hash: %w", err)
 	}
 	defer file.Close()
 
-	hasher := md5.New()
+	hasher := algorithm.newHasher()
 	buffer := make([]byte, QUICK_HASH_SIZE)
 
 	n, err := file.Read(buffer)
@@ -105,14 +179,14 @@ func calculateQuickHash(filePath string) (string, error) {
 }
 
 // Calculate a hash of the entire file
-func calculateFullHash(filePath string) (string, error) {
+func calculateFullHash(filePath string, algorithm HashAlgorithm) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to open file for full hash: %w", err)
 	}
 	defer file.Close()
 
-	hasher := md5.New()
+	hasher := algorithm.newHasher()
 	if _, err := io.Copy(hasher, file); err != nil {
 		return "", fmt.Errorf("failed to read file for full hash: %w", err)
 	}
@@ -120,22 +194,63 @@ func calculateFullHash(filePath string) (string, error) {
 	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
 }
 
-// Load hash cache from disk
+// HashFile returns the full hash of a single file, taken from the cache while
+// its size and modification time are unchanged. A computed hash is stored in
+// the cache.
+func HashFile(cache *HashCache, path string, algorithm HashAlgorithm) (string, error) {
+	file, err := GetFileInfo(path)
+	if err != nil {
+		return "", err
+	}
+	file.Algorithm = algorithm
+	file.resolveIdentity()
+	if hash, found := cache.LookupHash(file, FullHash); found {
+		return hash, nil
+	}
+	hash, err := calculateFullHash(path, algorithm)
+	if err != nil {
+		return "", err
+	}
+	file.FullHash = hash
+	cache.StoreHash(file, FullHash)
+	return hash, nil
+}
+
+// Load hash cache from disk. An old hash_cache.json next to the log is
+// converted on first use. If the log cannot be opened, a memory-only cache is
+// returned together with the error.
 func LoadHashCache() (*HashCache, error) {
+	return openHashCache(GetHashCachePath())
+}
+
+// openHashCache opens the cache log at path, see LoadHashCache
+func openHashCache(path string) (*HashCache, error) {
 	cache := &HashCache{
-		Entries: make(map[string]CacheEntry),
+		MaxAge:  HASH_CACHE_MAX_AGE_DAYS * 24 * time.Hour,
+		path:    path,
+		index:   make(map[cacheKey]cacheRef),
+		pending: make(map[cacheKey]CacheEntry),
 	}
 
-	data, err := os.ReadFile(GetHashCachePath())
+	lock, err := os.OpenFile(cache.path+".lock", os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return cache, nil // Not an error if file doesn't exist
-		}
-		return cache, fmt.Errorf("failed to read hash cache file: %w", err)
+		return cache, fmt.Errorf("failed to open hash cache lock: %w", err)
+	}
+	if err := lockFile(lock, true); err != nil {
+		lock.Close()
+		return cache, fmt.Errorf("failed to lock hash cache: %w", err)
+	}
+	err = cache.openLog()
+	unlockFile(lock)
+	if err != nil {
+		lock.Close()
+		return cache, err
 	}
+	cache.lock = lock
 
-	if err = json.Unmarshal(data, &cache.Entries); err != nil {
-		return cache, fmt.Errorf("failed to parse hash cache file: %w", err)
+	legacyPath := filepath.Join(filepath.Dir(cache.path), HASH_CACHE_LEGACY_FILE)
+	if _, err := os.Stat(legacyPath); err == nil {
+		cache.importLegacyCache(legacyPath)
 	}
 
 	return cache, nil
@@ -162,3 +277,13 @@ func GetFileInfo(path string) (DuplicateFileInfo, error) {
 
 	return fileInfo, nil
 }
+
+// resolveIdentity fills Volume and FileID, which key the hash cache. It is
+// only called for files that need hashing, since it may open the file.
+func (f *DuplicateFileInfo) resolveIdentity() {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return
+	}
+	f.Volume, f.FileID, _ = fileIdentity(f.Path, info)
+}
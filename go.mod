@@ -4,7 +4,13 @@ go 1.24.4
 
 require (
 	github.com/StackExchange/wmi v1.2.1
+	github.com/zeebo/blake3 v0.2.4
+	github.com/zeebo/xxh3 v1.1.0
 	golang.org/x/sys v0.34.0
+	golang.org/x/text v0.26.0
 )
 
-require github.com/go-ole/go-ole v1.2.5 // indirect
+require (
+	github.com/go-ole/go-ole v1.2.5 // indirect
+	github.com/klauspost/cpuid/v2 v2.2.10 // indirect
+)
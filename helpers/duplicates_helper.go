@@ -16,12 +16,14 @@ import (
 // Format of the file list should be:
 // <hash>|<path>|<size>|<modtime>
 // Where:
-//   - hash is the full hash of the file
+//   - hash is the full hash of the file, optionally qualified with its
+//     algorithm (md5:..., sha256:..., xxh3:..., blake3:...)
 //   - path is the absolute path to the file
 //   - size is the file size in bytes (optional)
 //   - modtime is the modification time in format "2006-01-02 15:04:05" (optional)
 //
-// Or the format created by FileDO's duplicate check command:
+// Or a JSON report created with "cd ... list file.json", or the format
+// created by FileDO's duplicate check command:
 //   - path/to/file (original file)
 //     path/to/duplicate (duplicate file)
 func CheckDuplicatesFromFile(args []string) error {
@@ -34,7 +36,10 @@ func CheckDuplicatesFromFile(args []string) error {
 	filePath := args[2]
 
 	// Skip the first three arguments (from, list, file_path)
-	options := fileduplicates.ParseArguments(args[3:])
+	options, err := fileduplicates.ParseArguments(args[3:])
+	if err != nil {
+		return err
+	}
 
 	// If deletion is requested but no selection mode is specified, use "new" as default
 	if options.Action == fileduplicates.DeleteAction &&
@@ -47,12 +52,25 @@ func CheckDuplicatesFromFile(args []string) error {
 
 	// Determine file format based on extension or content
 	isStandardFormat := false
-	if strings.HasSuffix(strings.ToLower(filePath), ".lst") {
+	if strings.HasSuffix(strings.ToLower(filePath), ".json") {
+		// JSON report written by "cd ... list file.json"
+		duplicateGroups, err := fileduplicates.LoadJSONReport(filePath)
+		if err != nil {
+			return fmt.Errorf("error reading duplicate report: %v", err)
+		}
+
+		return fileduplicates.ProcessDuplicateGroupsFromList(duplicateGroups, options)
+	} else if strings.HasSuffix(strings.ToLower(filePath), ".lst") {
 		// Assume it's a FileDO duplicate list format
-		duplicateGroups, err := readDuplicateListFormat(filePath)
+		duplicateGroups, keepMarked, err := readDuplicateListFormat(filePath)
 		if err != nil {
 			return fmt.Errorf("error reading duplicate list: %v", err)
 		}
+		if keepMarked {
+			// Reviewed plan: the files marked "*" are kept
+			fmt.Println("Reviewed plan: keeping the marked files, selection mode is ignored")
+			options.KeepMarked = true
+		}
 
 		// Process the duplicate groups with the provided options
 		return fileduplicates.ProcessDuplicateGroupsFromList(duplicateGroups, options)
@@ -85,13 +103,22 @@ func CheckDuplicatesFromFile(args []string) error {
 				continue // Skip invalid entries
 			}
 
-			hash := parts[0]
+			algorithm, hexHash, err := fileduplicates.ParseHash(parts[0])
+			if err != nil {
+				fmt.Printf("Warning: %v in %s, skipping\n", err, filePath)
+				continue
+			}
 			path := parts[1]
 
+			// Group key carries the algorithm so digests of different
+			// algorithms never end up in the same group
+			hash := fileduplicates.FormatHash(algorithm, hexHash)
+
 			// Create file info
 			fileInfo := fileduplicates.DuplicateFileInfo{
-				Path:     path,
-				FullHash: hash,
+				Path:      path,
+				FullHash:  hexHash,
+				Algorithm: algorithm,
 			}
 
 			// Try to parse size if available
@@ -132,22 +159,27 @@ func CheckDuplicatesFromFile(args []string) error {
 	return nil
 }
 
-// readDuplicateListFormat reads a FileDO duplicate list file
+// readDuplicateListFormat reads a FileDO duplicate list file. Groups are keyed
+// by their position, so groups sharing a hash stay separate. It also reports
+// whether the list is a reviewed plan whose "*" marks must be kept.
 // Format:
 // # Group 1 (2 files, 0.01 MB each)
+// # Hash: sha256:<digest> (optional)
 //   - path/to/file (original file)
 //     path/to/duplicate (duplicate file)
-func readDuplicateListFormat(filePath string) (map[string][]fileduplicates.DuplicateFileInfo, error) {
+func readDuplicateListFormat(filePath string) (map[string][]fileduplicates.DuplicateFileInfo, bool, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open duplicate list: %w", err)
+		return nil, false, fmt.Errorf("failed to open duplicate list: %w", err)
 	}
 	defer file.Close()
 
 	duplicateGroups := make(map[string][]fileduplicates.DuplicateFileInfo)
 	var currentGroup []fileduplicates.DuplicateFileInfo
 	var currentHash string
+	var currentAlgorithm fileduplicates.HashAlgorithm
 	groupIndex := 0
+	keepMarked := false
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
@@ -155,15 +187,28 @@ func readDuplicateListFormat(filePath string) (map[string][]fileduplicates.Dupli
 
 		// Skip empty lines and header lines
 		if line == "" || strings.HasPrefix(line, "# ") {
+			if line == fileduplicates.PLAN_MARKER {
+				keepMarked = true
+				continue
+			}
+			// Per-group hash written by lists of newer versions
+			if strings.HasPrefix(line, "# Hash: ") {
+				if algorithm, hexHash, err := fileduplicates.ParseHash(strings.TrimPrefix(line, "# Hash: ")); err == nil {
+					currentAlgorithm = algorithm
+					currentHash = fileduplicates.FormatHash(algorithm, hexHash)
+				}
+				continue
+			}
 			// Check if this is a new group header
 			if strings.Contains(line, "Group") && strings.Contains(line, "files") {
 				// New group, save the previous one if it exists
 				if len(currentGroup) > 1 {
-					groupIndex++
 					duplicateGroups[fmt.Sprintf("group_%d", groupIndex)] = currentGroup
 				}
+				groupIndex++
 				currentGroup = []fileduplicates.DuplicateFileInfo{}
-				currentHash = fmt.Sprintf("group_%d", groupIndex+1)
+				currentHash = ""
+				currentAlgorithm = ""
 			}
 			continue
 		}
@@ -199,13 +244,16 @@ func readDuplicateListFormat(filePath string) (map[string][]fileduplicates.Dupli
 			continue
 		}
 
-		// Create duplicate file info
+		// Create duplicate file info. Lists without a "# Hash:" line leave
+		// the hash empty; it is computed where it is needed
 		fileInfo := fileduplicates.DuplicateFileInfo{
 			Path:       path,
 			Size:       info.Size(),
 			ModTime:    info.ModTime(),
 			IsOriginal: isOriginal,
-			FullHash:   currentHash, // Use group index as hash since we don't have actual hash
+			FullHash:   strings.TrimPrefix(currentHash, string(currentAlgorithm)+":"),
+			Algorithm:  currentAlgorithm,
+			IsDir:      info.IsDir(), // Groups of duplicate folders
 		}
 
 		currentGroup = append(currentGroup, fileInfo)
@@ -213,16 +261,16 @@ func readDuplicateListFormat(filePath string) (map[string][]fileduplicates.Dupli
 
 	// Add the last group if it exists
 	if len(currentGroup) > 1 {
-		duplicateGroups[currentHash] = currentGroup
+		duplicateGroups[fmt.Sprintf("group_%d", groupIndex)] = currentGroup
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading duplicate list: %w", err)
+		return nil, false, fmt.Errorf("error reading duplicate list: %w", err)
 	}
 
 	if len(duplicateGroups) == 0 {
-		return nil, fmt.Errorf("no duplicate groups found in file")
+		return nil, false, fmt.Errorf("no duplicate groups found in file")
 	}
 
-	return duplicateGroups, nil
+	return duplicateGroups, keepMarked, nil
 }